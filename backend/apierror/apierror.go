@@ -0,0 +1,161 @@
+// Package apierror defines the errors the API reports to clients. Every
+// error response carries the same JSON envelope:
+//
+//	{"error": {"code": "not_found", "message": "...", "requestId": "...", "details": {...}}}
+//
+// Codes are stable and meant for programs; messages are for people and
+// may change.
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"strategic-insight-analyst/blobstore"
+	"strategic-insight-analyst/repository"
+	"strategic-insight-analyst/utils"
+)
+
+type Code string
+
+const (
+	CodeInvalidRequest       Code = "invalid_request"
+	CodeUnauthenticated      Code = "unauthenticated"
+	CodeForbidden            Code = "forbidden"
+	CodeNotFound             Code = "not_found"
+	CodeMethodNotAllowed     Code = "method_not_allowed"
+	CodeConflict             Code = "conflict"
+	CodeRequestTooLarge      Code = "request_too_large"
+	CodeUnsupportedMediaType Code = "unsupported_media_type"
+	CodeExtractionFailed     Code = "extraction_failed"
+	CodeNotProcessed         Code = "document_not_processed"
+	CodeRateLimited          Code = "rate_limited"
+	CodeQuotaExceeded        Code = "quota_exceeded"
+	CodeInternal             Code = "internal"
+	CodeProviderUnavailable  Code = "provider_unavailable"
+	CodeUnavailable          Code = "unavailable"
+	CodeTimeout              Code = "timeout"
+)
+
+var statuses = map[Code]int{
+	CodeInvalidRequest:       http.StatusBadRequest,
+	CodeUnauthenticated:      http.StatusUnauthorized,
+	CodeForbidden:            http.StatusForbidden,
+	CodeNotFound:             http.StatusNotFound,
+	CodeMethodNotAllowed:     http.StatusMethodNotAllowed,
+	CodeConflict:             http.StatusConflict,
+	CodeRequestTooLarge:      http.StatusRequestEntityTooLarge,
+	CodeUnsupportedMediaType: http.StatusUnsupportedMediaType,
+	CodeExtractionFailed:     http.StatusUnprocessableEntity,
+	CodeNotProcessed:         http.StatusConflict,
+	CodeRateLimited:          http.StatusTooManyRequests,
+	CodeQuotaExceeded:        http.StatusTooManyRequests,
+	CodeInternal:             http.StatusInternalServerError,
+	CodeProviderUnavailable:  http.StatusBadGateway,
+	CodeUnavailable:          http.StatusServiceUnavailable,
+	CodeTimeout:              http.StatusGatewayTimeout,
+}
+
+// Status returns the HTTP status code for code.
+func (c Code) Status() int {
+	if s, ok := statuses[c]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is an error with a client-facing code and message. Cause, if set,
+// is logged but never sent to the client.
+type Error struct {
+	Code    Code
+	Message string
+	Details map[string]any
+	Cause   error
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap returns an Error that reports code and message to the client and
+// keeps cause for the logs.
+func Wrap(cause error, code Code, message string) *Error {
+	return &Error{Code: code, Message: message, Cause: cause}
+}
+
+// WithDetails returns a copy of e carrying details.
+func (e *Error) WithDetails(details map[string]any) *Error {
+	cp := *e
+	cp.Details = details
+	return &cp
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return string(e.Code) + ": " + e.Message + ": " + e.Cause.Error()
+	}
+	return string(e.Code) + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Cause }
+
+// From converts err to an Error, mapping the domain errors of the
+// repository and blob store to their codes. Anything unrecognised is an
+// internal error whose text stays out of the response.
+func From(err error) *Error {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, repository.ErrNotFound), errors.Is(err, blobstore.ErrNotFound):
+		return Wrap(err, CodeNotFound, "Resource not found")
+	case errors.Is(err, repository.ErrConflict):
+		return Wrap(err, CodeConflict, "Resource already exists")
+	case errors.Is(err, context.DeadlineExceeded):
+		return Wrap(err, CodeTimeout, "The request timed out")
+	default:
+		return Wrap(err, CodeInternal, "Internal server error")
+	}
+}
+
+type envelope struct {
+	Error body `json:"error"`
+}
+
+type body struct {
+	Code      Code           `json:"code"`
+	Message   string         `json:"message"`
+	RequestID string         `json:"requestId,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// Write sends err to the client as an error envelope. Server-side
+// failures are logged together with their cause.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	e := From(err)
+	status := e.Code.Status()
+	ctx := r.Context()
+	if status >= 500 {
+		slog.ErrorContext(ctx, e.Message, "code", e.Code, "error", e.Cause)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(envelope{body{
+		Code:      e.Code,
+		Message:   e.Message,
+		RequestID: utils.LogAttr(ctx, "request_id"),
+		Details:   e.Details,
+	}})
+}
+
+// Handler responds to every request with err; it suits router fallbacks
+// such as NotFoundHandler.
+func Handler(err *Error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, err)
+	})
+}
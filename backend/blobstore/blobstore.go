@@ -0,0 +1,34 @@
+// Package blobstore stores the original uploaded files. Production uses a
+// Supabase Storage bucket; the in-memory store is for tests and local
+// development.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when an object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// ErrSigningUnsupported is returned by stores that cannot mint signed URLs.
+var ErrSigningUnsupported = errors.New("signed URLs are not supported by this store")
+
+type Object struct {
+	Path      string
+	CreatedAt time.Time
+}
+
+type Store interface {
+	Upload(ctx context.Context, path string, data []byte, contentType string) error
+	Download(ctx context.Context, path string) ([]byte, error)
+	Remove(ctx context.Context, paths ...string) error
+	// SignedURL returns a URL from which the object can be downloaded
+	// without further authorization until expiry has passed.
+	SignedURL(ctx context.Context, path string, expiry time.Duration) (string, error)
+	// List returns every object whose path starts with prefix + "/".
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// Ping checks that the store is reachable and the bucket exists.
+	Ping(ctx context.Context) error
+}
@@ -0,0 +1,69 @@
+package blobstore
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryStore struct {
+	mu      sync.Mutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	data      []byte
+	createdAt time.Time
+}
+
+// NewMemory returns a Store that keeps objects in process memory.
+func NewMemory() Store {
+	return &memoryStore{objects: make(map[string]memoryObject)}
+}
+
+func (s *memoryStore) Upload(ctx context.Context, path string, data []byte, contentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[path] = memoryObject{data: append([]byte(nil), data...), createdAt: time.Now()}
+	return nil
+}
+
+func (s *memoryStore) Download(ctx context.Context, path string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[path]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), obj.data...), nil
+}
+
+func (s *memoryStore) Remove(ctx context.Context, paths ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range paths {
+		delete(s.objects, p)
+	}
+	return nil
+}
+
+func (s *memoryStore) SignedURL(ctx context.Context, path string, expiry time.Duration) (string, error) {
+	return "", ErrSigningUnsupported
+}
+
+func (s *memoryStore) List(ctx context.Context, prefix string) ([]Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var objects []Object
+	for p, obj := range s.objects {
+		if strings.HasPrefix(p, prefix+"/") {
+			objects = append(objects, Object{Path: p, CreatedAt: obj.createdAt})
+		}
+	}
+	return objects, nil
+}
+
+func (s *memoryStore) Ping(ctx context.Context) error {
+	return nil
+}
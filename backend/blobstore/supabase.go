@@ -0,0 +1,128 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"strategic-insight-analyst/utils"
+
+	storage "github.com/supabase-community/storage-go"
+)
+
+// listPageSize is the page size used when listing a folder.
+const listPageSize = 1000
+
+type supabaseStore struct {
+	cfg utils.StorageConfig
+}
+
+// NewSupabase returns a Store backed by the configured Supabase bucket.
+func NewSupabase(cfg utils.StorageConfig) Store {
+	return &supabaseStore{cfg: cfg}
+}
+
+// client returns a fresh client for each operation: the Supabase client
+// keeps per-request options in shared headers, so it must not be reused
+// concurrently.
+func (s *supabaseStore) client() *storage.Client {
+	return storage.NewClient(s.cfg.SupabaseURL+"/storage/v1", s.cfg.ServiceRoleKey.Value(), nil)
+}
+
+func (s *supabaseStore) Upload(ctx context.Context, path string, data []byte, contentType string) error {
+	opts := storage.FileOptions{}
+	if contentType != "" {
+		opts.ContentType = &contentType
+	}
+	_, err := s.client().UploadFile(s.cfg.Bucket, path, bytes.NewReader(data), opts)
+	return err
+}
+
+func (s *supabaseStore) Download(ctx context.Context, path string) ([]byte, error) {
+	data, err := s.client().DownloadFile(s.cfg.Bucket, path)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *supabaseStore) Remove(ctx context.Context, paths ...string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	_, err := s.client().RemoveFile(s.cfg.Bucket, paths)
+	return err
+}
+
+func (s *supabaseStore) SignedURL(ctx context.Context, path string, expiry time.Duration) (string, error) {
+	seconds := int((expiry + time.Second - 1) / time.Second)
+	resp, err := s.client().CreateSignedUrl(s.cfg.Bucket, path, seconds)
+	if isNotFound(err) {
+		return "", ErrNotFound
+	}
+	return resp.SignedURL, err
+}
+
+func (s *supabaseStore) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	for offset := 0; ; offset += listPageSize {
+		files, err := s.client().ListFiles(s.cfg.Bucket, prefix, storage.FileSearchOptions{
+			Limit:  listPageSize,
+			Offset: offset,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.Id == "" {
+				continue // sub-folder placeholder
+			}
+			created, _ := time.Parse(time.RFC3339, f.CreatedAt)
+			objects = append(objects, Object{Path: prefix + "/" + f.Name, CreatedAt: created})
+		}
+		if len(files) < listPageSize {
+			return objects, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *supabaseStore) Ping(ctx context.Context) error {
+	_, err := s.client().GetBucket(s.cfg.Bucket)
+	return err
+}
+
+// ErrPublicBucket is returned by EnsurePrivate for a public bucket it was
+// not allowed to change.
+var ErrPublicBucket = errors.New("bucket is public: stored documents can be read without authorization")
+
+// EnsurePrivate checks that the configured bucket does not serve objects
+// publicly. A public bucket is made private when cfg.MakeBucketPrivate is
+// set, and changed reports that it was; otherwise ErrPublicBucket is
+// returned.
+func EnsurePrivate(ctx context.Context, cfg utils.StorageConfig) (changed bool, err error) {
+	client := storage.NewClient(cfg.SupabaseURL+"/storage/v1", cfg.ServiceRoleKey.Value(), nil)
+	bucket, err := client.GetBucket(cfg.Bucket)
+	if err != nil || !bucket.Public {
+		return false, err
+	}
+	if !cfg.MakeBucketPrivate {
+		return false, ErrPublicBucket
+	}
+	if _, err := client.UpdateBucket(cfg.Bucket, storage.BucketOptions{Public: false}); err != nil {
+		return false, fmt.Errorf("make bucket private: %w", err)
+	}
+	return true, nil
+}
+
+// isNotFound recognises a missing object. The storage API does not always
+// fill in the status, so the message is checked as well.
+func isNotFound(err error) bool {
+	se, ok := err.(*storage.StorageError)
+	return ok && (se.Status == 404 || strings.Contains(strings.ToLower(se.Message), "not found"))
+}
@@ -0,0 +1,83 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("strategic-insight-analyst/blobstore")
+
+type traced struct {
+	Store
+}
+
+// WithTracing wraps s so that every operation is recorded as a span.
+func WithTracing(s Store) Store {
+	return traced{s}
+}
+
+func startSpan(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "blobstore."+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+func endSpan(span trace.Span, err error) {
+	// A missing object is an answer, not a failure of the store.
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t traced) Upload(ctx context.Context, path string, data []byte, contentType string) (err error) {
+	ctx, span := startSpan(ctx, "upload",
+		attribute.String("blob.path", path),
+		attribute.Int("blob.size", len(data)),
+		attribute.String("blob.content_type", contentType))
+	defer func() { endSpan(span, err) }()
+	return t.Store.Upload(ctx, path, data, contentType)
+}
+
+func (t traced) Download(ctx context.Context, path string) (data []byte, err error) {
+	ctx, span := startSpan(ctx, "download", attribute.String("blob.path", path))
+	defer func() {
+		span.SetAttributes(attribute.Int("blob.size", len(data)))
+		endSpan(span, err)
+	}()
+	return t.Store.Download(ctx, path)
+}
+
+func (t traced) Remove(ctx context.Context, paths ...string) (err error) {
+	ctx, span := startSpan(ctx, "remove", attribute.StringSlice("blob.paths", paths))
+	defer func() { endSpan(span, err) }()
+	return t.Store.Remove(ctx, paths...)
+}
+
+func (t traced) SignedURL(ctx context.Context, path string, expiry time.Duration) (url string, err error) {
+	ctx, span := startSpan(ctx, "signed_url",
+		attribute.String("blob.path", path),
+		attribute.String("blob.expiry", expiry.String()))
+	defer func() { endSpan(span, err) }()
+	return t.Store.SignedURL(ctx, path, expiry)
+}
+
+func (t traced) List(ctx context.Context, prefix string) (objs []Object, err error) {
+	ctx, span := startSpan(ctx, "list", attribute.String("blob.prefix", prefix))
+	defer func() {
+		span.SetAttributes(attribute.Int("blob.count", len(objs)))
+		endSpan(span, err)
+	}()
+	return t.Store.List(ctx, prefix)
+}
+
+func (t traced) Ping(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "ping")
+	defer func() { endSpan(span, err) }()
+	return t.Store.Ping(ctx)
+}
@@ -0,0 +1,136 @@
+// Command chunkbench measures how fast document chunks are persisted. It
+// compares the repository's Create (batched INSERT / COPY inside one
+// transaction) with the old one-INSERT-per-chunk approach.
+//
+// Run it against a disposable local database, never production:
+//
+//	go run ./cmd/chunkbench -database-url postgres://localhost/insight_bench?sslmode=disable
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"strategic-insight-analyst/repository"
+	"strategic-insight-analyst/utils"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+)
+
+func main() {
+	dbURL := flag.String("database-url", os.Getenv("BENCH_DATABASE_URL"), "Postgres connection string of a disposable database")
+	sizes := flag.String("sizes", "1000,10000", "comma-separated chunk counts per document")
+	chunkLen := flag.Int("chunk-bytes", 2000, "bytes of text per chunk")
+	baseline := flag.Bool("baseline", true, "also benchmark one INSERT per chunk")
+	flag.Parse()
+
+	if *dbURL == "" {
+		log.Fatal("set -database-url or BENCH_DATABASE_URL")
+	}
+	db, err := sql.Open("postgres", *dbURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := utils.MigrateUp(ctx, db); err != nil {
+		log.Fatalf("migrate: %v", err)
+	}
+
+	userID := "chunkbench-" + uuid.New().String()
+	if _, err := db.ExecContext(ctx, "INSERT INTO users (id, email) VALUES ($1, $2)", userID, userID+"@bench.invalid"); err != nil {
+		log.Fatalf("create bench user: %v", err)
+	}
+	// Deleting the user cascades to every document created below; their
+	// chunk sets are then used by no version.
+	defer db.ExecContext(ctx, `
+		DELETE FROM chunk_sets s
+		WHERE NOT EXISTS (SELECT 1 FROM document_versions v WHERE v.chunk_set_id = s.id)`)
+	defer db.ExecContext(ctx, "DELETE FROM users WHERE id = $1", userID)
+
+	repos := repository.NewPostgres(db, repository.DefaultSearchLanguage)
+	chunk := strings.Repeat("x", *chunkLen)
+
+	fmt.Printf("%-10s %-12s %12s %14s\n", "chunks", "method", "ms/doc", "chunks/sec")
+	for _, field := range strings.Split(*sizes, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 {
+			log.Fatalf("invalid size %q", field)
+		}
+		chunks := make([]string, n)
+		for i := range chunks {
+			chunks[i] = chunk
+		}
+
+		report(n, "repository", testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				doc := benchDocument(userID)
+				if err := repos.Documents.Create(ctx, doc, chunks); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}))
+
+		if *baseline {
+			report(n, "per-row", testing.Benchmark(func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if err := perRowInsert(ctx, db, benchDocument(userID), chunks); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}))
+		}
+	}
+}
+
+func benchDocument(userID string) *repository.Document {
+	id := uuid.New().String()
+	return &repository.Document{
+		ID:          id,
+		UserID:      userID,
+		FileName:    "bench.txt",
+		StoragePath: "bench/" + id,
+		UploadedAt:  time.Now(),
+	}
+}
+
+// perRowInsert reproduces the original upload path, on the current
+// schema: one round trip per chunk, outside any transaction.
+func perRowInsert(ctx context.Context, db *sql.DB, doc *repository.Document, chunks []string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO documents (id, user_id, file_name, storage_path, uploaded_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		doc.ID, doc.UserID, doc.FileName, doc.StoragePath, doc.UploadedAt)
+	if err != nil {
+		return err
+	}
+	setID := uuid.New().String()
+	if _, err := db.ExecContext(ctx, "INSERT INTO chunk_sets (id, ref_count) VALUES ($1, 1)", setID); err != nil {
+		return err
+	}
+	for i, c := range chunks {
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO document_chunks (id, chunk_set_id, chunk_index, content)
+			VALUES ($1, $2, $3, $4)`, uuid.New().String(), setID, i, c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func report(chunks int, method string, r testing.BenchmarkResult) {
+	perDoc := time.Duration(r.NsPerOp())
+	rate := float64(chunks) / perDoc.Seconds()
+	fmt.Printf("%-10d %-12s %12.1f %14.0f\n", chunks, method, float64(perDoc.Microseconds())/1000, rate)
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/repository"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Audited actions.
+const (
+	AuditUserRegister      = "user.register"
+	AuditAuthFailure       = "auth.failure"
+	AuditDocumentUpload    = "document.upload"
+	AuditDocumentVersion   = "document.version"
+	AuditDocumentView      = "document.view"
+	AuditDocumentDownload  = "document.download"
+	AuditDocumentShare     = "document.share"
+	AuditDocumentInspect   = "document.inspect"
+	AuditDocumentUpdate    = "document.update"
+	AuditDocumentReprocess = "document.reprocess"
+	AuditDocumentDelete    = "document.delete"
+	AuditDocumentRestore   = "document.restore"
+	AuditDocumentPurge     = "document.purge"
+	AuditDocumentInsight   = "document.insight"
+	AuditDocumentChat      = "document.chat"
+)
+
+type AuditEvent = repository.AuditEvent
+
+// AuditLogger writes security-relevant and data-access events to the
+// append-only audit log.
+type AuditLogger struct {
+	events repository.AuditRepo
+}
+
+func NewAuditLogger(repos repository.Repos) *AuditLogger {
+	return &AuditLogger{events: repos.Audit}
+}
+
+type auditKey struct{}
+
+// auditRecord is shared between the outer audit middleware and the inner
+// hooks, which fill in what is only known once a route has been matched,
+// the caller authenticated, or the handler has run.
+type auditRecord struct {
+	mu         sync.Mutex
+	action     string
+	actorID    string
+	targetType string
+	targetID   string
+	details    map[string]string
+}
+
+func auditFrom(ctx context.Context) *auditRecord {
+	rec, _ := ctx.Value(auditKey{}).(*auditRecord)
+	return rec
+}
+
+// setAuditActor records the user responsible for the current request.
+func setAuditActor(ctx context.Context, actorID string) {
+	if rec := auditFrom(ctx); rec != nil {
+		rec.mu.Lock()
+		rec.actorID = actorID
+		rec.mu.Unlock()
+	}
+}
+
+// setAuditTarget records the object the current request acted on, for
+// handlers that only learn it while running (e.g. a new document's ID).
+func setAuditTarget(ctx context.Context, targetType, targetID string) {
+	if rec := auditFrom(ctx); rec != nil {
+		rec.mu.Lock()
+		rec.targetType, rec.targetID = targetType, targetID
+		rec.mu.Unlock()
+	}
+}
+
+// setAuditDetail attaches extra context to the current request's event.
+func setAuditDetail(ctx context.Context, key, value string) {
+	if rec := auditFrom(ctx); rec != nil {
+		rec.mu.Lock()
+		if rec.details == nil {
+			rec.details = make(map[string]string)
+		}
+		rec.details[key] = value
+		rec.mu.Unlock()
+	}
+}
+
+// auditAuthFailure marks the current request as rejected by authentication
+// or authorization, for reason. actorID is the caller when known. Requests
+// already marked by Action, such as registration, keep their action.
+func auditAuthFailure(ctx context.Context, actorID, reason string) {
+	if rec := auditFrom(ctx); rec != nil {
+		rec.mu.Lock()
+		if rec.action == "" {
+			rec.action = AuditAuthFailure
+		}
+		if actorID != "" {
+			rec.actorID = actorID
+		}
+		if rec.details == nil {
+			rec.details = make(map[string]string)
+		}
+		rec.details["reason"] = reason
+		rec.mu.Unlock()
+	}
+}
+
+// Middleware must wrap the whole router. It writes an event for every
+// request marked by Action or auditAuthFailure, and for every other
+// request rejected with 401.
+func (al *AuditLogger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &auditRecord{}
+		sw := newStatusRecorder(w)
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), auditKey{}, rec)))
+
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		action := rec.action
+		if action == "" {
+			if sw.status != http.StatusUnauthorized {
+				return
+			}
+			action = AuditAuthFailure
+		}
+
+		outcome := "success"
+		if sw.status >= 400 {
+			outcome = "failure"
+		}
+		ev := AuditEvent{
+			ID:         uuid.New().String(),
+			Action:     action,
+			Outcome:    outcome,
+			Status:     sw.status,
+			ActorID:    rec.actorID,
+			TargetType: rec.targetType,
+			TargetID:   rec.targetID,
+			IP:         clientIP(r),
+			UserAgent:  r.UserAgent(),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Details:    rec.details,
+			CreatedAt:  time.Now(),
+		}
+		// The client may already be gone; the event must still be written.
+		if err := al.Log(context.WithoutCancel(r.Context()), ev); err != nil {
+			slog.ErrorContext(r.Context(), "failed to write audit event", "action", ev.Action, "error", err)
+		}
+	})
+}
+
+// Action marks the wrapped handler's requests as audited. When targetVar
+// is set, the target ID is taken from that route variable.
+func (al *AuditLogger) Action(action, targetType, targetVar string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rec := auditFrom(r.Context()); rec != nil {
+			rec.mu.Lock()
+			rec.action = action
+			rec.targetType = targetType
+			if targetVar != "" {
+				rec.targetID = mux.Vars(r)[targetVar]
+			}
+			if userID, err := getUserID(r.Context()); err == nil {
+				rec.actorID = userID
+			}
+			rec.mu.Unlock()
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (al *AuditLogger) Log(ctx context.Context, ev AuditEvent) error {
+	return al.events.Append(ctx, ev)
+}
+
+// RequireAdmin rejects callers without admin access.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r.Context()) {
+			userID, _ := getUserID(r.Context())
+			auditAuthFailure(r.Context(), userID, "admin access required")
+			apierror.Write(w, r, apierror.New(apierror.CodeForbidden, "Admin access required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// auditQuery builds the filter shared by the list and export endpoints
+// from the request's query parameters.
+func auditQuery(r *http.Request) (repository.AuditFilter, error) {
+	q := r.URL.Query()
+	f := repository.AuditFilter{
+		Action:     q.Get("action"),
+		ActorID:    q.Get("actorId"),
+		TargetType: q.Get("targetType"),
+		TargetID:   q.Get("targetId"),
+		Outcome:    q.Get("outcome"),
+		IP:         q.Get("ip"),
+	}
+	for param, t := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		var err error
+		if *t, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, fmt.Errorf("invalid %s timestamp", param)
+		}
+	}
+	return f, nil
+}
+
+// ListAuditEvents returns audit events matching the filters, newest first,
+// one page at a time (limit/offset).
+func (al *AuditLogger) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	filter, err := auditQuery(r)
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInvalidRequest, err.Error()))
+		return
+	}
+
+	limit, offset := 50, 0
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 500 {
+		limit = v
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && v > 0 {
+		offset = v
+	}
+
+	events, total, err := al.events.List(ctx, filter, limit, offset)
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to query audit events"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events": events,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// ExportAuditEvents streams every matching event as JSON Lines, oldest
+// first.
+func (al *AuditLogger) ExportAuditEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	filter, err := auditQuery(r)
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInvalidRequest, err.Error()))
+		return
+	}
+
+	// Headers are sent with the first event, so that a failure before it
+	// can still be reported as an error response.
+	var enc *json.Encoder
+	start := func() {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit-events.jsonl"`)
+		enc = json.NewEncoder(w)
+	}
+	err = al.events.Export(ctx, filter, func(ev AuditEvent) error {
+		if enc == nil {
+			start()
+		}
+		return enc.Encode(ev)
+	})
+	switch {
+	case err != nil && enc == nil:
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to export audit events"))
+	case err != nil:
+		slog.ErrorContext(ctx, "audit export failed", "error", err)
+	case enc == nil:
+		start() // no matching events
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	ts := newTestServer(t, QuotaLimits{})
+	id := ts.upload(t, "alice", "report.txt", "Revenue grew in every region.")
+	ts.do(t, "GET", "/api/documents/"+id, "alice", nil, "User-Agent", "test-agent")
+	ts.do(t, "GET", "/api/documents/"+id, "bob", nil)
+	ts.do(t, "GET", "/api/documents/"+id, "", nil)
+	ts.do(t, "GET", "/api/trash", "alice", nil) // not audited
+
+	if w := ts.do(t, "GET", "/api/admin/audit", "alice", nil); w.Code != http.StatusForbidden {
+		t.Errorf("list as non-admin: status = %d, want 403", w.Code)
+	}
+
+	var list struct {
+		Events []AuditEvent `json:"events"`
+		Total  int          `json:"total"`
+	}
+	decode(t, ts.do(t, "GET", "/api/admin/audit", testAdmin, nil), &list)
+	want := []struct{ action, outcome, actor string }{
+		{AuditAuthFailure, "failure", "alice"},
+		{AuditAuthFailure, "failure", ""},
+		{AuditDocumentView, "failure", "bob"},
+		{AuditDocumentView, "success", "alice"},
+		{AuditDocumentUpload, "success", "alice"},
+	}
+	if list.Total != len(want) || len(list.Events) != len(want) {
+		t.Fatalf("events = %+v, want %d", list.Events, len(want))
+	}
+	for i, w := range want {
+		ev := list.Events[i]
+		if ev.Action != w.action || ev.Outcome != w.outcome || ev.ActorID != w.actor {
+			t.Errorf("event %d = %s/%s by %q, want %s/%s by %q",
+				i, ev.Action, ev.Outcome, ev.ActorID, w.action, w.outcome, w.actor)
+		}
+		if ev.Action != AuditAuthFailure && ev.TargetID != id {
+			t.Errorf("event %d: target = %q, want %q", i, ev.TargetID, id)
+		}
+	}
+	if ev := list.Events[0]; ev.Status != http.StatusForbidden || ev.Details["reason"] != "admin access required" {
+		t.Errorf("admin rejection event = %+v", ev)
+	}
+	if list.Events[3].UserAgent != "test-agent" || list.Events[3].Path != "/api/documents/"+id {
+		t.Errorf("view event = %+v", list.Events[3])
+	}
+
+	decode(t, ts.do(t, "GET", "/api/admin/audit?actorId=alice&limit=1&offset=2", testAdmin, nil), &list)
+	if list.Total != 3 || len(list.Events) != 1 || list.Events[0].Action != AuditDocumentUpload {
+		t.Errorf("filtered page = %+v (total %d)", list.Events, list.Total)
+	}
+	if w := ts.do(t, "GET", "/api/admin/audit?from=yesterday", testAdmin, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid from: status = %d, want 400", w.Code)
+	}
+
+	w := ts.do(t, "GET", "/api/admin/audit/export?action="+AuditDocumentView, testAdmin, nil)
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("export: Content-Type = %q", ct)
+	}
+	var actors []string
+	sc := bufio.NewScanner(strings.NewReader(w.Body.String()))
+	for sc.Scan() {
+		var ev AuditEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("export line %q: %v", sc.Text(), err)
+		}
+		actors = append(actors, ev.ActorID)
+	}
+	if strings.Join(actors, ",") != "alice,bob" {
+		t.Errorf("exported actors = %v, want alice,bob oldest first", actors)
+	}
+}
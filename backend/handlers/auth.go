@@ -2,23 +2,62 @@ package handlers
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strings"
 
-	"strategic-insight-analyst/utils" 
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/repository"
+	"strategic-insight-analyst/utils"
 
-	firebase "firebase.google.com/go/v4"
+	"firebase.google.com/go/v4/auth"
 )
 
 type contextKey string
 
-const userIDKey contextKey = "userID"
+const (
+	userIDKey contextKey = "userID"
+	adminKey  contextKey = "admin"
+)
+
+// TokenVerifier checks ID tokens; *auth.Client implements it.
+type TokenVerifier interface {
+	VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error)
+}
+
+// AuthOptions controls how verified identities are mapped onto user records.
+type AuthOptions struct {
+	// JITProvisioning creates the user record on the first authenticated
+	// request instead of requiring an explicit call to /api/register.
+	JITProvisioning bool
+	// RequireVerifiedEmail rejects tokens whose email has not been verified
+	// by the identity provider.
+	RequireVerifiedEmail bool
+	// AdminUserIDs are granted admin access in addition to users whose
+	// token carries the "admin" custom claim.
+	AdminUserIDs []string
+}
+
+func (o AuthOptions) isAdmin(token *auth.Token) bool {
+	if admin, _ := token.Claims["admin"].(bool); admin {
+		return true
+	}
+	for _, id := range o.AdminUserIDs {
+		if id == token.UID {
+			return true
+		}
+	}
+	return false
+}
 
-func AuthMiddleware(app *firebase.App, db *sql.DB) func(http.Handler) http.Handler {
+// acceptsEmail reports whether a token email with the given verification
+// state may be stored on a user record.
+func (o AuthOptions) acceptsEmail(verified bool) bool {
+	return verified || !o.RequireVerifiedEmail
+}
+
+func AuthMiddleware(verifier TokenVerifier, users repository.UserRepo, opts AuthOptions) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.URL.Path == "/api/login" || r.URL.Path == "/api/register" {
@@ -26,73 +65,142 @@ func AuthMiddleware(app *firebase.App, db *sql.DB) func(http.Handler) http.Handl
 				return
 			}
 
-			authHeader := r.Header.Get("Authorization")
-			if !strings.HasPrefix(authHeader, "Bearer ") {
-				http.Error(w, "Missing or invalid Authorization header", http.StatusUnauthorized)
-				return
-			}
-
-			idToken := strings.TrimPrefix(authHeader, "Bearer ")
-			idToken = strings.TrimSpace(idToken)
-			if idToken == "" {
-				http.Error(w, "ID token missing", http.StatusUnauthorized)
-				return
-			}
-
-			token, err := utils.VerifyIDToken(r.Context(), app, idToken)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Invalid token: %v", err), http.StatusUnauthorized)
+			token, authErr := verifyBearerToken(r, verifier)
+			if authErr != nil {
+				auditAuthFailure(r.Context(), "", authErr.Message)
+				apierror.Write(w, r, authErr)
 				return
 			}
+			email, verified := tokenEmail(token)
 
 			var userID string
-			err = db.QueryRowContext(r.Context(), "SELECT id FROM users WHERE id = $1", token.UID).Scan(&userID)
-			if err != nil {
-				if err == sql.ErrNoRows {
-					http.Error(w, "User not registered", http.StatusUnauthorized)
+			user, err := users.Get(r.Context(), token.UID)
+			switch {
+			case errors.Is(err, repository.ErrNotFound):
+				if !opts.JITProvisioning {
+					auditAuthFailure(r.Context(), token.UID, "user not registered")
+					apierror.Write(w, r, apierror.New(apierror.CodeUnauthenticated, "User not registered"))
+					return
+				}
+				if email == "" || !opts.acceptsEmail(verified) {
+					auditAuthFailure(r.Context(), token.UID, "verified email required")
+					apierror.Write(w, r, apierror.New(apierror.CodeForbidden, "Verified email required"))
 					return
 				}
-				log.Printf("Database error: %v", err)
-				http.Error(w, "Database error", http.StatusInternalServerError)
+				if err := users.Upsert(r.Context(), token.UID, email); err != nil {
+					if errors.Is(err, repository.ErrConflict) {
+						auditAuthFailure(r.Context(), token.UID, "email already registered")
+					}
+					apierror.Write(w, r, registrationError(err))
+					return
+				}
+				userID = token.UID
+			case err != nil:
+				apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to load user"))
 				return
+			default:
+				userID = user.ID
+				// The identity provider is the source of truth for the
+				// email address; keep our copy in sync when it changes,
+				// but never replace it with an address we would not
+				// have registered.
+				if email != "" && opts.acceptsEmail(verified) && !strings.EqualFold(email, user.Email) {
+					if err := users.UpdateEmail(r.Context(), userID, email); err != nil {
+						slog.WarnContext(r.Context(), "failed to sync user email", "error", err)
+					}
+				}
 			}
 
+			utils.AddLogAttrs(r.Context(), slog.String("user_id", userID))
 			ctx := context.WithValue(r.Context(), userIDKey, userID)
+			ctx = context.WithValue(ctx, adminKey, opts.isAdmin(token))
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// isAdmin reports whether the authenticated caller has admin access.
+func isAdmin(ctx context.Context) bool {
+	admin, _ := ctx.Value(adminKey).(bool)
+	return admin
+}
 
-func RegisterHandler(db *sql.DB) http.HandlerFunc {
+// RegisterHandler creates the user record for the caller's verified ID
+// token. The UID and email are taken from the token claims; anything the
+// client puts in the request body is ignored.
+func RegisterHandler(verifier TokenVerifier, users repository.UserRepo, opts AuthOptions) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			apierror.Write(w, r, errMethodNotAllowed)
+			return
+		}
+
+		token, authErr := verifyBearerToken(r, verifier)
+		if authErr != nil {
+			auditAuthFailure(r.Context(), "", authErr.Message)
+			apierror.Write(w, r, authErr)
 			return
 		}
+		setAuditActor(r.Context(), token.UID)
 
-		type reqBody struct {
-			ID    string `json:"id"`
-			Email string `json:"email"`
+		email, verified := tokenEmail(token)
+		if email == "" {
+			auditAuthFailure(r.Context(), "", "no email claim")
+			apierror.Write(w, r, apierror.New(apierror.CodeInvalidRequest, "Token has no email claim"))
+			return
 		}
-		var body reqBody
-		err := json.NewDecoder(r.Body).Decode(&body)
-		log.Printf("[REGISTER] Received: id=%s, email=%s", body.ID, body.Email)
-		if err != nil || body.ID == "" || body.Email == "" {
-			log.Printf("[REGISTER] Invalid request body: err=%v, id=%s, email=%s", err, body.ID, body.Email)
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if !opts.acceptsEmail(verified) {
+			auditAuthFailure(r.Context(), "", "email not verified")
+			apierror.Write(w, r, apierror.New(apierror.CodeForbidden, "Email address not verified"))
 			return
 		}
 
-		_, err = db.ExecContext(r.Context(), "INSERT INTO users (id, email) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING", body.ID, body.Email)
-		if err != nil {
-			log.Printf("[REGISTER] Failed to register user: %v", err)
-			http.Error(w, "Failed to register user", http.StatusInternalServerError)
+		if err := users.Upsert(r.Context(), token.UID, email); err != nil {
+			apierror.Write(w, r, registrationError(err))
 			return
 		}
 
-		log.Printf("[REGISTER] User registered successfully: id=%s, email=%s", body.ID, body.Email)
+		slog.InfoContext(r.Context(), "user registered", "user_id", token.UID)
 		w.WriteHeader(http.StatusCreated)
 		w.Write([]byte("User registered successfully"))
 	}
 }
+
+// registrationError reports a failed user upsert.
+func registrationError(err error) *apierror.Error {
+	if errors.Is(err, repository.ErrConflict) {
+		return apierror.Wrap(err, apierror.CodeConflict, "Email already registered")
+	}
+	return apierror.Wrap(err, apierror.CodeInternal, "Failed to register user")
+}
+
+// verifyBearerToken checks the Authorization header of r and returns the
+// verified token, or the error to send.
+func verifyBearerToken(r *http.Request, verifier TokenVerifier) (*auth.Token, *apierror.Error) {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return nil, apierror.New(apierror.CodeUnauthenticated, "Missing or invalid Authorization header")
+	}
+
+	idToken := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+	if idToken == "" {
+		return nil, apierror.New(apierror.CodeUnauthenticated, "ID token missing")
+	}
+
+	token, err := verifier.VerifyIDToken(r.Context(), idToken)
+	if err != nil {
+		// The verifier's error can quote token contents; keep it out of
+		// the response.
+		slog.WarnContext(r.Context(), "ID token verification failed", "error", err)
+		return nil, apierror.New(apierror.CodeUnauthenticated, "Invalid token")
+	}
+	return token, nil
+}
+
+// tokenEmail returns the email claim of token and whether the identity
+// provider has verified it.
+func tokenEmail(token *auth.Token) (string, bool) {
+	email, _ := token.Claims["email"].(string)
+	verified, _ := token.Claims["email_verified"].(bool)
+	return strings.TrimSpace(email), verified
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"strategic-insight-analyst/repository"
+
+	"firebase.google.com/go/v4/auth"
+	"github.com/gorilla/mux"
+)
+
+// fakeVerifier accepts the ID tokens it holds, as the tokens they decode to.
+type fakeVerifier map[string]*auth.Token
+
+func (v fakeVerifier) VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error) {
+	if token, ok := v[idToken]; ok {
+		return token, nil
+	}
+	return nil, errors.New("ID token has invalid signature")
+}
+
+// idToken returns a token for uid carrying email, verified or not; an
+// empty email leaves the claim out.
+func idToken(uid, email string, verified bool) *auth.Token {
+	claims := map[string]interface{}{"email_verified": verified}
+	if email != "" {
+		claims["email"] = email
+	}
+	return &auth.Token{UID: uid, Claims: claims}
+}
+
+var testTokens = fakeVerifier{
+	"alice":     idToken("alice", "alice@example.com", true),
+	"alice-new": idToken("alice", "alice@new.example.com", true),
+	"alice-unv": idToken("alice", "alice@unverified.example.com", false),
+	"bob":       idToken("bob", "bob@example.com", false),
+	"carol":     idToken("carol", "", true),
+	"mallory":   idToken("mallory", "alice@example.com", true),
+}
+
+// newAuthTestServer serves /api/register and, behind AuthMiddleware,
+// /api/me, which answers with the caller's user ID. Requests send the
+// token name as their bearer token.
+func newAuthTestServer(t *testing.T, opts AuthOptions) *testServer {
+	t.Helper()
+	ts := &testServer{repos: repository.NewMemory()}
+	audit := NewAuditLogger(ts.repos)
+	r := mux.NewRouter()
+	r.Use(audit.Middleware)
+	r.Handle("/api/register", audit.Action(AuditUserRegister, "user", "",
+		RegisterHandler(testTokens, ts.repos.Users, opts))).Methods("POST")
+	api := r.PathPrefix("/api").Subrouter()
+	api.Use(AuthMiddleware(testTokens, ts.repos.Users, opts))
+	api.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := getUserID(r.Context())
+		w.Write([]byte(userID))
+	}).Methods("GET")
+	ts.handler = r
+	return ts
+}
+
+// userEmail returns the stored email of userID, or "" if there is no such
+// user.
+func (ts *testServer) userEmail(t *testing.T, userID string) string {
+	t.Helper()
+	u, err := ts.repos.Users.Get(context.Background(), userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Email
+}
+
+func TestRegister(t *testing.T) {
+	ts := newAuthTestServer(t, AuthOptions{RequireVerifiedEmail: true})
+
+	// The email comes from the token; the body is ignored.
+	w := ts.do(t, "POST", "/api/register", "alice", map[string]any{"uid": "bob", "email": "evil@example.com"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register: status = %d, body %s", w.Code, w.Body)
+	}
+	if got := ts.userEmail(t, "alice"); got != "alice@example.com" {
+		t.Errorf("email = %q, want the token's", got)
+	}
+	if got := ts.userEmail(t, "bob"); got != "" {
+		t.Errorf("bob was registered from the request body")
+	}
+
+	tests := []struct {
+		name, token string
+		status      int
+	}{
+		{"invalid token", "forged", http.StatusUnauthorized},
+		{"no token", "", http.StatusUnauthorized},
+		{"no email claim", "carol", http.StatusBadRequest},
+		{"unverified email", "bob", http.StatusForbidden},
+		{"email of another user", "mallory", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := ts.do(t, "POST", "/api/register", tt.token, nil); w.Code != tt.status {
+				t.Errorf("status = %d, want %d, body %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+	if got := ts.userEmail(t, "bob"); got != "" {
+		t.Errorf("bob was registered with an unverified email")
+	}
+
+	ts = newAuthTestServer(t, AuthOptions{})
+	if w := ts.do(t, "POST", "/api/register", "bob", nil); w.Code != http.StatusCreated {
+		t.Errorf("unverified email, verification not required: status = %d", w.Code)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	ts := newAuthTestServer(t, AuthOptions{RequireVerifiedEmail: true})
+	if w := ts.do(t, "GET", "/api/me", "alice", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("unregistered user: status = %d, want 401", w.Code)
+	}
+	ts.do(t, "POST", "/api/register", "alice", nil)
+	if w := ts.do(t, "GET", "/api/me", "alice", nil); w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Errorf("registered user: status = %d, body %s", w.Code, w.Body)
+	}
+	if w := ts.do(t, "GET", "/api/me", "forged", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("invalid token: status = %d, want 401", w.Code)
+	}
+
+	ts = newAuthTestServer(t, AuthOptions{JITProvisioning: true, RequireVerifiedEmail: true})
+	if w := ts.do(t, "GET", "/api/me", "alice", nil); w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Errorf("JIT provisioning: status = %d, body %s", w.Code, w.Body)
+	}
+	if got := ts.userEmail(t, "alice"); got != "alice@example.com" {
+		t.Errorf("provisioned email = %q", got)
+	}
+	if w := ts.do(t, "GET", "/api/me", "bob", nil); w.Code != http.StatusForbidden {
+		t.Errorf("JIT with an unverified email: status = %d, want 403", w.Code)
+	}
+	if got := ts.userEmail(t, "bob"); got != "" {
+		t.Errorf("bob was provisioned with an unverified email")
+	}
+	if w := ts.do(t, "GET", "/api/me", "carol", nil); w.Code != http.StatusForbidden {
+		t.Errorf("JIT without an email: status = %d, want 403", w.Code)
+	}
+}
+
+func TestAuthEmailSync(t *testing.T) {
+	tests := []struct {
+		name    string
+		require bool
+		token   string
+		want    string
+	}{
+		{"verified change", true, "alice-new", "alice@new.example.com"},
+		{"unverified change, verification required", true, "alice-unv", "alice@example.com"},
+		{"unverified change, verification not required", false, "alice-unv", "alice@unverified.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newAuthTestServer(t, AuthOptions{RequireVerifiedEmail: tt.require})
+			ts.do(t, "POST", "/api/register", "alice", nil)
+			if w := ts.do(t, "GET", "/api/me", tt.token, nil); w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			if got := ts.userEmail(t, "alice"); got != tt.want {
+				t.Errorf("email = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+
+	"strategic-insight-analyst/apierror"
+)
+
+// Chunking strategies.
+const (
+	// chunkFixed cuts the text every Size bytes.
+	chunkFixed = "fixed"
+	// chunkParagraph packs whole paragraphs into chunks of up to Size
+	// bytes, cutting only paragraphs longer than that.
+	chunkParagraph = "paragraph"
+)
+
+// chunkSize is the number of bytes of extracted text stored per chunk
+// unless the caller chooses otherwise.
+const chunkSize = 2000
+
+// Bounds on a chosen chunk size, in bytes.
+const (
+	minChunkSize = 200
+	maxChunkSize = 8000
+)
+
+// chunker says how extracted text is split into chunks. Whatever the
+// strategy, the chunks partition the text: joined in order they give it
+// back unchanged, which versionText relies on. Chunks never split a UTF-8
+// sequence.
+type chunker struct {
+	Strategy string `json:"strategy"`
+	Size     int    `json:"size"`
+}
+
+var defaultChunker = chunker{Strategy: chunkFixed, Size: chunkSize}
+
+// withDefaults fills in the zero fields of c from defaultChunker and
+// checks the result.
+func (c chunker) withDefaults() (chunker, *apierror.Error) {
+	if c.Strategy == "" {
+		c.Strategy = defaultChunker.Strategy
+	}
+	if c.Size == 0 {
+		c.Size = defaultChunker.Size
+	}
+	if c.Strategy != chunkFixed && c.Strategy != chunkParagraph {
+		return c, apierror.New(apierror.CodeInvalidRequest, "chunker.strategy must be fixed or paragraph").
+			WithDetails(map[string]any{"strategy": c.Strategy})
+	}
+	if c.Size < minChunkSize || c.Size > maxChunkSize {
+		return c, apierror.New(apierror.CodeInvalidRequest,
+			"chunker.size must be between "+strconv.Itoa(minChunkSize)+" and "+strconv.Itoa(maxChunkSize)+" bytes")
+	}
+	return c, nil
+}
+
+func (c chunker) split(text string) []string {
+	if c.Strategy == chunkParagraph {
+		return splitParagraphs(text, c.Size)
+	}
+	return splitChunks(text, c.Size)
+}
+
+// splitChunks cuts content into chunks of at most size bytes, ending each
+// chunk early rather than inside a UTF-8 sequence.
+func splitChunks(content string, size int) []string {
+	var chunks []string
+	for start := 0; start < len(content); {
+		end := start + size
+		if end >= len(content) {
+			end = len(content)
+		} else {
+			for end > start+1 && !utf8.RuneStart(content[end]) {
+				end--
+			}
+		}
+		chunks = append(chunks, content[start:end])
+		start = end
+	}
+	return chunks
+}
+
+// paragraphBreak matches the blank lines ending a paragraph.
+var paragraphBreak = regexp.MustCompile(`\n\s*\n`)
+
+// splitParagraphs packs consecutive paragraphs, each with the blank lines
+// that follow it, into chunks of at most size bytes. A paragraph longer
+// than size is cut with splitChunks.
+func splitParagraphs(content string, size int) []string {
+	var chunks []string
+	start, end := 0, 0
+	for end < len(content) {
+		next := len(content)
+		if loc := paragraphBreak.FindStringIndex(content[end:]); loc != nil {
+			next = end + loc[1]
+		}
+		if next-start > size && end > start {
+			chunks = append(chunks, content[start:end])
+			start = end
+		}
+		if next-start > size {
+			chunks = append(chunks, splitChunks(content[start:next], size)...)
+			start = next
+		}
+		end = next
+	}
+	if end > start {
+		chunks = append(chunks, content[start:end])
+	}
+	return chunks
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"strategic-insight-analyst/apierror"
+
+	"github.com/gorilla/mux"
+)
+
+// CORSConfig controls cross-origin access and the security headers sent
+// with every API response.
+type CORSConfig struct {
+	// AllowedOrigins lists exact origins ("https://app.example.com"),
+	// wildcard subdomains ("https://*.example.com") or "*".
+	AllowedOrigins []string
+	AllowedHeaders []string
+	ExposedHeaders []string
+	// AllowCredentials lets pages read responses to credentialed requests.
+	// It is never granted to origins admitted only by "*", which would let
+	// any site read them.
+	AllowCredentials bool
+	// MaxAge is how long, in seconds, browsers may cache a preflight.
+	MaxAge int
+	// HSTSMaxAge enables Strict-Transport-Security when positive.
+	HSTSMaxAge int
+}
+
+// preflightMethods are probed against the router to find which methods a
+// path supports.
+var preflightMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete,
+}
+
+type originPattern struct {
+	scheme string
+	host   string // without any "*." prefix
+	port   string
+	any    bool // "*": every origin
+	suffix bool // "*.host": any subdomain of host
+}
+
+func parseOriginPattern(s string) (originPattern, bool) {
+	if s == "*" {
+		return originPattern{any: true}, true
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return originPattern{}, false
+	}
+	p := originPattern{scheme: strings.ToLower(u.Scheme), host: strings.ToLower(u.Hostname()), port: u.Port()}
+	if strings.HasPrefix(p.host, "*.") {
+		p.host = strings.TrimPrefix(p.host, "*.")
+		p.suffix = true
+	}
+	return p, true
+}
+
+func (p originPattern) matches(origin *url.URL) bool {
+	if p.any {
+		return true
+	}
+	host := strings.ToLower(origin.Hostname())
+	if strings.ToLower(origin.Scheme) != p.scheme || origin.Port() != p.port {
+		return false
+	}
+	if p.suffix {
+		// "*.example.com" matches sub.example.com but not example.com itself.
+		return strings.HasSuffix(host, "."+p.host)
+	}
+	return host == p.host
+}
+
+// CORS wraps router with CORS handling driven by cfg. Preflight responses
+// advertise only the methods that router actually serves for the path.
+func CORS(router *mux.Router, cfg CORSConfig) http.Handler {
+	var patterns []originPattern
+	for _, o := range cfg.AllowedOrigins {
+		if p, ok := parseOriginPattern(strings.TrimSpace(o)); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	allowedHeaders := make(map[string]bool)
+	for _, h := range cfg.AllowedHeaders {
+		allowedHeaders[strings.ToLower(h)] = true
+	}
+
+	// originAllowed reports whether origin may read responses, and
+	// whether it may do so with credentials.
+	originAllowed := func(origin string) (allowed, credentials bool) {
+		// Opaque origins ("null") are never allowed.
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return false, false
+		}
+		for _, p := range patterns {
+			if p.matches(u) {
+				allowed = true
+				if !p.any {
+					return true, cfg.AllowCredentials
+				}
+			}
+		}
+		return allowed, false
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setSecurityHeaders(w, cfg)
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" {
+			router.ServeHTTP(w, r)
+			return
+		}
+		allowed, credentials := originAllowed(origin)
+		if !allowed {
+			if preflight {
+				apierror.Write(w, r, apierror.New(apierror.CodeForbidden, "Origin not allowed"))
+				return
+			}
+			// Serve without CORS headers; the browser will block the
+			// response from reaching the page.
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		if credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(cfg.ExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(cfg.ExposedHeaders, ", "))
+			}
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+
+		methods := routeMethods(router, r)
+		if len(methods) == 0 {
+			apierror.Write(w, r, errRouteNotFound)
+			return
+		}
+		requested := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+		if !containsString(methods, requested) {
+			apierror.Write(w, r, apierror.New(apierror.CodeForbidden, "Method not allowed").
+				WithDetails(map[string]any{"allowedMethods": methods}))
+			return
+		}
+		for _, name := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "" && !allowedHeaders[name] {
+				apierror.Write(w, r, apierror.New(apierror.CodeForbidden, "Header not allowed: "+name))
+				return
+			}
+		}
+
+		h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(cfg.AllowedHeaders) > 0 {
+			h.Set("Access-Control-Allow-Headers", strings.Join(cfg.AllowedHeaders, ", "))
+		}
+		if cfg.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// routeMethods returns the methods router serves for r's path.
+func routeMethods(router *mux.Router, r *http.Request) []string {
+	var methods []string
+	for _, m := range preflightMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = m
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.MatchErr == nil {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+func setSecurityHeaders(w http.ResponseWriter, cfg CORSConfig) {
+	h := w.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("Referrer-Policy", "no-referrer")
+	// The API never serves HTML, so nothing may be loaded or framed.
+	h.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+	if cfg.HSTSMaxAge > 0 {
+		h.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(cfg.HSTSMaxAge)+"; includeSubDomains")
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func corsTestRouter() *mux.Router {
+	r := mux.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	r.HandleFunc("/api/documents", ok).Methods("GET", "POST")
+	r.HandleFunc("/api/documents/{id}", ok).Methods("GET", "DELETE")
+	return r
+}
+
+func TestCORS(t *testing.T) {
+	cfg := CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		ExposedHeaders:   []string{"X-Request-ID"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}
+	tests := []struct {
+		name    string
+		cfg     *CORSConfig // defaults to cfg
+		method  string
+		path    string
+		origin  string
+		reqMeth string // Access-Control-Request-Method
+		reqHdrs string // Access-Control-Request-Headers
+
+		status      int
+		allowOrigin string
+		credentials bool
+		allowMeths  string
+	}{
+		{
+			name: "same-origin request", method: "GET", path: "/api/documents",
+			status: http.StatusOK,
+		},
+		{
+			name: "allowed origin", method: "GET", path: "/api/documents", origin: "https://app.example.com",
+			status: http.StatusOK, allowOrigin: "https://app.example.com", credentials: true,
+		},
+		{
+			name: "disallowed origin is served without CORS headers", method: "GET", path: "/api/documents",
+			origin: "https://evil.example.net",
+			status: http.StatusOK,
+		},
+		{
+			name: "disallowed origin preflight", method: "OPTIONS", path: "/api/documents",
+			origin: "https://evil.example.net", reqMeth: "GET",
+			status: http.StatusForbidden,
+		},
+		{
+			name: "opaque origin", method: "OPTIONS", path: "/api/documents", origin: "null", reqMeth: "GET",
+			status: http.StatusForbidden,
+		},
+		{
+			name: "wildcard subdomain", method: "OPTIONS", path: "/api/documents",
+			origin: "https://tenant.example.org", reqMeth: "POST", reqHdrs: "content-type, authorization",
+			status: http.StatusNoContent, allowOrigin: "https://tenant.example.org", credentials: true,
+			allowMeths: "GET, POST",
+		},
+		{
+			name: "wildcard subdomain excludes the parent domain", method: "OPTIONS", path: "/api/documents",
+			origin: "https://example.org", reqMeth: "GET",
+			status: http.StatusForbidden,
+		},
+		{
+			name: "wildcard subdomain excludes lookalike domains", method: "OPTIONS", path: "/api/documents",
+			origin: "https://badexample.org", reqMeth: "GET",
+			status: http.StatusForbidden,
+		},
+		{
+			name: "wildcard subdomain requires the scheme", method: "OPTIONS", path: "/api/documents",
+			origin: "http://tenant.example.org", reqMeth: "GET",
+			status: http.StatusForbidden,
+		},
+		{
+			name: "method the route does not allow", method: "OPTIONS", path: "/api/documents",
+			origin: "https://app.example.com", reqMeth: "DELETE",
+			status: http.StatusForbidden, allowOrigin: "https://app.example.com", credentials: true,
+		},
+		{
+			name: "methods are per route", method: "OPTIONS", path: "/api/documents/abc",
+			origin: "https://app.example.com", reqMeth: "DELETE",
+			status: http.StatusNoContent, allowOrigin: "https://app.example.com", credentials: true,
+			allowMeths: "GET, DELETE",
+		},
+		{
+			name: "unknown route", method: "OPTIONS", path: "/api/nothing",
+			origin: "https://app.example.com", reqMeth: "GET",
+			status: http.StatusNotFound, allowOrigin: "https://app.example.com", credentials: true,
+		},
+		{
+			name: "header missing from the allow list", method: "OPTIONS", path: "/api/documents",
+			origin: "https://app.example.com", reqMeth: "GET", reqHdrs: "Authorization, X-Debug",
+			status: http.StatusForbidden, allowOrigin: "https://app.example.com", credentials: true,
+		},
+		{
+			name: "non-preflight OPTIONS reaches the router", method: "OPTIONS", path: "/api/documents",
+			origin: "https://app.example.com",
+			status: http.StatusMethodNotAllowed, allowOrigin: "https://app.example.com", credentials: true,
+		},
+		{
+			name:   "any origin never gets credentials",
+			cfg:    &CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			method: "GET", path: "/api/documents", origin: "https://anyone.example.net",
+			status: http.StatusOK, allowOrigin: "https://anyone.example.net",
+		},
+		{
+			name:   "listed origin keeps credentials next to any origin",
+			cfg:    &CORSConfig{AllowedOrigins: []string{"*", "https://app.example.com"}, AllowCredentials: true},
+			method: "GET", path: "/api/documents", origin: "https://app.example.com",
+			status: http.StatusOK, allowOrigin: "https://app.example.com", credentials: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cfg
+			if tt.cfg != nil {
+				c = *tt.cfg
+			}
+			router := corsTestRouter()
+			router.MethodNotAllowedHandler = MethodNotAllowed
+			handler := CORS(router, c)
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.reqMeth != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.reqMeth)
+			}
+			if tt.reqHdrs != "" {
+				req.Header.Set("Access-Control-Request-Headers", tt.reqHdrs)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			h := w.Result().Header
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allowOrigin)
+			}
+			if got := h.Get("Access-Control-Allow-Credentials") == "true"; got != tt.credentials {
+				t.Errorf("Access-Control-Allow-Credentials sent = %v, want %v", got, tt.credentials)
+			}
+			if got := h.Get("Access-Control-Allow-Methods"); got != tt.allowMeths {
+				t.Errorf("Access-Control-Allow-Methods = %q, want %q", got, tt.allowMeths)
+			}
+			// Responses vary by origin whether or not it is allowed, so
+			// that caches never serve one origin's answer to another.
+			if !slices.Contains(h.Values("Vary"), "Origin") {
+				t.Errorf("Vary = %q, want it to include Origin", h.Values("Vary"))
+			}
+			if tt.reqMeth != "" && tt.allowOrigin != "" &&
+				!slices.Contains(h.Values("Vary"), "Access-Control-Request-Method") {
+				t.Errorf("Vary = %q, want it to include Access-Control-Request-Method", h.Values("Vary"))
+			}
+			if tt.status == http.StatusNoContent && h.Get("Access-Control-Max-Age") != "600" {
+				t.Errorf("Access-Control-Max-Age = %q, want 600", h.Get("Access-Control-Max-Age"))
+			}
+		})
+	}
+}
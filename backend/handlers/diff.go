@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Passage change types.
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+	changeChanged = "changed"
+)
+
+// passageChange is one difference between two versions of a text. From
+// and FromIndex describe the passage in the old version, To and ToIndex in
+// the new one; a change of type added has no old side and removed no new.
+type passageChange struct {
+	Type      string `json:"type"`
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+	FromIndex *int   `json:"fromIndex,omitempty"`
+	ToIndex   *int   `json:"toIndex,omitempty"`
+}
+
+type diffSummary struct {
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Changed   int `json:"changed"`
+	Unchanged int `json:"unchanged"`
+}
+
+// maxDiffCells bounds the LCS table built by diffPassages, and with it the
+// memory one comparison may use (2 bytes per cell).
+const maxDiffCells = 16_000_000
+
+var errDiffTooLarge = errors.New("texts are too large to compare")
+
+// changedSimilarity is the word overlap from which a removed passage
+// followed by an added one is reported as a single changed passage.
+const changedSimilarity = 0.5
+
+var blankLines = regexp.MustCompile(`\n[ \t\f\r]*\n`)
+
+// splitPassages splits text into paragraphs at blank lines, with runs of
+// whitespace inside each collapsed to one space.
+func splitPassages(text string) []string {
+	var passages []string
+	for _, p := range blankLines.Split(text, -1) {
+		if p = strings.Join(strings.Fields(p), " "); p != "" {
+			passages = append(passages, p)
+		}
+	}
+	return passages
+}
+
+// diffPassages compares two passage lists and returns the changes that
+// turn a into b, in document order, with a summary.
+func diffPassages(a, b []string) ([]passageChange, diffSummary, error) {
+	var summary diffSummary
+
+	// Passages shared at both ends need no table.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	summary.Unchanged = prefix + suffix
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(ma), len(mb)
+	if (n+1)*(m+1) > maxDiffCells {
+		return nil, summary, errDiffTooLarge
+	}
+
+	// lcs[i*(m+1)+j] is the length of the longest common subsequence of
+	// ma[i:] and mb[j:].
+	lcs := make([]uint16, (n+1)*(m+1))
+	at := func(i, j int) int { return int(lcs[i*(m+1)+j]) }
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case ma[i] == mb[j]:
+				lcs[i*(m+1)+j] = uint16(at(i+1, j+1) + 1)
+			case at(i+1, j) >= at(i, j+1):
+				lcs[i*(m+1)+j] = uint16(at(i+1, j))
+			default:
+				lcs[i*(m+1)+j] = uint16(at(i, j+1))
+			}
+		}
+	}
+
+	var changes []passageChange
+	var removed, added []int
+	// flush reports the pending run of removals and additions, pairing
+	// similar passages at the same position in the run as changed.
+	flush := func() {
+		for k := 0; k < len(removed) || k < len(added); k++ {
+			switch {
+			case k < len(removed) && k < len(added) &&
+				similarity(ma[removed[k]], mb[added[k]]) >= changedSimilarity:
+				from, to := prefix+removed[k], prefix+added[k]
+				changes = append(changes, passageChange{Type: changeChanged,
+					From: ma[removed[k]], To: mb[added[k]], FromIndex: &from, ToIndex: &to})
+				summary.Changed++
+			default:
+				if k < len(removed) {
+					from := prefix + removed[k]
+					changes = append(changes, passageChange{Type: changeRemoved, From: ma[removed[k]], FromIndex: &from})
+					summary.Removed++
+				}
+				if k < len(added) {
+					to := prefix + added[k]
+					changes = append(changes, passageChange{Type: changeAdded, To: mb[added[k]], ToIndex: &to})
+					summary.Added++
+				}
+			}
+		}
+		removed, added = removed[:0], added[:0]
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && ma[i] == mb[j]:
+			flush()
+			summary.Unchanged++
+			i++
+			j++
+		case j == m || (i < n && at(i+1, j) >= at(i, j+1)):
+			removed = append(removed, i)
+			i++
+		default:
+			added = append(added, j)
+			j++
+		}
+	}
+	flush()
+	return changes, summary, nil
+}
+
+// similarity is the Jaccard index of the word sets of a and b.
+func similarity(a, b string) float64 {
+	words := make(map[string]int)
+	for _, w := range strings.Fields(strings.ToLower(a)) {
+		words[w] |= 1
+	}
+	for _, w := range strings.Fields(strings.ToLower(b)) {
+		words[w] |= 2
+	}
+	if len(words) == 0 {
+		return 1
+	}
+	both := 0
+	for _, in := range words {
+		if in == 3 {
+			both++
+		}
+	}
+	return float64(both) / float64(len(words))
+}
@@ -3,126 +3,184 @@ package handlers
 import (
 	"bytes"
 	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/blobstore"
+	"strategic-insight-analyst/repository"
 	"strategic-insight-analyst/utils"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
-	storage "github.com/supabase-community/storage-go"
 	"rsc.io/pdf"
 )
 
-var (
-	supabaseURL    = utils.GetEnv("SUPABASE_URL", "")
-	supabaseKey    = utils.GetEnv("SUPABASE_SERVICE_ROLE_KEY", "")
-	supabaseBucket = utils.GetEnv("SUPABASE_BUCKET", "")
-)
-
 type DocumentService struct {
-	db *sql.DB
+	documents  repository.DocumentRepo
+	chunks     repository.ChunkRepo
+	folders    repository.FolderRepo
+	blobs      blobstore.Store
+	storage    utils.StorageConfig
+	extraction utils.ExtractionConfig
+	// blobQueue holds the blobs of permanently deleted documents until
+	// they are removed; retention is how long documents stay in the trash.
+	blobQueue repository.BlobQueueRepo
+	retention time.Duration
+	// tagger suggests tags for new uploads; nil disables suggestions.
+	tagger TagSuggester
+	// workers tracks background work started by requests, so that
+	// shutdown can wait for it once the server stops taking requests.
+	workers *sync.WaitGroup
 }
 
-func NewDocumentService(db *sql.DB) *DocumentService {
-	return &DocumentService{db: db}
+func NewDocumentService(repos repository.Repos, blobs blobstore.Store, storage utils.StorageConfig, extraction utils.ExtractionConfig, trash utils.TrashConfig, tagger TagSuggester, workers *sync.WaitGroup) *DocumentService {
+	return &DocumentService{
+		documents:  repos.Documents,
+		chunks:     repos.Chunks,
+		folders:    repos.Folders,
+		blobs:      blobs,
+		storage:    storage,
+		extraction: extraction,
+		blobQueue:  repos.BlobQueue,
+		retention:  trash.Retention,
+		tagger:     tagger,
+		workers:    workers,
+	}
 }
 
-type Document struct {
-	ID         string    `json:"id"`
-	UserID     string    `json:"userId"`
-	FileName   string    `json:"fileName"`
-	StorageURL string    `json:"storageUrl"`
-	UploadedAt time.Time `json:"uploadedAt"`
-}
+type Document = repository.Document
 
-func (ds *DocumentService) UploadDocument(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	userIDVal := ctx.Value(userIDKey)
-	userID, ok := userIDVal.(string)
-	if !ok || userID == "" {
-		http.Error(w, "Unauthorized: userID missing in context", http.StatusUnauthorized)
-		return
+// documentLookupError reports a failed document lookup: not found when
+// the document does not exist or belongs to someone else, the error itself
+// when it is already an API error, otherwise an internal error described
+// by msg.
+func documentLookupError(err error, msg string) *apierror.Error {
+	var aerr *apierror.Error
+	if errors.As(err, &aerr) {
+		return aerr
 	}
-	log.Printf("UploadDocument: userID=%s", userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return apierror.Wrap(err, apierror.CodeNotFound, "Document not found")
+	}
+	return apierror.Wrap(err, apierror.CodeInternal, msg)
+}
+
+// errExtractionFailed reports that no usable text could be extracted from
+// a file. The cause may name internal tools and paths, so it is only
+// logged (by ingest), never sent to the client.
+func errExtractionFailed(err error) *apierror.Error {
+	return apierror.Wrap(err, apierror.CodeExtractionFailed, "No text could be extracted from the document")
+}
+
+// documentPrefix is the storage folder holding every uploaded original.
+const documentPrefix = "documents"
+
+var supportedExtensions = map[string]string{
+	".pdf": "application/pdf",
+	".txt": "text/plain; charset=utf-8",
+}
+
+// upload is a document file received in a multipart request.
+type upload struct {
+	fileName string
+	ext      string
+	// contentType is stored with the blob; mediaType was sniffed from the
+	// content.
+	contentType string
+	mediaType   string
+	data        []byte
+}
 
-	err := r.ParseMultipartForm(10 << 20)
+// uploadMemory is how much of an upload request is kept in memory while
+// it is parsed; the rest spills to temporary files.
+const uploadMemory = 10 << 20
+
+// readUpload reads the "document" file of a multipart request of at most
+// maxBytes and checks that it is of a supported type and that its content
+// matches its extension.
+func readUpload(w http.ResponseWriter, r *http.Request, maxBytes int64) (*upload, *apierror.Error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	err := r.ParseMultipartForm(uploadMemory)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, apierror.Wrap(err, apierror.CodeRequestTooLarge, "The document is too large").
+			WithDetails(map[string]any{"maxBytes": maxBytes})
+	}
 	if err != nil {
-		http.Error(w, "Unable to parse form", http.StatusBadRequest)
-		return
+		return nil, apierror.Wrap(err, apierror.CodeInvalidRequest, "Unable to parse multipart form")
 	}
 
 	file, handler, err := r.FormFile("document")
 	if err != nil {
-		http.Error(w, "Error retrieving the file", http.StatusBadRequest)
-		return
+		return nil, apierror.Wrap(err, apierror.CodeInvalidRequest, "Missing \"document\" file field")
 	}
 	defer file.Close()
 
-	fileExt := filepath.Ext(handler.Filename)
-	newFileName := uuid.New().String() + fileExt
-
-	var buf bytes.Buffer
-	if _, err := io.Copy(&buf, file); err != nil {
-		http.Error(w, "Unable to read file", http.StatusInternalServerError)
-		return
+	up := &upload{fileName: handler.Filename, ext: strings.ToLower(filepath.Ext(handler.Filename))}
+	contentType, ok := supportedExtensions[up.ext]
+	if !ok {
+		return nil, apierror.New(apierror.CodeUnsupportedMediaType,
+			"Unsupported file type: only PDF and TXT documents can be analyzed").
+			WithDetails(map[string]any{"extension": up.ext, "supported": []string{".pdf", ".txt"}})
 	}
+	up.contentType = contentType
 
-	client := storage.NewClient(supabaseURL+"/storage/v1", supabaseKey, nil)
-	uploadPath := "documents/" + newFileName
-
-	_, err = client.UploadFile(supabaseBucket, uploadPath, &buf)
+	up.data, err = io.ReadAll(file)
 	if err != nil {
-		log.Printf("Supabase upload error: %v", err)
-		http.Error(w, "Failed to upload to Supabase Storage", http.StatusInternalServerError)
-		return
+		return nil, apierror.Wrap(err, apierror.CodeInternal, "Unable to read file")
 	}
+	uploadSizeBytes.Observe(float64(len(up.data)))
 
-	downloaded, err := client.DownloadFile(supabaseBucket, uploadPath)
-	if err != nil {
-		log.Printf("Supabase download error: %v", err)
-		http.Error(w, "Failed to download file from Supabase Storage", http.StatusInternalServerError)
-		return
+	up.mediaType = sniffMediaType(up.data)
+	if !contentMatchesExtension(up.mediaType, up.ext) {
+		return nil, apierror.New(apierror.CodeUnsupportedMediaType,
+			"File content does not match its extension").
+			WithDetails(map[string]any{"extension": up.ext, "detected": up.mediaType})
 	}
+	return up, nil
+}
 
-	var textContent string
-	if fileExt == ".pdf" {
-
-		tmpFile, err := os.CreateTemp("", "*.pdf")
-		if err != nil {
-			http.Error(w, "Failed to create temp file", http.StatusInternalServerError)
-			return
-		}
-		defer os.Remove(tmpFile.Name())
-		if _, err := tmpFile.Write(downloaded); err != nil {
-			http.Error(w, "Failed to write temp file", http.StatusInternalServerError)
-			return
-		}
-		tmpFile.Close()
-		textContent, err = extractTextFromPDF(tmpFile.Name())
-		if err != nil {
-			http.Error(w, "Failed to extract text from PDF", http.StatusInternalServerError)
-			return
-		}
-	} else if fileExt == ".txt" {
-
-		textContent = string(downloaded)
+// describe records on doc the metadata that comes from the file itself.
+func (up *upload) describe(doc *Document) {
+	doc.FileName = up.fileName
+	doc.SizeBytes = int64(len(up.data))
+	doc.MimeType = up.mediaType
+	doc.SHA256 = sha256Hex(up.data)
+	doc.PageCount = 0
+	if up.ext == ".pdf" {
+		doc.PageCount = countPages(up.data)
 	}
-	textContent = strings.ReplaceAll(textContent, "\x00", "")
+}
 
-	docID := uuid.New().String()
-	uploadedAt := time.Now()
+// ingest extracts the text of up, records on doc how it was obtained and
+// what it contains, and returns the chunks to store. opts chooses the
+// extractor and chunker; the zero value uses the defaults.
+func (ds *DocumentService) ingest(ctx context.Context, doc *Document, up *upload, opts ingestOptions) ([]string, error) {
+	if opts.Chunker == (chunker{}) {
+		opts.Chunker = defaultChunker
+	}
+	textContent, extractor, err := ds.extractText(ctx, up.ext, up.data, opts.Extractor)
+	if err == nil && strings.TrimSpace(textContent) == "" {
+		err = errors.New("No text could be extracted from the document")
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "text extraction failed", "document_id", doc.ID, "error", err)
+		return nil, err
+	}
 
 	garbled := false
 	asciiCount := 0
@@ -135,104 +193,273 @@ func (ds *DocumentService) UploadDocument(w http.ResponseWriter, r *http.Request
 		garbled = true
 	}
 	if garbled {
-		log.Printf("[WARNING] Extracted text may be garbled for docID=%s, fileName=%s", docID, handler.Filename)
+		slog.WarnContext(ctx, "extracted text may be garbled", "document_id", doc.ID)
 	}
 
-	_, err = ds.db.ExecContext(ctx, `
-		INSERT INTO documents (id, user_id, file_name, storage_path, uploaded_at)
-		VALUES ($1, $2, $3, $4, $5)`,
-		docID, userID, handler.Filename, uploadPath, uploadedAt)
-	if err != nil {
-		log.Printf("Database error (insert document): %v", err)
-		http.Error(w, "Error saving document to database", http.StatusInternalServerError)
+	doc.Status = repository.StatusReady
+	doc.ProcessingError = ""
+	doc.Extractor = extractor
+	doc.Language = detectLanguage(textContent)
+	doc.WordCount = countWords(textContent)
+	doc.ChunkStrategy = opts.Chunker.Strategy
+	doc.ChunkSize = opts.Chunker.Size
+	return opts.Chunker.split(textContent), nil
+}
+
+// ingestOptions chooses how a document is processed. An empty Extractor
+// tries every extractor for the file type in turn.
+type ingestOptions struct {
+	Extractor string  `json:"extractor"`
+	Chunker   chunker `json:"chunker"`
+}
+
+// check fills in the defaults of opts and checks that they apply to
+// files with extension ext.
+func (opts ingestOptions) check(ext, ocrAPIKey string) (ingestOptions, *apierror.Error) {
+	var aerr *apierror.Error
+	opts.Chunker, aerr = opts.Chunker.withDefaults()
+	if aerr != nil {
+		return opts, aerr
+	}
+	if opts.Extractor == "" {
+		return opts, nil
+	}
+	applicable := fileExtractors[ext]
+	if !slices.Contains(applicable, opts.Extractor) {
+		return opts, apierror.New(apierror.CodeInvalidRequest, "The extractor does not apply to this file type").
+			WithDetails(map[string]any{"extractor": opts.Extractor, "extension": ext, "supported": applicable})
+	}
+	if opts.Extractor == extractorOCRSpace && ocrAPIKey == "" {
+		return opts, apierror.New(apierror.CodeInvalidRequest, "OCR is not configured on this server")
+	}
+	return opts, nil
+}
+
+func (ds *DocumentService) UploadDocument(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userIDVal := ctx.Value(userIDKey)
+	userID, ok := userIDVal.(string)
+	if !ok || userID == "" {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	up, aerr := readUpload(w, r, ds.storage.MaxUploadBytes)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
 		return
 	}
 
-	if textContent != "" {
-		if err := ds.saveDocumentChunks(ctx, docID, textContent); err != nil {
-			log.Printf("Database error (saving chunks): %v", err)
-			http.Error(w, "Error saving document chunks", http.StatusInternalServerError)
+	docID := uuid.New().String()
+	setAuditTarget(ctx, "document", docID)
+	doc := &Document{
+		ID:         docID,
+		UserID:     userID,
+		UploadedAt: time.Now(),
+		Status:     repository.StatusReady,
+	}
+	up.describe(doc)
+
+	// The same content uploaded again returns the earlier document unless
+	// the caller asks for a separate copy, which then shares its blob and
+	// chunks instead of being stored and extracted again.
+	if existing, err := ds.documents.FindByHash(ctx, userID, doc.SHA256); err == nil {
+		if r.URL.Query().Get("allowDuplicate") != "true" {
+			setAuditTarget(ctx, "document", existing.ID)
+			ds.writeUpload(w, existing, existing.ID)
+			return
+		}
+		dup := *doc
+		dup.StoragePath = existing.StoragePath
+		dup.PageCount = existing.PageCount
+		dup.Language = existing.Language
+		dup.WordCount = existing.WordCount
+		dup.Extractor = existing.Extractor
+		dup.ChunkStrategy = existing.ChunkStrategy
+		dup.ChunkSize = existing.ChunkSize
+		dup.SuggestedTags = existing.SuggestedTags
+		err := ds.documents.CreateCopy(ctx, &dup, existing.ID)
+		if err == nil {
+			ds.writeUpload(w, &dup, existing.ID)
+			return
+		}
+		if !errors.Is(err, repository.ErrNotFound) {
+			apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to save document"))
 			return
 		}
+		// The original was deleted meanwhile; ingest this upload afresh.
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to check for duplicate documents"))
+		return
 	}
 
-	publicUrl := fmt.Sprintf("%s/storage/v1/object/public/%s/%s", supabaseURL, supabaseBucket, uploadPath)
+	chunks, err := ds.ingest(ctx, doc, up, ingestOptions{})
+	if err != nil {
+		// Keep the original so the failure can be inspected, but nothing
+		// is chunked. Clients see the processing error, so it gets the
+		// same fixed message as the response.
+		slog.ErrorContext(ctx, "document processing failed", "document_id", docID, "error", err)
+		aerr := errExtractionFailed(err)
+		doc.Status = repository.StatusFailed
+		doc.ProcessingError = aerr.Message
+		storeErr := ds.store(ctx, doc, up, func() error { return ds.documents.Create(ctx, doc, nil) })
+		if storeErr != nil {
+			slog.ErrorContext(ctx, "failed to record failed document", "document_id", docID, "error", storeErr)
+		} else {
+			aerr = aerr.WithDetails(map[string]any{"documentId": docID})
+		}
+		apierror.Write(w, r, aerr)
+		return
+	}
 
-	response := Document{
-		ID:         docID,
-		UserID:     userID,
-		FileName:   handler.Filename,
-		StorageURL: publicUrl,
-		UploadedAt: uploadedAt,
+	if err := ds.store(ctx, doc, up, func() error { return ds.documents.Create(ctx, doc, chunks) }); err != nil {
+		apierror.Write(w, r, err)
+		return
 	}
+	documentChunks.Observe(float64(len(chunks)))
+	ds.suggestTags(ctx, doc, chunks)
+	ds.writeUpload(w, doc, "")
+}
 
+// store uploads the original, sets doc.StoragePath and runs save to record
+// the document. If save fails the blob is removed again.
+func (ds *DocumentService) store(ctx context.Context, doc *Document, up *upload, save func() error) error {
+	uploadPath := documentPrefix + "/" + uuid.New().String() + up.ext
+	if err := ds.blobs.Upload(ctx, uploadPath, up.data, up.contentType); err != nil {
+		return apierror.Wrap(err, apierror.CodeUnavailable, "Failed to store document")
+	}
+	doc.StoragePath = uploadPath
+	if err := save(); err != nil {
+		// Nothing references the blob now; remove it rather than leaving
+		// it for the reconciler.
+		if rmErr := ds.blobs.Remove(context.WithoutCancel(ctx), uploadPath); rmErr != nil {
+			slog.ErrorContext(ctx, "failed to remove orphaned blob", "path", uploadPath, "error", rmErr)
+		}
+		if errors.Is(err, repository.ErrConflict) {
+			return apierror.Wrap(err, apierror.CodeConflict, "The document was changed by another request; please retry")
+		}
+		return apierror.Wrap(err, apierror.CodeInternal, "Failed to save document")
+	}
+	return nil
+}
+
+// uploadResponse is the uploaded document. DuplicateOf is set when the
+// content had been uploaded before: to the earlier document's ID, which is
+// also the document returned unless a duplicate was explicitly allowed.
+type uploadResponse struct {
+	Document
+	DuplicateOf string `json:"duplicateOf,omitempty"`
+}
+
+func (ds *DocumentService) writeUpload(w http.ResponseWriter, doc *Document, duplicateOf string) {
+	response := uploadResponse{Document: *doc, DuplicateOf: duplicateOf}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-func (ds *DocumentService) saveDocumentChunks(ctx context.Context, documentID string, content string) error {
-	chunkSize := 2000
-	for i := 0; i*chunkSize < len(content); i++ {
-		start := i * chunkSize
-		end := start + chunkSize
-		if end > len(content) {
-			end = len(content)
+// extractText returns the text content of an uploaded file and the
+// extractor that produced it. only, if not empty, names the one extractor
+// to use.
+func (ds *DocumentService) extractText(ctx context.Context, fileExt string, data []byte, only string) (string, string, error) {
+	var text, extractor string
+	switch fileExt {
+	case ".pdf":
+		tmpFile, err := os.CreateTemp("", "*.pdf")
+		if err != nil {
+			return "", "", fmt.Errorf("failed to create temp file: %v", err)
 		}
-
-		chunk := content[start:end]
-		chunkID := uuid.New().String()
-
-		_, err := ds.db.ExecContext(ctx, `
-			INSERT INTO document_chunks (id, document_id, chunk_index, content)
-			VALUES ($1, $2, $3, $4)`,
-			chunkID, documentID, i, chunk)
+		defer os.Remove(tmpFile.Name())
+		if _, err := tmpFile.Write(data); err != nil {
+			tmpFile.Close()
+			return "", "", fmt.Errorf("failed to write temp file: %v", err)
+		}
+		tmpFile.Close()
+		text, extractor, err = extractTextFromPDF(ctx, tmpFile.Name(), ds.extraction.OCRSpaceAPIKey.Value(), only)
 		if err != nil {
-			return fmt.Errorf("error inserting chunk %d: %v", i, err)
+			return "", "", err
 		}
+	case ".txt":
+		text, extractor = string(data), extractorPlainText
 	}
-	return nil
+	return strings.ReplaceAll(text, "\x00", ""), extractor, nil
 }
 
-func extractTextFromPDF(filePath string) (string, error) {
+// fileExtractors lists the extractors for each supported file type, in
+// the order they are tried.
+var fileExtractors = map[string][]string{
+	".pdf": {extractorOCRSpace, extractorPDFToText, extractorRSCPDF},
+	".txt": {extractorPlainText},
+}
 
-	apiKey := os.Getenv("OCR_SPACE_API_KEY")
-	if apiKey != "" {
-		ocrText, ocrErr := extractTextWithOCRSpace(filePath, apiKey)
-		if ocrErr == nil && len(ocrText) > 0 {
-			return ocrText, nil
+// extractTextFromPDF tries each PDF extractor in turn, or only the one
+// named by only if it is not empty, and returns the first text found
+// together with the extractor that found it. OCR is skipped without an
+// API key.
+func extractTextFromPDF(ctx context.Context, filePath, apiKey, only string) (string, string, error) {
+	var lastErr error
+	for _, extractor := range fileExtractors[".pdf"] {
+		if (only != "" && extractor != only) || (extractor == extractorOCRSpace && apiKey == "") {
+			continue
 		}
+		attemptCtx, done := startExtraction(ctx, extractor)
+		var text string
+		var err error
+		switch extractor {
+		case extractorOCRSpace:
+			text, err = extractTextWithOCRSpace(attemptCtx, filePath, apiKey)
+		case extractorPDFToText:
+			text, err = extractTextWithPDFToText(attemptCtx, filePath)
+		case extractorRSCPDF:
+			text, err = extractTextWithRSCPDF(filePath)
+		}
+		ok := err == nil && len(text) > 0
+		done(ok, err)
+		if ok {
+			return text, extractor, nil
+		}
+		lastErr = err
 	}
+	if only != "" && lastErr != nil {
+		return "", "", fmt.Errorf("Failed to extract text from PDF with %s: %v", only, lastErr)
+	}
+	return "", "", fmt.Errorf("Failed to extract text from PDF")
+}
 
+func extractTextWithPDFToText(ctx context.Context, filePath string) (string, error) {
 	txtPath := filePath + ".txt"
-	cmd := exec.Command("pdftotext", filePath, txtPath)
-	err := cmd.Run()
-	if err == nil {
-		defer os.Remove(txtPath)
-		data, readErr := ioutil.ReadFile(txtPath)
-		if readErr == nil && len(data) > 0 {
-			return string(data), nil
-		}
+	if err := exec.CommandContext(ctx, "pdftotext", filePath, txtPath).Run(); err != nil {
+		return "", err
 	}
+	defer os.Remove(txtPath)
+	data, err := ioutil.ReadFile(txtPath)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
 
+func extractTextWithRSCPDF(filePath string) (string, error) {
 	f, err := pdf.Open(filePath)
-	if err == nil {
-		var text string
-		for i := 1; i <= f.NumPage(); i++ {
-			page := f.Page(i)
-			content := page.Content()
-			for _, txt := range content.Text {
-				text += txt.S + " "
-			}
-			text += "\n"
-		}
-		if len(text) > 20 {
-			return text, nil
+	if err != nil {
+		return "", err
+	}
+	var text string
+	for i := 1; i <= f.NumPage(); i++ {
+		page := f.Page(i)
+		content := page.Content()
+		for _, txt := range content.Text {
+			text += txt.S + " "
 		}
+		text += "\n" + pageBreak
 	}
-	return "", fmt.Errorf("Failed to extract text from PDF")
+	// Pages without text still leave their separators behind.
+	if len(strings.TrimSpace(text)) <= 20 {
+		return "", nil
+	}
+	return text, nil
 }
 
-func extractTextWithOCRSpace(pdfPath, apiKey string) (string, error) {
+func extractTextWithOCRSpace(ctx context.Context, pdfPath, apiKey string) (string, error) {
 	file, err := os.Open(pdfPath)
 	if err != nil {
 		return "", err
@@ -253,7 +480,7 @@ func extractTextWithOCRSpace(pdfPath, apiKey string) (string, error) {
 	w.WriteField("OCREngine", "2")
 	w.Close()
 
-	req, err := http.NewRequest("POST", "https://api.ocr.space/parse/image", &b)
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.ocr.space/parse/image", &b)
 	if err != nil {
 		return "", err
 	}
@@ -282,7 +509,12 @@ func extractTextWithOCRSpace(pdfPath, apiKey string) (string, error) {
 	if len(result.ParsedResults) == 0 {
 		return "", fmt.Errorf("No text extracted")
 	}
-	return result.ParsedResults[0].ParsedText, nil
+	// OCR.space parses each page of a PDF separately.
+	pages := make([]string, len(result.ParsedResults))
+	for i, page := range result.ParsedResults {
+		pages[i] = page.ParsedText
+	}
+	return strings.Join(pages, pageBreak), nil
 }
 
 func (ds *DocumentService) ListDocuments(w http.ResponseWriter, r *http.Request) {
@@ -290,35 +522,34 @@ func (ds *DocumentService) ListDocuments(w http.ResponseWriter, r *http.Request)
 	userIDVal := ctx.Value(userIDKey)
 	userID, ok := userIDVal.(string)
 	if !ok || userID == "" {
-		http.Error(w, "Unauthorized: userID missing in context", http.StatusUnauthorized)
+		apierror.Write(w, r, errUnauthenticated)
 		return
 	}
 
-	rows, err := ds.db.QueryContext(ctx, `
-		SELECT id, file_name, storage_path, uploaded_at
-		FROM documents
-		WHERE user_id = $1
-		ORDER BY uploaded_at DESC`, userID)
-	if err != nil {
-		log.Printf("Database error (list): %v", err)
-		http.Error(w, "Failed to list documents", http.StatusInternalServerError)
+	query, aerr := parseDocumentQuery(r)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
 		return
 	}
-	defer rows.Close()
-
-	var documents []Document
-	for rows.Next() {
-		var doc Document
-		if err := rows.Scan(&doc.ID, &doc.FileName, &doc.StorageURL, &doc.UploadedAt); err != nil {
-			log.Printf("Row scan error: %v", err)
-			continue
+	if query.FolderID != "" {
+		_, err := ds.folders.Get(ctx, query.FolderID, userID)
+		if errors.Is(err, repository.ErrNotFound) {
+			apierror.Write(w, r, errFolderNotFound)
+			return
 		}
-		doc.UserID = userID
-		documents = append(documents, doc)
+		if err != nil {
+			apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to retrieve folder"))
+			return
+		}
+	}
+	page, err := ds.documents.List(ctx, userID, query)
+	if err != nil {
+		apierror.Write(w, r, listError(err, "Failed to list documents"))
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(documents)
+	json.NewEncoder(w).Encode(page)
 }
 
 func (ds *DocumentService) GetDocument(w http.ResponseWriter, r *http.Request) {
@@ -326,27 +557,15 @@ func (ds *DocumentService) GetDocument(w http.ResponseWriter, r *http.Request) {
 	userIDVal := ctx.Value(userIDKey)
 	userID, ok := userIDVal.(string)
 	if !ok || userID == "" {
-		http.Error(w, "Unauthorized: userID missing in context", http.StatusUnauthorized)
+		apierror.Write(w, r, errUnauthenticated)
 		return
 	}
 
 	docID := mux.Vars(r)["id"]
-	log.Printf("GetDocument: docID=%s, userID=%s", docID, userID)
-
-	var doc Document
-
-	err := ds.db.QueryRowContext(ctx, `
-		SELECT id, user_id, file_name, storage_path, uploaded_at
-		FROM documents WHERE id = $1 AND user_id = $2`,
-		docID, userID).Scan(&doc.ID, &doc.UserID, &doc.FileName, &doc.StorageURL, &doc.UploadedAt)
 
+	doc, err := ds.documents.Get(ctx, docID, userID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Document not found", http.StatusNotFound)
-		} else {
-			log.Printf("Database error (get): %v", err)
-			http.Error(w, "Failed to retrieve document", http.StatusInternalServerError)
-		}
+		apierror.Write(w, r, documentLookupError(err, "Failed to retrieve document"))
 		return
 	}
 
@@ -354,44 +573,19 @@ func (ds *DocumentService) GetDocument(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(doc)
 }
 
+// DeleteDocument moves a document to the trash. It can be restored until
+// the retention period is over, when the purger deletes it for good.
 func (ds *DocumentService) DeleteDocument(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userIDVal := ctx.Value(userIDKey)
 	userID, ok := userIDVal.(string)
 	if !ok || userID == "" {
-		http.Error(w, "Unauthorized: userID missing in context", http.StatusUnauthorized)
-		return
-	}
-
-	docID := mux.Vars(r)["id"]
-	log.Printf("DeleteDocument: docID=%s, userID=%s", docID, userID)
-
-	var storagePath string
-	err := ds.db.QueryRowContext(ctx, `
-		SELECT storage_path FROM documents WHERE id = $1 AND user_id = $2`,
-		docID, userID).Scan(&storagePath)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Document not found", http.StatusNotFound)
-		} else {
-			log.Printf("Database error (get for delete): %v", err)
-			http.Error(w, "Failed to fetch document", http.StatusInternalServerError)
-		}
+		apierror.Write(w, r, errUnauthenticated)
 		return
 	}
 
-	client := storage.NewClient(supabaseURL+"/storage/v1", supabaseKey, nil)
-	_, err = client.RemoveFile(supabaseBucket, []string{storagePath})
-	if err != nil {
-		log.Printf("Supabase delete error: %v", err)
-		// Optionally, handle error but still delete DB record
-	}
-
-	_, err = ds.db.ExecContext(ctx, "DELETE FROM documents WHERE id = $1 AND user_id = $2", docID, userID)
-	if err != nil {
-		log.Printf("Database error (delete): %v", err)
-		http.Error(w, "Failed to delete document", http.StatusInternalServerError)
+	if err := ds.documents.Trash(ctx, mux.Vars(r)["id"], userID); err != nil {
+		apierror.Write(w, r, documentLookupError(err, "Failed to delete document"))
 		return
 	}
 
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDocumentLifecycle(t *testing.T) {
+	ts := newTestServer(t, QuotaLimits{})
+	const content = "Quarterly report.\n\nRevenue grew in every region."
+	id := ts.upload(t, "alice", "report.txt", content)
+
+	w := ts.do(t, "GET", "/api/documents/"+id, "alice", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("get: status = %d, body %s", w.Code, w.Body)
+	}
+	var doc Document
+	decode(t, w, &doc)
+	if doc.FileName != "report.txt" || doc.Version != 1 || doc.Status != "ready" {
+		t.Errorf("get: document = %+v", doc)
+	}
+
+	if w := ts.do(t, "GET", "/api/documents/"+id, "bob", nil); w.Code != http.StatusNotFound {
+		t.Errorf("get as another user: status = %d, want 404", w.Code)
+	}
+
+	w = ts.do(t, "GET", "/api/documents/"+id+"/download", "alice", nil)
+	if w.Code != http.StatusOK || w.Body.String() != content {
+		t.Errorf("download: status = %d, body %q", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename=report.txt` {
+		t.Errorf("download: Content-Disposition = %q", got)
+	}
+	w = ts.do(t, "GET", "/api/documents/"+id+"/download", "alice", nil, "Range", "bytes=0-8")
+	if w.Code != http.StatusPartialContent || w.Body.String() != content[:9] {
+		t.Errorf("ranged download: status = %d, body %q", w.Code, w.Body)
+	}
+
+	if w := ts.do(t, "DELETE", "/api/documents/"+id, "alice", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: status = %d, body %s", w.Code, w.Body)
+	}
+	if w := ts.do(t, "GET", "/api/documents/"+id, "alice", nil); w.Code != http.StatusNotFound {
+		t.Errorf("get trashed: status = %d, want 404", w.Code)
+	}
+	w = ts.do(t, "GET", "/api/trash", "alice", nil)
+	var trash struct {
+		Items []Document `json:"items"`
+	}
+	decode(t, w, &trash)
+	if len(trash.Items) != 1 || trash.Items[0].ID != id {
+		t.Errorf("trash = %+v, want the deleted document", trash.Items)
+	}
+
+	if w := ts.do(t, "POST", "/api/documents/"+id+"/restore", "bob", nil); w.Code != http.StatusNotFound {
+		t.Errorf("restore as another user: status = %d, want 404", w.Code)
+	}
+	if w := ts.do(t, "POST", "/api/documents/"+id+"/restore", "alice", nil); w.Code != http.StatusOK {
+		t.Fatalf("restore: status = %d, body %s", w.Code, w.Body)
+	}
+	if w := ts.do(t, "GET", "/api/documents/"+id, "alice", nil); w.Code != http.StatusOK {
+		t.Errorf("get restored: status = %d, want 200", w.Code)
+	}
+}
+
+func TestUploadRejectsInvalidFiles(t *testing.T) {
+	ts := newTestServer(t, QuotaLimits{})
+	tests := []struct {
+		name, fileName, content string
+		code                    string
+	}{
+		{"unsupported extension", "report.docx", "Quarterly report.", "unsupported_media_type"},
+		{"content does not match the extension", "report.txt", "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n", "unsupported_media_type"},
+		{"no text", "empty.txt", "  \n\n ", "extraction_failed"},
+		{"too large", "huge.txt", strings.Repeat("Revenue grew. ", testMaxUpload/10), "request_too_large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			fw, _ := mw.CreateFormFile("document", tt.fileName)
+			io.WriteString(fw, tt.content)
+			mw.Close()
+			w := ts.do(t, "POST", "/api/documents", "alice", &body, "Content-Type", mw.FormDataContentType())
+			if got := errorCode(t, w); got != tt.code {
+				t.Errorf("status = %d, code = %q, want %q", w.Code, got, tt.code)
+			}
+		})
+	}
+
+	if w := ts.do(t, "POST", "/api/documents", "alice", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("no file: status = %d, want 400", w.Code)
+	}
+	if w := ts.do(t, "POST", "/api/documents", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated: status = %d, want 401", w.Code)
+	}
+}
+
+func TestFailedUploadHidesExtractorError(t *testing.T) {
+	ts := newTestServer(t, QuotaLimits{})
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("document", "broken.pdf")
+	io.WriteString(fw, "%PDF-1.4\n%\xe2\xe3\xcf\xd3\nnot really a PDF\n")
+	mw.Close()
+	w := ts.do(t, "POST", "/api/documents", "alice", &body, "Content-Type", mw.FormDataContentType())
+	var failed struct {
+		Error struct {
+			Details map[string]any `json:"details"`
+		} `json:"error"`
+	}
+	decode(t, w, &failed)
+	id, _ := failed.Error.Details["documentId"].(string)
+	if w.Code != http.StatusUnprocessableEntity || id == "" {
+		t.Fatalf("upload: status = %d, body %s", w.Code, w.Body)
+	}
+
+	// The extractor's error names internal tools; the failed document only
+	// keeps the message the upload was rejected with.
+	var doc Document
+	decode(t, ts.do(t, "GET", "/api/documents/"+id, "alice", nil), &doc)
+	if doc.Status != "failed" || doc.ProcessingError != "No text could be extracted from the document" {
+		t.Errorf("status = %q, processing error = %q", doc.Status, doc.ProcessingError)
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/blobstore"
+)
+
+// contentDisposition returns the Content-Disposition header for serving
+// fileName: as an attachment unless the "disposition" query parameter asks
+// for inline display.
+func contentDisposition(r *http.Request, fileName string) (string, *apierror.Error) {
+	disposition := r.URL.Query().Get("disposition")
+	switch disposition {
+	case "":
+		disposition = "attachment"
+	case "attachment", "inline":
+	default:
+		return "", apierror.New(apierror.CodeInvalidRequest, "disposition must be attachment or inline")
+	}
+	return mime.FormatMediaType(disposition, map[string]string{"filename": fileName}), nil
+}
+
+// DownloadDocument streams the original file of a document to its owner.
+// Range and conditional requests are supported.
+func (ds *DocumentService) DownloadDocument(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	version, aerr := ds.requestedVersion(ctx, r, userID)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+	disposition, aerr := contentDisposition(r, version.FileName)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+	setAuditDetail(ctx, "version", strconv.Itoa(version.Version))
+
+	data, err := ds.blobs.Download(ctx, version.StoragePath)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeNotFound, "The original document is missing from storage"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeUnavailable, "Failed to retrieve the original document"))
+		return
+	}
+
+	contentType, ok := supportedExtensions[strings.ToLower(filepath.Ext(version.StoragePath))]
+	if !ok {
+		contentType = "application/octet-stream"
+	}
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Disposition", disposition)
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Cache-Control", "private, no-cache")
+	if version.SHA256 != "" {
+		h.Set("ETag", strconv.Quote(version.SHA256))
+	}
+	http.ServeContent(w, r, version.FileName, version.UploadedAt, bytes.NewReader(data))
+}
+
+type downloadURLResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// CreateDownloadURL mints a signed URL from which the original file of a
+// document can be downloaded without authorization until it expires. The
+// "expiresIn" query parameter, in seconds, shortens or extends the
+// configured lifetime up to its maximum.
+func (ds *DocumentService) CreateDownloadURL(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	expiry := ds.storage.SignedURLTTL
+	if v := r.URL.Query().Get("expiresIn"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > ds.storage.SignedURLMaxTTL {
+			apierror.Write(w, r, apierror.New(apierror.CodeInvalidRequest,
+				"expiresIn must be between 1 and "+strconv.Itoa(int(ds.storage.SignedURLMaxTTL/time.Second))+" seconds"))
+			return
+		}
+		expiry = time.Duration(seconds) * time.Second
+	}
+	version, aerr := ds.requestedVersion(ctx, r, userID)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+	if _, aerr := contentDisposition(r, version.FileName); aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+	setAuditDetail(ctx, "version", strconv.Itoa(version.Version))
+	setAuditDetail(ctx, "expires_in", expiry.String())
+
+	expiresAt := time.Now().Add(expiry)
+	signed, err := ds.blobs.SignedURL(ctx, version.StoragePath, expiry)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeNotFound, "The original document is missing from storage"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeUnavailable, "Failed to create a download URL"))
+		return
+	}
+	// Supabase serves the object as an attachment, under the given name,
+	// when the URL carries a download parameter.
+	if r.URL.Query().Get("disposition") != "inline" {
+		sep := "?"
+		if strings.Contains(signed, "?") {
+			sep = "&"
+		}
+		signed += sep + "download=" + url.QueryEscape(version.FileName)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(downloadURLResponse{URL: signed, ExpiresAt: expiresAt})
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/repository"
+
+	"github.com/gorilla/mux"
+)
+
+// Limits on the details a user can edit, in characters.
+const (
+	maxFileNameLength      = 255
+	maxDescriptionLength   = 2000
+	maxMetadataValueLength = 500
+	// maxMetadataKeys bounds the metadata entries of one document.
+	maxMetadataKeys = 50
+)
+
+// metadataKeyPattern restricts metadata keys to short identifiers.
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// fileName trims name and checks that it can replace current: it must keep
+// current's extension, which decides how the document is processed.
+func fileName(name, current string) (string, *apierror.Error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", apierror.New(apierror.CodeInvalidRequest, "fileName must not be empty")
+	}
+	if utf8.RuneCountInString(name) > maxFileNameLength {
+		return "", apierror.New(apierror.CodeInvalidRequest,
+			"fileName must be at most "+strconv.Itoa(maxFileNameLength)+" characters")
+	}
+	if strings.ContainsAny(name, `/\`) || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "", apierror.New(apierror.CodeInvalidRequest, "fileName must not contain slashes or control characters")
+	}
+	if ext := filepath.Ext(current); !strings.EqualFold(filepath.Ext(name), ext) || name == ext {
+		return "", apierror.New(apierror.CodeInvalidRequest, "fileName must keep the file's extension").
+			WithDetails(map[string]any{"extension": ext})
+	}
+	return name, nil
+}
+
+// checkMetadata checks a metadata patch: keys map to their new value, or
+// to nil to remove them.
+func checkMetadata(patch map[string]*string) *apierror.Error {
+	for key, value := range patch {
+		if !metadataKeyPattern.MatchString(key) {
+			return apierror.New(apierror.CodeInvalidRequest,
+				"Metadata keys must be 1 to 64 letters, digits, dots, dashes or underscores").
+				WithDetails(map[string]any{"key": key})
+		}
+		if value != nil && utf8.RuneCountInString(*value) > maxMetadataValueLength {
+			return apierror.New(apierror.CodeInvalidRequest,
+				"Metadata values must be at most "+strconv.Itoa(maxMetadataValueLength)+" characters").
+				WithDetails(map[string]any{"key": key})
+		}
+	}
+	return nil
+}
+
+// UpdateDocument renames a document or edits its description and
+// metadata. An absent field is left unchanged. metadata is merged into the
+// document's: a key set to null is removed, other keys are set.
+func (ds *DocumentService) UpdateDocument(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	var req struct {
+		FileName    *string            `json:"fileName"`
+		Description *string            `json:"description"`
+		Metadata    map[string]*string `json:"metadata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInvalidRequest, "Invalid request body"))
+		return
+	}
+	if req.FileName == nil && req.Description == nil && req.Metadata == nil {
+		apierror.Write(w, r, apierror.New(apierror.CodeInvalidRequest, "fileName, description or metadata is required"))
+		return
+	}
+	if req.Description != nil && utf8.RuneCountInString(*req.Description) > maxDescriptionLength {
+		apierror.Write(w, r, apierror.New(apierror.CodeInvalidRequest,
+			"description must be at most "+strconv.Itoa(maxDescriptionLength)+" characters"))
+		return
+	}
+	if aerr := checkMetadata(req.Metadata); aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+
+	doc, err := ds.documents.Update(ctx, mux.Vars(r)["id"], userID, func(doc *Document) error {
+		if req.FileName != nil {
+			name, aerr := fileName(*req.FileName, doc.FileName)
+			if aerr != nil {
+				return aerr
+			}
+			doc.FileName = name
+		}
+		if req.Description != nil {
+			doc.Description = strings.TrimSpace(*req.Description)
+		}
+		for key, value := range req.Metadata {
+			if value == nil {
+				delete(doc.Metadata, key)
+				continue
+			}
+			if doc.Metadata == nil {
+				doc.Metadata = make(map[string]string)
+			}
+			doc.Metadata[key] = *value
+		}
+		if len(doc.Metadata) > maxMetadataKeys {
+			return apierror.New(apierror.CodeInvalidRequest,
+				"A document can have at most "+strconv.Itoa(maxMetadataKeys)+" metadata entries")
+		}
+		return nil
+	})
+	if err != nil {
+		apierror.Write(w, r, documentLookupError(err, "Failed to update document"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(doc)
+}
+
+// ReprocessDocument extracts and chunks the latest version of a document
+// again from its original, optionally with a chosen extractor and chunker,
+// and replaces its chunks with the result. If extraction fails the
+// document keeps its current chunks.
+func (ds *DocumentService) ReprocessDocument(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	// Every option has a default, so the body may be left out.
+	var opts ingestOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil && !errors.Is(err, io.EOF) {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInvalidRequest, "Invalid request body"))
+		return
+	}
+
+	doc, err := ds.documents.Get(ctx, mux.Vars(r)["id"], userID)
+	if err != nil {
+		apierror.Write(w, r, documentLookupError(err, "Failed to retrieve document"))
+		return
+	}
+	ext := strings.ToLower(filepath.Ext(doc.StoragePath))
+	opts, aerr := opts.check(ext, ds.extraction.OCRSpaceAPIKey.Value())
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+	setAuditDetail(ctx, "version", strconv.Itoa(doc.Version))
+	setAuditDetail(ctx, "extractor", opts.Extractor)
+	setAuditDetail(ctx, "chunker", opts.Chunker.Strategy+":"+strconv.Itoa(opts.Chunker.Size))
+
+	data, err := ds.blobs.Download(ctx, doc.StoragePath)
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeUnavailable, "Failed to retrieve the original document"))
+		return
+	}
+
+	next := *doc
+	chunks, err := ds.ingest(ctx, &next, &upload{fileName: doc.FileName, ext: ext, data: data}, opts)
+	if err != nil {
+		apierror.Write(w, r, errExtractionFailed(err))
+		return
+	}
+	err = ds.documents.Reprocess(ctx, &next, chunks)
+	if errors.Is(err, repository.ErrConflict) {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeConflict,
+			"A new version was added while reprocessing; please retry"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, documentLookupError(err, "Failed to save document"))
+		return
+	}
+	documentChunks.Observe(float64(len(chunks)))
+	// A document that failed at upload had no text to suggest tags from.
+	if len(doc.SuggestedTags) == 0 {
+		ds.suggestTags(ctx, &next, chunks)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(next)
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"net/http"
+
+	"strategic-insight-analyst/apierror"
+)
+
+// Errors shared by several handlers.
+var (
+	errUnauthenticated  = apierror.New(apierror.CodeUnauthenticated, "Unauthorized")
+	errMethodNotAllowed = apierror.New(apierror.CodeMethodNotAllowed, "Method not allowed")
+	errRouteNotFound    = apierror.New(apierror.CodeNotFound, "Not found")
+)
+
+// Router fallbacks, so unmatched requests get the same error envelope as
+// everything else.
+var (
+	NotFound         http.Handler = apierror.Handler(errRouteNotFound)
+	MethodNotAllowed http.Handler = apierror.Handler(errMethodNotAllowed)
+)
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/repository"
+
+	"github.com/gorilla/mux"
+)
+
+// maxFolderNameLength bounds folder names, in characters.
+const maxFolderNameLength = 255
+
+// maxBulkDocuments bounds the documents one bulk request may change.
+const maxBulkDocuments = 500
+
+var (
+	errFolderNotFound       = apierror.New(apierror.CodeNotFound, "Folder not found")
+	errParentFolderNotFound = apierror.New(apierror.CodeNotFound, "Parent folder not found")
+	errFolderNameTaken      = apierror.New(apierror.CodeConflict, "A folder with this name already exists here")
+	errFolderCycle          = apierror.New(apierror.CodeInvalidRequest, "A folder cannot be moved into itself or one of its subfolders")
+)
+
+type FolderService struct {
+	folders repository.FolderRepo
+}
+
+func NewFolderService(repos repository.Repos) *FolderService {
+	return &FolderService{folders: repos.Folders}
+}
+
+// folderName trims name and checks that it is usable as a folder name.
+func folderName(name string) (string, *apierror.Error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", apierror.New(apierror.CodeInvalidRequest, "name is required")
+	}
+	if utf8.RuneCountInString(name) > maxFolderNameLength {
+		return "", apierror.New(apierror.CodeInvalidRequest,
+			"name must be at most "+strconv.Itoa(maxFolderNameLength)+" characters")
+	}
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "", apierror.New(apierror.CodeInvalidRequest, "name must not contain control characters")
+	}
+	return name, nil
+}
+
+// folderWriteError reports a failed folder create or update.
+func folderWriteError(err error) *apierror.Error {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return errParentFolderNotFound
+	case errors.Is(err, repository.ErrConflict):
+		return errFolderNameTaken
+	case errors.Is(err, repository.ErrFolderCycle):
+		return errFolderCycle
+	}
+	return apierror.Wrap(err, apierror.CodeInternal, "Failed to save folder")
+}
+
+// ListFolders returns every folder of the user as a flat list sorted by
+// name; parentId links them into a tree.
+func (fs *FolderService) ListFolders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	folders, err := fs.folders.List(ctx, userID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to list folders"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(folders)
+}
+
+func (fs *FolderService) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	var req struct {
+		Name     string `json:"name"`
+		ParentID string `json:"parentId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInvalidRequest, "Invalid request body"))
+		return
+	}
+	name, aerr := folderName(req.Name)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+
+	folder := &repository.Folder{UserID: userID, ParentID: req.ParentID, Name: name}
+	if err := fs.folders.Create(ctx, folder); err != nil {
+		apierror.Write(w, r, folderWriteError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(folder)
+}
+
+// UpdateFolder renames a folder or moves it. An absent field is left
+// unchanged; a parentId of "" moves the folder to the top level.
+func (fs *FolderService) UpdateFolder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	folder, err := fs.folders.Get(ctx, mux.Vars(r)["id"], userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Write(w, r, errFolderNotFound)
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to retrieve folder"))
+		return
+	}
+
+	var req struct {
+		Name     *string `json:"name"`
+		ParentID *string `json:"parentId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInvalidRequest, "Invalid request body"))
+		return
+	}
+	if req.Name != nil {
+		name, aerr := folderName(*req.Name)
+		if aerr != nil {
+			apierror.Write(w, r, aerr)
+			return
+		}
+		folder.Name = name
+	}
+	if req.ParentID != nil {
+		folder.ParentID = *req.ParentID
+	}
+
+	if err := fs.folders.Update(ctx, folder); err != nil {
+		apierror.Write(w, r, folderWriteError(err))
+		return
+	}
+	updated, err := fs.folders.Get(ctx, folder.ID, userID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to retrieve folder"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteFolder removes a folder and its subfolders. The documents they
+// held move to the folder's parent; none are deleted.
+func (fs *FolderService) DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	err = fs.folders.Delete(ctx, mux.Vars(r)["id"], userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Write(w, r, errFolderNotFound)
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to delete folder"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkDocumentIDs checks the document IDs of a bulk request.
+func checkDocumentIDs(ids []string) *apierror.Error {
+	if len(ids) == 0 {
+		return apierror.New(apierror.CodeInvalidRequest, "documentIds is required")
+	}
+	if len(ids) > maxBulkDocuments {
+		return apierror.New(apierror.CodeInvalidRequest,
+			"documentIds must list at most "+strconv.Itoa(maxBulkDocuments)+" documents")
+	}
+	for _, id := range ids {
+		if id == "" {
+			return apierror.New(apierror.CodeInvalidRequest, "documentIds must not contain empty IDs")
+		}
+	}
+	return nil
+}
+
+// MoveDocuments puts documents into a folder, or outside every folder
+// when folderId is "". Either all of them move or, if one is not found,
+// none does.
+func (fs *FolderService) MoveDocuments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	var req struct {
+		DocumentIDs []string `json:"documentIds"`
+		FolderID    string   `json:"folderId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInvalidRequest, "Invalid request body"))
+		return
+	}
+	if aerr := checkDocumentIDs(req.DocumentIDs); aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+
+	err = fs.folders.MoveDocuments(ctx, userID, req.FolderID, req.DocumentIDs)
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeNotFound, "Folder or document not found"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to move documents"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthCheck probes one dependency for the readiness endpoint.
+type HealthCheck func(ctx context.Context) error
+
+// Health serves /healthz (the process is alive) and /readyz (it can serve
+// traffic). Results of slow external checks can be cached so that
+// frequent probes do not hammer third-party APIs.
+type Health struct {
+	mu       sync.Mutex
+	checks   []*namedCheck
+	draining atomic.Bool
+}
+
+type namedCheck struct {
+	name  string
+	check HealthCheck
+	ttl   time.Duration
+
+	mu      sync.Mutex // serialises runs so concurrent probes share a cached result
+	lastRun time.Time
+	lastErr error
+}
+
+func NewHealth() *Health {
+	return &Health{}
+}
+
+// AddCheck registers a readiness check. A positive ttl reuses the previous
+// result for that long.
+func (h *Health) AddCheck(name string, ttl time.Duration, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, &namedCheck{name: name, check: check, ttl: ttl})
+}
+
+// SetDraining makes /readyz fail so that load balancers stop routing new
+// requests while in-flight ones finish.
+func (h *Health) SetDraining() {
+	h.draining.Store(true)
+}
+
+func (h *Health) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// checkResult is the outcome of one check. /readyz is public, so the
+// error, which can name hosts or quote a provider, is only logged.
+type checkResult struct {
+	Status string `json:"status"`
+}
+
+func (h *Health) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	h.mu.Lock()
+	checks := append([]*namedCheck(nil), h.checks...)
+	h.mu.Unlock()
+
+	results := make(map[string]checkResult, len(checks))
+	var resultsMu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c *namedCheck) {
+			defer wg.Done()
+			err := c.run(ctx)
+			res := checkResult{Status: "ok"}
+			if err != nil {
+				res = checkResult{Status: "fail"}
+			}
+			resultsMu.Lock()
+			results[c.name] = res
+			resultsMu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	status := "ok"
+	code := http.StatusOK
+	for _, res := range results {
+		if res.Status != "ok" {
+			status, code = "fail", http.StatusServiceUnavailable
+		}
+	}
+	if h.draining.Load() {
+		status, code = "draining", http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "checks": results})
+}
+
+func (c *namedCheck) run(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl > 0 && !c.lastRun.IsZero() && time.Since(c.lastRun) < c.ttl {
+		return c.lastErr
+	}
+	err := c.check(ctx)
+	c.lastRun, c.lastErr = time.Now(), err
+	if err != nil {
+		slog.WarnContext(ctx, "readiness check failed", "check", c.name, "error", err)
+	}
+	return err
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	h := NewHealth()
+	h.AddCheck("database", 0, func(ctx context.Context) error { return nil })
+	h.AddCheck("blobstore", 0, func(ctx context.Context) error {
+		return errors.New("dial tcp db.internal.example:5432: connection refused")
+	})
+
+	w := httptest.NewRecorder()
+	h.Readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", w.Code)
+	}
+	var resp struct {
+		Status string                 `json:"status"`
+		Checks map[string]checkResult `json:"checks"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "fail" || resp.Checks["database"].Status != "ok" || resp.Checks["blobstore"].Status != "fail" {
+		t.Errorf("response = %s", w.Body)
+	}
+	if strings.Contains(w.Body.String(), "db.internal") {
+		t.Errorf("response exposes the check error: %s", w.Body)
+	}
+
+	h.SetDraining()
+	w = httptest.NewRecorder()
+	h.Readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"draining"`) {
+		t.Errorf("draining: status = %d, body %s", w.Code, w.Body)
+	}
+}
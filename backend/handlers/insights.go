@@ -3,26 +3,51 @@ package handlers
 import (
 	"bytes"
 	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/repository"
+	"strategic-insight-analyst/utils"
+
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	hfProvider = "huggingface"
+	hfModel    = "HuggingFaceH4/zephyr-7b-beta"
 )
 
 type LLMService struct {
-	db *sql.DB
+	documents repository.DocumentRepo
+	chunks    repository.ChunkRepo
+	chats     repository.ChatRepo
+	tags      repository.TagRepo
+	cfg       utils.LLMConfig
+	quotas    *QuotaService
+	usage     *UsageService
 }
 
-func NewLLMService(db *sql.DB) *LLMService {
-	return &LLMService{db: db}
+func NewLLMService(repos repository.Repos, cfg utils.LLMConfig, quotas *QuotaService, usage *UsageService) *LLMService {
+	return &LLMService{
+		documents: repos.Documents,
+		chunks:    repos.Chunks,
+		chats:     repos.Chats,
+		tags:      repos.Tags,
+		cfg:       cfg,
+		quotas:    quotas,
+		usage:     usage,
+	}
 }
 
 type ChatMessage struct {
@@ -35,6 +60,7 @@ type ChatHistoryItem struct {
 	Type      string    `json:"type"`
 	Content   string    `json:"content"`
 	Timestamp time.Time `json:"timestamp"`
+	Version   int       `json:"version"`
 }
 
 func selectRelevantChunks(chunks []string, question string, maxChars int) string {
@@ -67,68 +93,128 @@ func selectRelevantChunks(chunks []string, question string, maxChars int) string
 	return strings.Join(selected, "\n\n")
 }
 
-func (ls *LLMService) getChunks(ctx context.Context, documentID string) ([]string, error) {
-	rows, err := ls.db.QueryContext(ctx, `
-		SELECT content FROM document_chunks
-		WHERE document_id = $1
-		ORDER BY chunk_index`, documentID)
-	if err != nil {
-		return nil, err
+// errProviderUnavailable reports a failed LLM call. A call cut short by
+// the request deadline or the client's own timeout is a timeout rather
+// than a provider failure.
+func errProviderUnavailable(err error) *apierror.Error {
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne) && ne.Timeout() {
+		return apierror.Wrap(err, apierror.CodeTimeout, "The language model did not respond in time")
 	}
-	defer rows.Close()
+	return apierror.Wrap(err, apierror.CodeProviderUnavailable, "The language model provider is unavailable").
+		WithDetails(map[string]any{"provider": hfProvider})
+}
 
-	var chunks []string
-	for rows.Next() {
-		var chunk string
-		if err := rows.Scan(&chunk); err != nil {
-			return nil, err
-		}
-		chunks = append(chunks, chunk)
+// errNoChunks is returned by retrieve for a document version without
+// stored text, such as one whose extraction failed.
+var errNoChunks = apierror.New(apierror.CodeNotProcessed,
+	"The document has no extracted text to analyze; reprocess it first")
+
+var errVersionNotFound = apierror.New(apierror.CodeNotFound, "Version not found")
+
+// retrieve selects the prompt context for question from the chunks of one
+// version of the document, after checking that the document belongs to
+// userID. Version 0 means the latest; the version used is returned.
+func (ls *LLMService) retrieve(ctx context.Context, documentID, userID string, version int, question string) (contextText string, used int, err error) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "retrieve", trace.WithAttributes(attribute.String("document.id", documentID)))
+	defer func() {
+		retrievalDuration.Observe(time.Since(start).Seconds())
+		span.SetAttributes(attribute.Int("retrieve.context_chars", len(contextText)))
+		endSpan(span, err)
+	}()
+
+	doc, err := ls.documents.Get(ctx, documentID, userID)
+	if err != nil {
+		return "", 0, err
+	}
+	if version == 0 {
+		version = doc.Version
+	}
+	if version < 1 || version > doc.Version {
+		return "", 0, errVersionNotFound
+	}
+	span.SetAttributes(attribute.Int("document.version", version))
+	chunks, err := ls.chunks.List(ctx, documentID, version)
+	if err != nil {
+		return "", 0, err
 	}
-	return chunks, nil
+	if len(chunks) == 0 {
+		return "", 0, errNoChunks
+	}
+	span.SetAttributes(attribute.Int("retrieve.chunks", len(chunks)))
+	return selectRelevantChunks(chunks, question, 2000), version, nil
 }
 
-func (ls *LLMService) getChatHistory(ctx context.Context, documentID, userID string) ([]ChatMessage, error) {
-	rows, err := ls.db.QueryContext(ctx, `
-		SELECT message_type, message_content
-		FROM chat_history
-		WHERE document_id = $1 AND user_id = $2
-		ORDER BY timestamp
-		LIMIT 10`, documentID, userID)
+// getChatHistory returns the latest messages about the given version of the
+// document, so that answers about one version never draw on another.
+func (ls *LLMService) getChatHistory(ctx context.Context, documentID, userID string, version int) ([]ChatMessage, error) {
+	msgs, err := ls.chats.Recent(ctx, documentID, userID, version, 10)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
 	var history []ChatMessage
-	for rows.Next() {
-		var msgType, content string
-		if err := rows.Scan(&msgType, &content); err != nil {
-			return nil, err
-		}
+	for _, m := range msgs {
 		role := "user"
-		if msgType == "ai" {
+		if m.Type == "ai" {
 			role = "model"
 		}
-		history = append(history, ChatMessage{Role: role, Content: content})
+		history = append(history, ChatMessage{Role: role, Content: m.Content})
 	}
 	return history, nil
 }
 
-func (ls *LLMService) saveChat(ctx context.Context, documentID, userID, userMsg, aiMsg string) {
-	_, err := ls.db.ExecContext(ctx, `
-		INSERT INTO chat_history (id, document_id, user_id, message_type, message_content)
-		VALUES ($1, $2, $3, 'user', $4)`,
-		uuid.New().String(), documentID, userID, userMsg)
+func (ls *LLMService) saveChat(ctx context.Context, documentID, userID string, version int, userMsg, aiMsg string) {
+	err := ls.chats.Append(ctx,
+		repository.ChatMessage{DocumentID: documentID, UserID: userID, Version: version, Type: "user", Content: userMsg},
+		repository.ChatMessage{DocumentID: documentID, UserID: userID, Version: version, Type: "ai", Content: aiMsg},
+	)
 	if err != nil {
-		log.Printf("Warning: failed to save user chat message: %v", err)
+		slog.WarnContext(ctx, "failed to save chat messages", "error", err)
 	}
-	_, err = ls.db.ExecContext(ctx, `
-		INSERT INTO chat_history (id, document_id, user_id, message_type, message_content)
-		VALUES ($1, $2, $3, 'ai', $4)`,
-		uuid.New().String(), documentID, userID, aiMsg)
-	if err != nil {
-		log.Printf("Warning: failed to save ai chat message: %v", err)
+}
+
+// writeLLMError reports an LLM call that failed or was refused.
+func writeLLMError(w http.ResponseWriter, r *http.Request, err error) {
+	var qe *QuotaExceededError
+	var aerr *apierror.Error
+	switch {
+	case errors.As(err, &qe):
+		writeQuotaExceeded(w, r, qe)
+	case errors.As(err, &aerr):
+		apierror.Write(w, r, aerr)
+	default:
+		apierror.Write(w, r, errProviderUnavailable(err))
+	}
+}
+
+// recordUsage meters a finished LLM request and settles its quota
+// reservation. It runs even when the client has gone away or the request
+// timed out, since the provider may have been paid regardless.
+func (ls *LLMService) recordUsage(ctx context.Context, res *QuotaReservation, call llmCall, prompt, response string, latency time.Duration, callErr error) {
+	ctx = context.WithoutCancel(ctx)
+	u := LLMUsage{
+		llmCall:          call,
+		Provider:         hfProvider,
+		Model:            hfModel,
+		PromptTokens:     estimateTokens(prompt),
+		CompletionTokens: estimateTokens(response),
+		Latency:          latency,
+		Status:           "ok",
+	}
+	if callErr != nil {
+		u.Status = "error"
+		llmErrors.WithLabelValues(u.Provider, u.Model).Inc()
+	}
+	llmRequestDuration.WithLabelValues(u.Provider, u.Model, u.Status).Observe(latency.Seconds())
+	llmTokens.WithLabelValues(u.Provider, u.Model, "prompt").Add(float64(u.PromptTokens))
+	llmTokens.WithLabelValues(u.Provider, u.Model, "completion").Add(float64(u.CompletionTokens))
+	if err := ls.usage.Record(ctx, u); err != nil {
+		slog.WarnContext(ctx, "failed to record LLM usage", "error", err)
+	}
+	if err := res.Settle(ctx, u.PromptTokens+u.CompletionTokens, callErr != nil); err != nil {
+		slog.WarnContext(ctx, "failed to record LLM quota usage", "error", err)
 	}
 }
 
@@ -145,25 +231,26 @@ func (ls *LLMService) GenerateInsight(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userID, err := getUserID(ctx)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		apierror.Write(w, r, errUnauthenticated)
 		return
 	}
 	documentID := mux.Vars(r)["documentId"]
 
 	var req struct {
 		Question string `json:"question"`
+		// Version selects the document version; 0 means the latest.
+		Version int `json:"version"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInvalidRequest, "Invalid request body"))
 		return
 	}
 
-	chunks, err := ls.getChunks(ctx, documentID)
-	if err != nil || len(chunks) == 0 {
-		http.Error(w, "Failed to retrieve document", http.StatusInternalServerError)
+	contextText, version, err := ls.retrieve(ctx, documentID, userID, req.Version, req.Question)
+	if err != nil {
+		apierror.Write(w, r, documentLookupError(err, "Failed to retrieve document"))
 		return
 	}
-	contextText := selectRelevantChunks(chunks, req.Question, 2000)
 
 	prompt := fmt.Sprintf(`You are a Strategic Insight Analyst. Analyze the following business document and answer the user's question.
 
@@ -182,45 +269,47 @@ User Question:
 
 Your Response:`, contextText, req.Question)
 
-	response, err := ls.callHuggingFaceAPI(prompt)
+	call := llmCall{UserID: userID, DocumentID: documentID, Feature: featureInsight}
+	response, err := ls.callHuggingFaceAPI(ctx, call, prompt)
 	if err != nil {
-		http.Error(w, "LLM API error", http.StatusInternalServerError)
+		writeLLMError(w, r, err)
 		return
 	}
 
-	ls.saveChat(ctx, documentID, userID, req.Question, response)
+	ls.saveChat(ctx, documentID, userID, version, req.Question, response)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"response": response})
+	json.NewEncoder(w).Encode(map[string]any{"response": response, "version": version})
 }
 
 func (ls *LLMService) ChatWithDocument(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userID, err := getUserID(ctx)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		apierror.Write(w, r, errUnauthenticated)
 		return
 	}
 	documentID := mux.Vars(r)["documentId"]
 
 	var req struct {
 		Message string `json:"message"`
+		// Version selects the document version; 0 means the latest.
+		Version int `json:"version"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInvalidRequest, "Invalid request body"))
 		return
 	}
 
-	chunks, err := ls.getChunks(ctx, documentID)
-	if err != nil || len(chunks) == 0 {
-		http.Error(w, "Failed to retrieve document", http.StatusInternalServerError)
+	contextText, version, err := ls.retrieve(ctx, documentID, userID, req.Version, req.Message)
+	if err != nil {
+		apierror.Write(w, r, documentLookupError(err, "Failed to retrieve document"))
 		return
 	}
-	contextText := selectRelevantChunks(chunks, req.Message, 2000)
 
-	history, err := ls.getChatHistory(ctx, documentID, userID)
+	history, err := ls.getChatHistory(ctx, documentID, userID, version)
 	if err != nil {
-		http.Error(w, "Chat history error", http.StatusInternalServerError)
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to load chat history"))
 		return
 	}
 
@@ -246,55 +335,117 @@ Document Context:
 	}
 	prompt.WriteString("User: " + req.Message + "\nAI:")
 
-	response, err := ls.callHuggingFaceAPI(prompt.String())
+	call := llmCall{UserID: userID, DocumentID: documentID, Feature: featureChat}
+	response, err := ls.callHuggingFaceAPI(ctx, call, prompt.String())
 	if err != nil {
-		http.Error(w, "LLM API error", http.StatusInternalServerError)
+		writeLLMError(w, r, err)
 		return
 	}
 
-	ls.saveChat(ctx, documentID, userID, req.Message, response)
+	ls.saveChat(ctx, documentID, userID, version, req.Message, response)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"response": response})
+	json.NewEncoder(w).Encode(map[string]any{"response": response, "version": version})
 }
 
+// GetChatHistory returns the conversation one page at a time, oldest
+// first by default (order=desc for newest first).
 func (ls *LLMService) GetChatHistory(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	userID, err := getUserID(ctx)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		apierror.Write(w, r, errUnauthenticated)
 		return
 	}
 	documentID := mux.Vars(r)["documentId"]
 
-	rows, err := ls.db.QueryContext(ctx, `
-		SELECT id, message_type, message_content, timestamp
-		FROM chat_history
-		WHERE document_id = $1 AND user_id = $2
-		ORDER BY timestamp`, documentID, userID)
-	if err != nil {
-		http.Error(w, "Error retrieving chat history", http.StatusInternalServerError)
+	desc, aerr := parseOrder(r, false)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
 		return
 	}
-	defer rows.Close()
-
-	var history []ChatHistoryItem
-	for rows.Next() {
-		var item ChatHistoryItem
-		if err := rows.Scan(&item.ID, &item.Type, &item.Content, &item.Timestamp); err != nil {
-			http.Error(w, "Error scanning chat history", http.StatusInternalServerError)
+	page, aerr := parsePage(r)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+	version := 0
+	if v := r.URL.Query().Get("version"); v != "" {
+		if version, err = strconv.Atoi(v); err != nil || version < 1 {
+			apierror.Write(w, r, apierror.New(apierror.CodeInvalidRequest, "version must be a positive integer"))
 			return
 		}
-		history = append(history, item)
+	}
+	msgs, err := ls.chats.List(ctx, documentID, userID, repository.ChatQuery{Version: version, Desc: desc, Page: page})
+	if err != nil {
+		apierror.Write(w, r, listError(err, "Failed to load chat history"))
+		return
+	}
+
+	history := repository.Page[ChatHistoryItem]{
+		Items:      []ChatHistoryItem{},
+		NextCursor: msgs.NextCursor,
+		Total:      msgs.Total,
+	}
+	for _, m := range msgs.Items {
+		history.Items = append(history.Items, ChatHistoryItem{ID: m.ID, Type: m.Type, Content: m.Content, Timestamp: m.Timestamp, Version: m.Version})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(history)
 }
 
-func (ls *LLMService) callHuggingFaceAPI(prompt string) (string, error) {
-	hfURL := "https://api-inference.huggingface.co/models/HuggingFaceH4/zephyr-7b-beta"
-	apiKey := os.Getenv("HF_API_TOKEN")
+// Ping checks that the inference API is reachable and knows our model.
+func (ls *LLMService) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(ls.cfg.HFAPIURL, "/")+"/status/"+hfModel, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+ls.cfg.HFAPIToken.Value())
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HuggingFace status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// callHuggingFaceAPI sends prompt to the inference API once the call has
+// been reserved against the user's quotas; a *QuotaExceededError is
+// returned if it cannot be. Every call made, successful or not, is
+// metered against call.
+func (ls *LLMService) callHuggingFaceAPI(ctx context.Context, call llmCall, prompt string) (response string, err error) {
+	res, err := ls.quotas.Reserve(ctx, call.UserID)
+	if err != nil {
+		var qe *QuotaExceededError
+		if errors.As(err, &qe) {
+			return "", err
+		}
+		return "", apierror.Wrap(err, apierror.CodeInternal, "Failed to check quota")
+	}
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "llm.generate",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("llm.provider", hfProvider),
+			attribute.String("llm.model", hfModel),
+			attribute.String("llm.feature", call.Feature),
+			attribute.Int("llm.prompt_chars", len(prompt)),
+			attribute.Int("llm.prompt_tokens", estimateTokens(prompt)),
+		))
+	defer func() {
+		ls.recordUsage(ctx, res, call, prompt, response, time.Since(start), err)
+		span.SetAttributes(attribute.Int("llm.completion_tokens", estimateTokens(response)))
+		endSpan(span, err)
+	}()
+
+	hfURL := strings.TrimSuffix(ls.cfg.HFAPIURL, "/") + "/models/" + hfModel
+	apiKey := ls.cfg.HFAPIToken.Value()
 
 	body, err := json.Marshal(map[string]interface{}{
 		"inputs": prompt,
@@ -304,12 +455,12 @@ func (ls *LLMService) callHuggingFaceAPI(prompt string) (string, error) {
 		},
 	})
 	if err != nil {
-		return "", fmt.Errorf("marshal error: %v", err)
+		return "", fmt.Errorf("marshal error: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", hfURL, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", hfURL, bytes.NewBuffer(body))
 	if err != nil {
-		return "", fmt.Errorf("request error: %v", err)
+		return "", fmt.Errorf("request error: %w", err)
 	}
 	req.Header.Set("Authorization", "Bearer "+apiKey)
 	req.Header.Set("Content-Type", "application/json")
@@ -317,7 +468,7 @@ func (ls *LLMService) callHuggingFaceAPI(prompt string) (string, error) {
 	client := &http.Client{Timeout: 60 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("API call error: %v", err)
+		return "", fmt.Errorf("API call error: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -330,7 +481,7 @@ func (ls *LLMService) callHuggingFaceAPI(prompt string) (string, error) {
 		GeneratedText string `json:"generated_text"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&hfResp); err != nil {
-		return "", fmt.Errorf("decode error: %v", err)
+		return "", fmt.Errorf("decode error: %w", err)
 	}
 	if len(hfResp) == 0 {
 		return "", fmt.Errorf("no content in response")
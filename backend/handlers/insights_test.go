@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"strategic-insight-analyst/apierror"
+)
+
+func TestGenerateInsight(t *testing.T) {
+	ts := newTestServer(t, QuotaLimits{})
+	id := ts.upload(t, "alice", "report.txt", "Quarterly report.\n\nRevenue grew in every region.")
+
+	w := ts.do(t, "POST", "/api/documents/"+id+"/insights", "alice", map[string]any{"question": "How did revenue do?"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Response string `json:"response"`
+		Version  int    `json:"version"`
+	}
+	decode(t, w, &resp)
+	if resp.Response != ts.reply || resp.Version != 1 {
+		t.Errorf("response = %+v", resp)
+	}
+
+	w = ts.do(t, "GET", "/api/documents/"+id+"/chat/history", "alice", nil)
+	var history struct {
+		Items []ChatHistoryItem `json:"items"`
+	}
+	decode(t, w, &history)
+	if len(history.Items) != 2 || history.Items[0].Content != "How did revenue do?" || history.Items[1].Content != ts.reply {
+		t.Errorf("history = %+v", history.Items)
+	}
+
+	var quota QuotaStatus
+	decode(t, ts.do(t, "GET", "/api/quota", "alice", nil), &quota)
+	if quota.Daily.Calls.Used != 1 || quota.Monthly.Calls.Used != 1 || quota.Daily.Tokens.Used == 0 {
+		t.Errorf("quota = %+v, want one call charged", quota)
+	}
+
+	var usage []UsageReportRow
+	decode(t, ts.do(t, "GET", "/api/usage", "alice", nil), &usage)
+	if len(usage) != 1 || usage[0].Calls != 1 || usage[0].Errors != 0 || usage[0].EstimatedCost <= 0 {
+		t.Errorf("usage = %+v, want one metered call", usage)
+	}
+}
+
+func TestChatHistoryIsPerVersion(t *testing.T) {
+	ts := newTestServer(t, QuotaLimits{})
+	id := ts.upload(t, "alice", "report.txt", "Revenue grew in every region.")
+	chat := func(message string, version int) string {
+		t.Helper()
+		w := ts.do(t, "POST", "/api/documents/"+id+"/chat", "alice", map[string]any{"message": message, "version": version})
+		if w.Code != http.StatusOK {
+			t.Fatalf("chat: status = %d, body %s", w.Code, w.Body)
+		}
+		return *ts.lastPrompt.Load()
+	}
+	chat("What about the first draft?", 1)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("document", "report.txt")
+	io.WriteString(fw, "Revenue fell in every region.")
+	mw.Close()
+	if w := ts.do(t, "POST", "/api/documents/"+id+"/versions", "alice", &body, "Content-Type", mw.FormDataContentType()); w.Code != http.StatusOK {
+		t.Fatalf("add version: status = %d, body %s", w.Code, w.Body)
+	}
+
+	if prompt := chat("And now?", 2); strings.Contains(prompt, "first draft") {
+		t.Errorf("version 2 prompt includes version 1 history:\n%s", prompt)
+	}
+	if prompt := chat("Anything else?", 1); !strings.Contains(prompt, "first draft") || strings.Contains(prompt, "And now?") {
+		t.Errorf("version 1 prompt has the wrong history:\n%s", prompt)
+	}
+}
+
+func TestGenerateInsightErrors(t *testing.T) {
+	ts := newTestServer(t, QuotaLimits{DailyCalls: 1})
+	id := ts.upload(t, "alice", "report.txt", "Revenue grew in every region.")
+	ask := func(userID, documentID string, body any) httpResponse {
+		w := ts.do(t, "POST", "/api/documents/"+documentID+"/insights", userID, body)
+		return httpResponse{w.Code, errorCode(t, w)}
+	}
+	question := map[string]any{"question": "Why?"}
+
+	if got := ask("bob", id, question); got != (httpResponse{http.StatusNotFound, "not_found"}) {
+		t.Errorf("another user's document: %+v", got)
+	}
+	if got := ask("alice", id, map[string]any{"question": "Why?", "version": 2}); got != (httpResponse{http.StatusNotFound, "not_found"}) {
+		t.Errorf("unknown version: %+v", got)
+	}
+	if got := ask("alice", id, "not an object"); got.status != http.StatusBadRequest {
+		t.Errorf("invalid body: %+v", got)
+	}
+
+	ts.failStatus.Store(http.StatusServiceUnavailable)
+	if got := ask("alice", id, question); got != (httpResponse{http.StatusBadGateway, "provider_unavailable"}) {
+		t.Errorf("provider failure: %+v", got)
+	}
+	ts.failStatus.Store(0)
+	var usage []UsageReportRow
+	decode(t, ts.do(t, "GET", "/api/usage", "alice", nil), &usage)
+	if len(usage) != 1 || usage[0].Errors != 1 {
+		t.Errorf("usage = %+v, want the failed call metered", usage)
+	}
+
+	// Failed calls are not charged, so the one call allowed is still left.
+	if w := ts.do(t, "POST", "/api/documents/"+id+"/insights", "alice", question); w.Code != http.StatusOK {
+		t.Fatalf("first call: status = %d, body %s", w.Code, w.Body)
+	}
+	calls := ts.llmCalls.Load()
+	w := ts.do(t, "POST", "/api/documents/"+id+"/insights", "alice", question)
+	if w.Code != http.StatusTooManyRequests || errorCode(t, w) != "quota_exceeded" {
+		t.Errorf("over quota: status = %d, body %s", w.Code, w.Body)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("over quota: no Retry-After header")
+	}
+	if ts.llmCalls.Load() != calls {
+		t.Error("over quota: the model was still called")
+	}
+}
+
+func TestQuotaReservationIsAtomic(t *testing.T) {
+	const limit, requests = 3, 12
+	ts := newTestServer(t, QuotaLimits{DailyCalls: limit})
+	id := ts.upload(t, "alice", "report.txt", "Revenue grew in every region.")
+
+	var wg sync.WaitGroup
+	statuses := make([]int, requests)
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = ts.do(t, "POST", "/api/documents/"+id+"/chat", "alice", map[string]any{"message": "Why?"}).Code
+		}()
+	}
+	wg.Wait()
+
+	counts := map[int]int{}
+	for _, status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusOK] != limit || counts[http.StatusTooManyRequests] != requests-limit {
+		t.Errorf("statuses = %v, want %d OK and the rest 429", counts, limit)
+	}
+	if got := ts.llmCalls.Load(); got != limit {
+		t.Errorf("model called %d times, want %d", got, limit)
+	}
+	var quota QuotaStatus
+	decode(t, ts.do(t, "GET", "/api/quota", "alice", nil), &quota)
+	if quota.Daily.Calls.Used != limit || quota.Daily.Tokens.Used == 0 {
+		t.Errorf("quota = %+v, want %d calls with their tokens", quota.Daily, limit)
+	}
+}
+
+func TestGenerateInsightWithoutText(t *testing.T) {
+	ts := newTestServer(t, QuotaLimits{})
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("document", "blank.txt")
+	io.WriteString(fw, " \n ")
+	mw.Close()
+	w := ts.do(t, "POST", "/api/documents", "alice", &body, "Content-Type", mw.FormDataContentType())
+	var failed struct {
+		Error struct {
+			Details map[string]any `json:"details"`
+		} `json:"error"`
+	}
+	decode(t, w, &failed)
+	id, _ := failed.Error.Details["documentId"].(string)
+	if w.Code != http.StatusUnprocessableEntity || id == "" {
+		t.Fatalf("upload: status = %d, body %s", w.Code, w.Body)
+	}
+
+	w = ts.do(t, "POST", "/api/documents/"+id+"/insights", "alice", map[string]any{"question": "Why?"})
+	if w.Code != http.StatusConflict || errorCode(t, w) != "document_not_processed" {
+		t.Errorf("status = %d, body %s", w.Code, w.Body)
+	}
+	if ts.llmCalls.Load() != 0 {
+		t.Error("the model was called without document text")
+	}
+}
+
+func TestErrProviderUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want apierror.Code
+	}{
+		{"provider error", errors.New("HuggingFace error 503: loading"), apierror.CodeProviderUnavailable},
+		{"connection refused", fmt.Errorf("API call error: %w",
+			&url.Error{Op: "Post", URL: "http://hf", Err: errors.New("connection refused")}), apierror.CodeProviderUnavailable},
+		{"request deadline", fmt.Errorf("API call error: %w",
+			&url.Error{Op: "Post", URL: "http://hf", Err: context.DeadlineExceeded}), apierror.CodeTimeout},
+		{"client timeout", fmt.Errorf("API call error: %w",
+			&url.Error{Op: "Post", URL: "http://hf", Err: timeoutError{}}), apierror.CodeTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errProviderUnavailable(tt.err).Code; got != tt.want {
+				t.Errorf("code = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// timeoutError is a net.Error that timed out, as returned when an
+// http.Client's Timeout elapses.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "Client.Timeout exceeded while awaiting headers" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// httpResponse is the status and error code of a response.
+type httpResponse struct {
+	status int
+	code   string
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/repository"
+)
+
+// pageBreak separates the pages of text extracted from a PDF, as
+// pdftotext does.
+const pageBreak = "\f"
+
+// pageRange returns the byte range of page n, counted from 1, of text
+// whose pages end with a page break. ok is false if text has fewer pages.
+func pageRange(text string, n int) (start, end int, ok bool) {
+	for i := 1; i < n; i++ {
+		j := strings.Index(text[start:], pageBreak)
+		if j < 0 {
+			return 0, 0, false
+		}
+		start += j + len(pageBreak)
+	}
+	if start == len(text) && n > 1 {
+		return 0, 0, false // the break ending the last page
+	}
+	end = len(text)
+	if j := strings.Index(text[start:], pageBreak); j >= 0 {
+		end = start + j
+	}
+	return start, end, true
+}
+
+// textResponse is the extracted text of a document version, or of one of
+// its pages. Start and End are the text's byte offsets in the version's
+// full text, as for chunks.
+type textResponse struct {
+	DocumentID string `json:"documentId"`
+	Version    int    `json:"version"`
+	Extractor  string `json:"extractor"`
+	PageCount  int    `json:"pageCount"`
+	Page       int    `json:"page,omitempty"`
+	Start      int    `json:"start"`
+	End        int    `json:"end"`
+	Text       string `json:"text"`
+}
+
+// DocumentText returns the text extracted from a document version, as
+// stored for retrieval. The "page" query parameter narrows it to one page.
+func (ds *DocumentService) DocumentText(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	version, aerr := ds.requestedVersion(ctx, r, userID)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+	page, aerr := versionParam(r, "page", 0)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+	setAuditDetail(ctx, "version", strconv.Itoa(version.Version))
+	if page > 0 {
+		setAuditDetail(ctx, "page", strconv.Itoa(page))
+	}
+
+	text, err := ds.versionText(ctx, version.DocumentID, version.Version)
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to load document text"))
+		return
+	}
+	response := textResponse{
+		DocumentID: version.DocumentID,
+		Version:    version.Version,
+		Extractor:  version.Extractor,
+		PageCount:  version.PageCount,
+		Page:       page,
+		End:        len(text),
+		Text:       text,
+	}
+	if page > 0 {
+		// Text extracted before pages were separated is one long page.
+		if version.PageCount > 1 && !strings.Contains(text, pageBreak) {
+			apierror.Write(w, r, apierror.New(apierror.CodeConflict,
+				"The page breaks of this version were not recorded; reprocess it to read it by page"))
+			return
+		}
+		start, end, ok := pageRange(text, page)
+		if !ok {
+			apierror.Write(w, r, apierror.New(apierror.CodeNotFound, "Page not found").
+				WithDetails(map[string]any{"pageCount": version.PageCount}))
+			return
+		}
+		response.Start, response.End, response.Text = start, end, text[start:end]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// chunkInfo is a stored chunk with the number of tokens it adds to a
+// prompt.
+type chunkInfo struct {
+	repository.Chunk
+	TokenCount int `json:"tokenCount"`
+}
+
+// ListChunks returns one page of the chunks of a document version, in
+// order, as they are fed to insights and chat.
+func (ds *DocumentService) ListChunks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+	page, aerr := parsePage(r)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+
+	version, aerr := ds.requestedVersion(ctx, r, userID)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+	setAuditDetail(ctx, "version", strconv.Itoa(version.Version))
+
+	chunks, err := ds.chunks.ListDetails(ctx, version.DocumentID, version.Version, page)
+	if err != nil {
+		apierror.Write(w, r, listError(err, "Failed to list chunks"))
+		return
+	}
+	response := repository.Page[chunkInfo]{
+		Items:      make([]chunkInfo, len(chunks.Items)),
+		NextCursor: chunks.NextCursor,
+		Total:      chunks.Total,
+	}
+	for i, c := range chunks.Items {
+		response.Items[i] = chunkInfo{Chunk: c, TokenCount: estimateTokens(c.Content)}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"rsc.io/pdf"
+)
+
+// sniffMediaType returns the media type of data, without parameters, as
+// detected from its content rather than its name.
+func sniffMediaType(data []byte) string {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mediaType
+}
+
+// contentMatchesExtension reports whether a sniffed media type is
+// plausible for a file with the given extension.
+func contentMatchesExtension(mediaType, fileExt string) bool {
+	switch fileExt {
+	case ".pdf":
+		return mediaType == "application/pdf"
+	case ".txt":
+		return mediaType == "text/plain"
+	}
+	return false
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// countPages returns the number of pages of a PDF, or 0 if the file
+// cannot be parsed.
+func countPages(data []byte) (n int) {
+	// rsc.io/pdf panics on some malformed files.
+	defer func() {
+		if recover() != nil {
+			n = 0
+		}
+	}()
+	r, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return 0
+	}
+	return r.NumPage()
+}
+
+func countWords(text string) int {
+	return len(strings.Fields(text))
+}
+
+// languageSampleWords bounds how much of a document detectLanguage reads.
+const languageSampleWords = 5000
+
+// stopwords are very frequent short words of each language that
+// detectLanguage recognises, keyed by ISO 639-1 code.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "in", "is", "that", "for", "with", "this", "are", "be", "on", "as", "by", "it"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "mit", "den", "von", "zu", "ein", "eine", "für", "auf", "sich", "dem"},
+	"fr": {"le", "la", "les", "et", "des", "est", "une", "du", "que", "pour", "dans", "qui", "au", "pas", "sur", "avec"},
+	"es": {"el", "la", "los", "las", "y", "que", "es", "del", "por", "una", "para", "con", "como", "se", "su", "al"},
+	"it": {"il", "di", "che", "la", "per", "non", "una", "sono", "della", "gli", "con", "del", "le", "nel", "alla", "è"},
+	"pt": {"o", "os", "que", "não", "uma", "para", "com", "do", "da", "em", "se", "mais", "das", "dos", "como", "é"},
+	"nl": {"de", "het", "een", "en", "van", "niet", "dat", "op", "voor", "met", "zijn", "ook", "aan", "bij", "wordt", "dit"},
+}
+
+var stopwordLanguages = func() map[string][]string {
+	index := make(map[string][]string)
+	for lang, words := range stopwords {
+		for _, w := range words {
+			index[w] = append(index[w], lang)
+		}
+	}
+	return index
+}()
+
+// detectLanguage guesses the language of text by counting stopwords and
+// returns its ISO 639-1 code, or "" when no language stands out.
+func detectLanguage(text string) string {
+	scores := make(map[string]int)
+	words := 0
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		if words++; words > languageSampleWords {
+			break
+		}
+		for _, lang := range stopwordLanguages[w] {
+			scores[lang]++
+		}
+	}
+
+	best, bestScore, runnerUp := "", 0, 0
+	for lang, score := range scores {
+		switch {
+		case score > bestScore:
+			best, bestScore, runnerUp = lang, score, bestScore
+		case score > runnerUp:
+			runnerUp = score
+		}
+	}
+	// Require a meaningful share of stopwords and a clear winner over
+	// related languages.
+	if bestScore < 5 || bestScore*20 < min(words, languageSampleWords) || bestScore*2 < runnerUp*3 {
+		return ""
+	}
+	return best
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Extractor names, used as the extractor label and recorded on each
+// document.
+const (
+	extractorOCRSpace  = "ocrspace"
+	extractorPDFToText = "pdftotext"
+	extractorRSCPDF    = "rsc_pdf"
+	extractorPlainText = "plaintext"
+)
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route template, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	uploadSizeBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "document_upload_size_bytes",
+		Help:    "Size of uploaded documents.",
+		Buckets: prometheus.ExponentialBuckets(1<<10, 4, 8), // 1KiB .. 16MiB
+	})
+
+	extractionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "document_extraction_duration_seconds",
+		Help:    "Duration of each text extraction attempt by extractor and outcome.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10), // 50ms .. ~25s
+	}, []string{"extractor", "outcome"})
+
+	documentChunks = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "document_chunks",
+		Help:    "Number of chunks stored per uploaded document.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8), // 1 .. 16384
+	})
+
+	retrievalDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "retrieval_duration_seconds",
+		Help:    "Time to load a document's chunks and select the prompt context.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	llmRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "llm_request_duration_seconds",
+		Help:    "LLM request latency by provider, model and status.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 2, 9), // 250ms .. 64s
+	}, []string{"provider", "model", "status"})
+
+	llmTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llm_tokens_total",
+		Help: "Estimated LLM tokens by provider, model and kind (prompt or completion).",
+	}, []string{"provider", "model", "kind"})
+
+	llmErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "llm_errors_total",
+		Help: "Failed LLM requests by provider and model.",
+	}, []string{"provider", "model"})
+
+	blobRemovalFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "blob_removal_failures_total",
+		Help: "Failed attempts to remove a deleted document's blob from storage.",
+	})
+)
+
+// RegisterDBStats exports the connection pool statistics of db.
+func RegisterDBStats(db *sql.DB, name string) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// MetricsHandler serves every registered metric in the Prometheus text
+// format.
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Metrics records request latency per route. It must be installed with
+// Router.Use so the matched route template is known; labelling by
+// template rather than path keeps document IDs out of the label set.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		route := "unknown"
+		if cur := mux.CurrentRoute(r); cur != nil {
+			if tpl, err := cur.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		httpRequestDuration.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+// startExtractionTimer times one extraction attempt; call the returned
+// function when it finishes.
+func startExtractionTimer(extractor string) func(ok bool) {
+	start := time.Now()
+	return func(ok bool) {
+		outcome := "ok"
+		if !ok {
+			outcome = "failed"
+		}
+		extractionDuration.WithLabelValues(extractor, outcome).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/repository"
+)
+
+// parsePage reads the pagination parameters shared by every listing:
+// limit (1-100) and cursor (the nextCursor of the previous page).
+func parsePage(r *http.Request) (repository.PageRequest, *apierror.Error) {
+	q := r.URL.Query()
+	page := repository.PageRequest{Cursor: q.Get("cursor")}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > repository.MaxPageSize {
+			return page, apierror.New(apierror.CodeInvalidRequest,
+				"limit must be between 1 and "+strconv.Itoa(repository.MaxPageSize))
+		}
+		page.Limit = n
+	}
+	return page, nil
+}
+
+// parseOrder reads the order parameter ("asc" or "desc"), falling back to
+// the listing's natural direction.
+func parseOrder(r *http.Request, defaultDesc bool) (bool, *apierror.Error) {
+	switch r.URL.Query().Get("order") {
+	case "":
+		return defaultDesc, nil
+	case "asc":
+		return false, nil
+	case "desc":
+		return true, nil
+	}
+	return false, apierror.New(apierror.CodeInvalidRequest, "order must be asc or desc")
+}
+
+// parseDate accepts a calendar date (YYYY-MM-DD) or an RFC 3339 time. A
+// bare date used as an upper bound covers the whole day.
+func parseDate(v string, upper bool) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, true
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, true
+}
+
+var fileTypePattern = regexp.MustCompile(`^[a-z0-9]{1,10}$`)
+
+var (
+	mimeTypePattern = regexp.MustCompile(`^[a-z0-9.+-]+/[a-z0-9.+-]+$`)
+	languagePattern = regexp.MustCompile(`^[a-z]{2}$`)
+)
+
+var extractors = map[string]bool{
+	extractorOCRSpace:  true,
+	extractorPDFToText: true,
+	extractorRSCPDF:    true,
+	extractorPlainText: true,
+}
+
+var documentStatuses = map[string]bool{
+	repository.StatusProcessing: true,
+	repository.StatusReady:      true,
+	repository.StatusFailed:     true,
+}
+
+// parseDocumentQuery reads the sort, filter and pagination parameters of
+// the document listing.
+func parseDocumentQuery(r *http.Request) (repository.DocumentQuery, *apierror.Error) {
+	q := r.URL.Query()
+	var dq repository.DocumentQuery
+
+	dq.Sort = q.Get("sort")
+	switch dq.Sort {
+	case "":
+		dq.Sort = repository.SortUploaded
+	case repository.SortUploaded, repository.SortName, repository.SortSize:
+	default:
+		return dq, apierror.New(apierror.CodeInvalidRequest, "sort must be one of name, uploaded or size")
+	}
+	// Newest and largest first; names alphabetically.
+	desc, aerr := parseOrder(r, dq.Sort != repository.SortName)
+	if aerr != nil {
+		return dq, aerr
+	}
+	dq.Desc = desc
+
+	if v := q.Get("type"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(t)), ".")
+			if !fileTypePattern.MatchString(t) {
+				return dq, apierror.New(apierror.CodeInvalidRequest, "type must be a comma-separated list of file extensions")
+			}
+			dq.Types = append(dq.Types, t)
+		}
+	}
+	if v := q.Get("status"); v != "" {
+		if !documentStatuses[v] {
+			return dq, apierror.New(apierror.CodeInvalidRequest, "status must be one of processing, ready or failed")
+		}
+		dq.Status = v
+	}
+	if v := q.Get("from"); v != "" {
+		t, ok := parseDate(v, false)
+		if !ok {
+			return dq, apierror.New(apierror.CodeInvalidRequest, "from must be a date (YYYY-MM-DD) or RFC 3339 time")
+		}
+		dq.UploadedFrom = t
+	}
+	if v := q.Get("to"); v != "" {
+		t, ok := parseDate(v, true)
+		if !ok {
+			return dq, apierror.New(apierror.CodeInvalidRequest, "to must be a date (YYYY-MM-DD) or RFC 3339 time")
+		}
+		dq.UploadedTo = t
+	}
+	if v := q.Get("mimeType"); v != "" {
+		if !mimeTypePattern.MatchString(v) {
+			return dq, apierror.New(apierror.CodeInvalidRequest, "mimeType must be a media type such as application/pdf")
+		}
+		dq.MimeType = v
+	}
+	if v := q.Get("language"); v != "" {
+		if !languagePattern.MatchString(v) {
+			return dq, apierror.New(apierror.CodeInvalidRequest, "language must be a two-letter ISO 639-1 code")
+		}
+		dq.Language = v
+	}
+	if v := q.Get("extractor"); v != "" {
+		if !extractors[v] {
+			return dq, apierror.New(apierror.CodeInvalidRequest, "extractor must be one of ocrspace, pdftotext, rsc_pdf or plaintext")
+		}
+		dq.Extractor = v
+	}
+	for _, bound := range []struct {
+		param string
+		dst   *int64
+	}{{"minSize", &dq.MinSize}, {"maxSize", &dq.MaxSize}} {
+		if v := q.Get(bound.param); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return dq, apierror.New(apierror.CodeInvalidRequest, bound.param+" must be a non-negative number of bytes")
+			}
+			*bound.dst = n
+		}
+	}
+
+	dq.FolderID = q.Get("folderId")
+	dq.IncludeSubfolders = q.Get("includeSubfolders") == "true"
+	dq.Unfiled = q.Get("unfiled") == "true"
+	if dq.Unfiled && dq.FolderID != "" {
+		return dq, apierror.New(apierror.CodeInvalidRequest, "folderId and unfiled cannot be combined")
+	}
+	if v := q.Get("tag"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			name, ok := normalizeTagName(t)
+			if !ok {
+				return dq, apierror.New(apierror.CodeInvalidRequest, "tag must be a comma-separated list of tag names")
+			}
+			dq.Tags = append(dq.Tags, name)
+		}
+	}
+
+	page, aerr := parsePage(r)
+	if aerr != nil {
+		return dq, aerr
+	}
+	dq.Page = page
+	return dq, nil
+}
+
+// listError reports a failed listing: a bad request for a cursor the
+// repository rejected, otherwise an internal error described by msg.
+func listError(err error, msg string) *apierror.Error {
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		return apierror.Wrap(err, apierror.CodeInvalidRequest, "cursor is invalid or does not match the requested ordering")
+	}
+	return apierror.Wrap(err, apierror.CodeInternal, msg)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"strategic-insight-analyst/blobstore"
+	"strategic-insight-analyst/repository"
+)
+
+// purgeBatchSize bounds the documents and blobs handled per query.
+const purgeBatchSize = 100
+
+// A failed blob removal is retried after blobRetryBase, doubling with
+// every further failure up to blobRetryMax.
+const (
+	blobRetryBase = time.Minute
+	blobRetryMax  = 24 * time.Hour
+)
+
+// Purger permanently deletes documents that have been in the trash longer
+// than the retention period, and removes the blobs that deletes leave
+// behind, retrying removals that failed.
+type Purger struct {
+	documents repository.DocumentRepo
+	queue     repository.BlobQueueRepo
+	blobs     blobstore.Store
+	retention time.Duration
+}
+
+func NewPurger(repos repository.Repos, blobs blobstore.Store, retention time.Duration) *Purger {
+	return &Purger{documents: repos.Documents, queue: repos.BlobQueue, blobs: blobs, retention: retention}
+}
+
+// Run purges once per interval until ctx is cancelled.
+func (p *Purger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := p.RunOnce(ctx); err != nil {
+			slog.ErrorContext(ctx, "purger pass failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce performs a single purge pass.
+func (p *Purger) RunOnce(ctx context.Context) error {
+	if err := p.purgeExpired(ctx, time.Now().Add(-p.retention)); err != nil {
+		return err
+	}
+	return p.removeQueuedBlobs(ctx)
+}
+
+// purgeExpired deletes the documents put in the trash before cutoff.
+// Chunks, versions and chat history cascade with the row; the blobs are
+// queued for removal.
+func (p *Purger) purgeExpired(ctx context.Context, cutoff time.Time) error {
+	for {
+		docs, err := p.documents.ListExpired(ctx, cutoff, purgeBatchSize)
+		if err != nil {
+			return err
+		}
+		purged := 0
+		for _, doc := range docs {
+			_, err := p.documents.Purge(ctx, doc.ID, doc.UserID)
+			if errors.Is(err, repository.ErrNotFound) {
+				continue // restored or purged meanwhile
+			}
+			if err != nil {
+				slog.ErrorContext(ctx, "purger: delete document failed", "document_id", doc.ID, "error", err)
+				continue
+			}
+			purged++
+		}
+		if purged > 0 {
+			slog.InfoContext(ctx, "purger: deleted expired documents", "count", purged)
+		}
+		// Stop when the trash is drained, or when nothing more could be
+		// purged so that failures are not retried in a tight loop.
+		if len(docs) < purgeBatchSize || purged == 0 {
+			return nil
+		}
+	}
+}
+
+// removeQueuedBlobs removes the queued blobs that are due.
+func (p *Purger) removeQueuedBlobs(ctx context.Context) error {
+	due, err := p.queue.Due(ctx, time.Now(), purgeBatchSize)
+	if err != nil || len(due) == 0 {
+		return err
+	}
+	paths := make([]string, len(due))
+	for i, b := range due {
+		paths[i] = b.Path
+	}
+	// A queued blob is unreferenced by construction; check anyway, since
+	// removing a blob a document still points to cannot be undone.
+	referenced, err := p.documents.ReferencedPaths(ctx, paths)
+	if err != nil {
+		return err
+	}
+	var orphaned []repository.PendingBlob
+	for _, b := range due {
+		if referenced[b.Path] {
+			slog.WarnContext(ctx, "purger: queued blob is referenced, keeping it", "path", b.Path)
+			if err := p.queue.Done(ctx, b.Path); err != nil {
+				return err
+			}
+			continue
+		}
+		orphaned = append(orphaned, b)
+	}
+	return removeBlobs(ctx, p.queue, p.blobs, orphaned)
+}
+
+// removeBlobs removes queued blobs from storage and takes them off the
+// queue. If the removal fails they stay queued, to be retried after a
+// delay that grows with every failed attempt.
+func removeBlobs(ctx context.Context, queue repository.BlobQueueRepo, blobs blobstore.Store, pending []repository.PendingBlob) error {
+	if len(pending) == 0 {
+		return nil
+	}
+	paths := make([]string, len(pending))
+	for i, b := range pending {
+		paths[i] = b.Path
+	}
+	removeErr := blobs.Remove(ctx, paths...)
+	if removeErr == nil {
+		return queue.Done(ctx, paths...)
+	}
+	now := time.Now()
+	for _, b := range pending {
+		if err := queue.Failed(ctx, b.Path, removeErr.Error(), now.Add(blobRetryDelay(b.Attempts+1))); err != nil {
+			return err
+		}
+	}
+	blobRemovalFailures.Add(float64(len(pending)))
+	return removeErr
+}
+
+// blobRetryDelay returns how long to wait after the given number of failed
+// removal attempts.
+func blobRetryDelay(attempts int) time.Duration {
+	delay := blobRetryBase
+	for i := 1; i < attempts && delay < blobRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, blobRetryMax)
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/repository"
+)
+
+// QuotaLimits caps LLM usage per period. A zero value means unlimited.
+type QuotaLimits = repository.QuotaLimits
+
+// QuotaService enforces daily and monthly LLM call/token quotas. Defaults
+// apply to every user; rows in llm_quotas override them per user. Quotas
+// are per user only: documents and usage belong to users, and there are no
+// shared workspaces to pool them by.
+type QuotaService struct {
+	quotas   repository.QuotaRepo
+	defaults QuotaLimits
+}
+
+func NewQuotaService(repos repository.Repos, defaults QuotaLimits) *QuotaService {
+	return &QuotaService{quotas: repos.Quotas, defaults: defaults}
+}
+
+// QuotaExceededError is returned by Reserve when a user has used up one of
+// their quotas.
+type QuotaExceededError struct {
+	Period  string
+	Metric  string
+	Limit   int64
+	Used    int64
+	ResetAt time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s %s quota exceeded (%d/%d)", e.Period, e.Metric, e.Used, e.Limit)
+}
+
+type periodUsage struct {
+	Calls    usageCounter `json:"calls"`
+	Tokens   usageCounter `json:"tokens"`
+	ResetsAt time.Time    `json:"resetsAt"`
+}
+
+type usageCounter struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit"`
+}
+
+type QuotaStatus struct {
+	Daily   periodUsage `json:"daily"`
+	Monthly periodUsage `json:"monthly"`
+}
+
+func periodBounds(now time.Time) (day, nextDay, month, nextMonth time.Time) {
+	now = now.UTC()
+	day = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return day, day.AddDate(0, 0, 1), month, month.AddDate(0, 1, 0)
+}
+
+// newQuotaStatus reports usage against limits at now.
+func newQuotaStatus(limits QuotaLimits, daily, monthly repository.QuotaUsage, now time.Time) QuotaStatus {
+	_, nextDay, _, nextMonth := periodBounds(now)
+	return QuotaStatus{
+		Daily: periodUsage{
+			Calls:    usageCounter{Used: daily.Calls, Limit: limits.DailyCalls},
+			Tokens:   usageCounter{Used: daily.Tokens, Limit: limits.DailyTokens},
+			ResetsAt: nextDay,
+		},
+		Monthly: periodUsage{
+			Calls:    usageCounter{Used: monthly.Calls, Limit: limits.MonthlyCalls},
+			Tokens:   usageCounter{Used: monthly.Tokens, Limit: limits.MonthlyTokens},
+			ResetsAt: nextMonth,
+		},
+	}
+}
+
+// Status reports the user's usage in the current day and month.
+func (qs *QuotaService) Status(ctx context.Context, userID string) (QuotaStatus, error) {
+	limits, err := qs.quotas.Limits(ctx, userID, qs.defaults)
+	if err != nil {
+		return QuotaStatus{}, err
+	}
+	now := time.Now()
+	day, _, month, _ := periodBounds(now)
+	daily, monthly, err := qs.quotas.Usage(ctx, userID, day, month)
+	if err != nil {
+		return QuotaStatus{}, err
+	}
+	return newQuotaStatus(limits, daily, monthly, now), nil
+}
+
+// exceeded returns the first quota in s that leaves no room for another
+// call, or nil.
+func (s QuotaStatus) exceeded() *QuotaExceededError {
+	checks := []struct {
+		period, metric string
+		counter        usageCounter
+		resetAt        time.Time
+	}{
+		{"daily", "calls", s.Daily.Calls, s.Daily.ResetsAt},
+		{"daily", "tokens", s.Daily.Tokens, s.Daily.ResetsAt},
+		{"monthly", "calls", s.Monthly.Calls, s.Monthly.ResetsAt},
+		{"monthly", "tokens", s.Monthly.Tokens, s.Monthly.ResetsAt},
+	}
+	for _, c := range checks {
+		if c.counter.Limit > 0 && c.counter.Used >= c.counter.Limit {
+			return &QuotaExceededError{
+				Period:  c.period,
+				Metric:  c.metric,
+				Limit:   c.counter.Limit,
+				Used:    c.counter.Used,
+				ResetAt: c.resetAt,
+			}
+		}
+	}
+	return nil
+}
+
+// QuotaReservation is an LLM call charged to a user's quotas before it is
+// made. Its tokens are only known afterwards, and are charged by Settle.
+type QuotaReservation struct {
+	quotas     repository.QuotaRepo
+	userID     string
+	day, month time.Time
+}
+
+// Reserve charges one LLM call to the user's current day and month, or
+// returns a *QuotaExceededError if a quota is used up. The check and the
+// charge are one step, so concurrent calls cannot all pass the check
+// before any of them is charged.
+func (qs *QuotaService) Reserve(ctx context.Context, userID string) (*QuotaReservation, error) {
+	limits, err := qs.quotas.Limits(ctx, userID, qs.defaults)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	day, _, month, _ := periodBounds(now)
+	daily, monthly, ok, err := qs.quotas.Reserve(ctx, userID, day, month, limits)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if qe := newQuotaStatus(limits, daily, monthly, now).exceeded(); qe != nil {
+			return nil, qe
+		}
+		return nil, fmt.Errorf("quota reservation refused within limits %+v", limits)
+	}
+	return &QuotaReservation{quotas: qs.quotas, userID: userID, day: day, month: month}, nil
+}
+
+// Settle charges the tokens of the reserved call, to the periods it was
+// reserved in. A failed call is not charged: its reservation is given
+// back.
+func (res *QuotaReservation) Settle(ctx context.Context, tokens int, failed bool) error {
+	if failed {
+		return res.quotas.Settle(ctx, res.userID, res.day, res.month, -1, 0)
+	}
+	return res.quotas.Settle(ctx, res.userID, res.day, res.month, 0, tokens)
+}
+
+// GetQuota returns the caller's current LLM usage against their quotas.
+func (qs *QuotaService) GetQuota(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	status, err := qs.Status(ctx, userID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to load quota"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// writeQuotaExceeded sends a 429 describing which quota was exhausted.
+func writeQuotaExceeded(w http.ResponseWriter, r *http.Request, qe *QuotaExceededError) {
+	wait := time.Until(qe.ResetAt)
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+	writeRateLimitHeaders(w, int(qe.Limit), 0, wait)
+	apierror.Write(w, r, apierror.Wrap(qe, apierror.CodeQuotaExceeded, "LLM "+qe.Period+" "+qe.Metric+" quota exceeded").
+		WithDetails(map[string]any{
+			"period":  qe.Period,
+			"metric":  qe.Metric,
+			"limit":   qe.Limit,
+			"used":    qe.Used,
+			"resetAt": qe.ResetAt,
+		}))
+}
+
+// estimateTokens approximates the token count of s. The inference API does
+// not report usage, so we use the common ~4 characters per token rule.
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"strategic-insight-analyst/apierror"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit describes a token bucket: Rate tokens are added per second up
+// to a maximum of Burst.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimiter keeps one token bucket per (route class, user) pair.
+type RateLimiter struct {
+	limits map[string]RateLimit
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Buckets that have not been touched for this long are dropped; a fresh
+// bucket starts full so forgetting an idle one is harmless.
+const bucketIdleTTL = 10 * time.Minute
+
+func NewRateLimiter(limits map[string]RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limits:    limits,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Middleware applies the bucket of the given route class to every request
+// passing through it. Classes without a configured limit are not limited.
+func (rl *RateLimiter) Middleware(class string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limit, ok := rl.limits[class]
+		if !ok || limit.Rate <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			now := time.Now()
+			lim := rl.bucketFor(class+":"+rateLimitKey(r), limit, now)
+
+			res := lim.ReserveN(now, 1)
+			delay := res.DelayFrom(now)
+			if !res.OK() || delay > 0 {
+				res.CancelAt(now)
+				writeRateLimitHeaders(w, limit.Burst, 0, delay)
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(delay)))
+				apierror.Write(w, r, apierror.New(apierror.CodeRateLimited, "Rate limit exceeded").
+					WithDetails(map[string]any{"retryAfterSeconds": ceilSeconds(delay)}))
+				return
+			}
+
+			remaining := int(math.Floor(lim.TokensAt(now)))
+			refill := time.Duration(float64(limit.Burst-remaining) / limit.Rate * float64(time.Second))
+			writeRateLimitHeaders(w, limit.Burst, remaining, refill)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Limit wraps a single handler with the bucket of the given route class.
+func (rl *RateLimiter) Limit(class string, h http.HandlerFunc) http.Handler {
+	return rl.Middleware(class)(h)
+}
+
+func (rl *RateLimiter) bucketFor(key string, limit RateLimit, now time.Time) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if now.Sub(rl.lastSweep) > bucketIdleTTL {
+		for k, b := range rl.buckets {
+			if now.Sub(b.lastSeen) > bucketIdleTTL {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		rl.buckets[key] = b
+	}
+	b.lastSeen = now
+	return b.limiter
+}
+
+// rateLimitKey identifies the caller: the authenticated user when there is
+// one, otherwise the client IP.
+func rateLimitKey(r *http.Request) string {
+	if userID, err := getUserID(r.Context()); err == nil {
+		return "user:" + userID
+	}
+	return "ip:" + clientIP(r)
+}
+
+func writeRateLimitHeaders(w http.ResponseWriter, limit, remaining int, reset time.Duration) {
+	if remaining < 0 {
+		remaining = 0
+	}
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"strategic-insight-analyst/blobstore"
+	"strategic-insight-analyst/repository"
+)
+
+// Reconciler repairs state left behind by uploads that failed part-way or
+// predate transactional uploads: blobs no document points to, and
+// documents that never received any chunks.
+type Reconciler struct {
+	documents repository.DocumentRepo
+	blobs     blobstore.Store
+	// grace keeps the reconciler away from uploads that are still in
+	// flight: only objects and documents older than this are considered.
+	grace time.Duration
+}
+
+func NewReconciler(repos repository.Repos, blobs blobstore.Store, grace time.Duration) *Reconciler {
+	return &Reconciler{documents: repos.Documents, blobs: blobs, grace: grace}
+}
+
+// Run reconciles once per interval until ctx is cancelled.
+func (rc *Reconciler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := rc.RunOnce(ctx); err != nil {
+			slog.ErrorContext(ctx, "reconciler pass failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce performs a single reconciliation pass.
+func (rc *Reconciler) RunOnce(ctx context.Context) error {
+	cutoff := time.Now().Add(-rc.grace)
+	if err := rc.removeChunklessDocuments(ctx, cutoff); err != nil {
+		return err
+	}
+	return rc.removeOrphanBlobs(ctx, cutoff)
+}
+
+// removeChunklessDocuments deletes documents that have nothing to analyze,
+// together with their blobs. Chunks and chat history cascade with the row.
+func (rc *Reconciler) removeChunklessDocuments(ctx context.Context, cutoff time.Time) error {
+	docs, err := rc.documents.ListChunkless(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if _, err := rc.documents.Delete(ctx, doc.ID, doc.UserID); err != nil {
+			slog.ErrorContext(ctx, "reconciler: delete chunkless document failed", "document_id", doc.ID, "error", err)
+			continue
+		}
+		slog.InfoContext(ctx, "reconciler: deleted chunkless document", "document_id", doc.ID)
+		// The blob is now unreferenced and is picked up below.
+	}
+	return nil
+}
+
+func (rc *Reconciler) removeOrphanBlobs(ctx context.Context, cutoff time.Time) error {
+	objects, err := rc.blobs.List(ctx, documentPrefix)
+	if err != nil {
+		return err
+	}
+
+	var candidates []string
+	for _, obj := range objects {
+		if obj.CreatedAt.IsZero() || obj.CreatedAt.Before(cutoff) {
+			candidates = append(candidates, obj.Path)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	referenced, err := rc.documents.ReferencedPaths(ctx, candidates)
+	if err != nil {
+		return err
+	}
+	var orphans []string
+	for _, p := range candidates {
+		if !referenced[p] {
+			orphans = append(orphans, p)
+		}
+	}
+	if len(orphans) == 0 {
+		return nil
+	}
+	if err := rc.blobs.Remove(ctx, orphans...); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "reconciler: removed orphaned blobs", "count", len(orphans))
+	return nil
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/repository"
+)
+
+// maxSearchQueryLength bounds the q parameter, in characters.
+const maxSearchQueryLength = 256
+
+type SearchService struct {
+	chunks repository.ChunkRepo
+}
+
+func NewSearchService(repos repository.Repos) *SearchService {
+	return &SearchService{chunks: repos.Chunks}
+}
+
+// Search runs a full-text query over the content of every document the
+// user owns and returns the matching documents, best first, each with
+// highlighted snippets of its best chunks.
+func (ss *SearchService) Search(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	text := strings.TrimSpace(r.URL.Query().Get("q"))
+	if text == "" {
+		apierror.Write(w, r, apierror.New(apierror.CodeInvalidRequest, "q is required"))
+		return
+	}
+	if utf8.RuneCountInString(text) > maxSearchQueryLength {
+		apierror.Write(w, r, apierror.New(apierror.CodeInvalidRequest,
+			"q must be at most "+strconv.Itoa(maxSearchQueryLength)+" characters"))
+		return
+	}
+	page, aerr := parsePage(r)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+
+	results, err := ss.chunks.Search(ctx, userID, repository.SearchQuery{Text: text, Page: page})
+	if err != nil {
+		apierror.Write(w, r, listError(err, "Search failed"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
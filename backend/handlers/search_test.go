@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"strategic-insight-analyst/repository"
+)
+
+func TestSearchHitPages(t *testing.T) {
+	ts := newTestServer(t, QuotaLimits{})
+	textID := ts.upload(t, "alice", "notes.txt", "Revenue grew in every region.")
+	// A two-page PDF whose second page holds the match, chunked across
+	// the page break.
+	chunks := []string{"Quarterly report.\n", "Costs fell.\n\f", "Revenue grew.\n\f"}
+	pdf := &repository.Document{ID: "pdf", UserID: "alice", FileName: "report.pdf", PageCount: 2}
+	if err := ts.repos.Documents.Create(context.Background(), pdf, chunks); err != nil {
+		t.Fatal(err)
+	}
+
+	w := ts.do(t, "GET", "/api/search?q=revenue", "alice", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	var results repository.Page[repository.SearchResult]
+	decode(t, w, &results)
+	hits := map[string]repository.SearchHit{}
+	for _, res := range results.Items {
+		if len(res.Hits) != 1 {
+			t.Fatalf("%s: hits = %+v, want 1", res.Document.ID, res.Hits)
+		}
+		hits[res.Document.ID] = res.Hits[0]
+	}
+	if hit := hits["pdf"]; hit.ChunkIndex != 2 || hit.Start != len(chunks[0])+len(chunks[1]) || hit.Page != 2 {
+		t.Errorf("PDF hit = %+v, want chunk 2 on page 2", hit)
+	}
+	if hit := hits[textID]; hit.Start != 0 || hit.Page != 0 {
+		t.Errorf("text hit = %+v, want no page", hit)
+	}
+}
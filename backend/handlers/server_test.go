@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/blobstore"
+	"strategic-insight-analyst/repository"
+	"strategic-insight-analyst/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// testAdmin is the user ID that the test server treats as an admin.
+const testAdmin = "admin"
+
+// testMaxUpload is the upload size limit of the test server.
+const testMaxUpload = 64 << 10
+
+// testServer serves the API over the in-memory repositories and blob
+// store. The LLM endpoints talk to a fake inference API that answers with
+// reply, or fails with the status in failStatus when it is set, and keeps
+// the last prompt it was sent in lastPrompt.
+type testServer struct {
+	repos   repository.Repos
+	blobs   blobstore.Store
+	handler http.Handler
+
+	reply      string
+	failStatus atomic.Int32
+	llmCalls   atomic.Int32
+	lastPrompt atomic.Pointer[string]
+}
+
+func newTestServer(t *testing.T, quota QuotaLimits) *testServer {
+	t.Helper()
+	ts := &testServer{
+		repos: repository.NewMemory(),
+		blobs: blobstore.NewMemory(),
+		reply: "Revenue grew.",
+	}
+
+	hf := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.llmCalls.Add(1)
+		var req struct {
+			Inputs string `json:"inputs"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		ts.lastPrompt.Store(&req.Inputs)
+		if status := ts.failStatus.Load(); status != 0 {
+			http.Error(w, "model overloaded", int(status))
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]string{{"generated_text": ts.reply}})
+	}))
+	t.Cleanup(hf.Close)
+
+	quotas := NewQuotaService(ts.repos, quota)
+	usage := NewUsageService(ts.repos, LLMPricing{PromptPer1K: 0.001, CompletionPer1K: 0.002})
+	llm := NewLLMService(ts.repos, utils.LLMConfig{HFAPIURL: hf.URL}, quotas, usage)
+	docs := NewDocumentService(ts.repos, ts.blobs, utils.StorageConfig{MaxUploadBytes: testMaxUpload}, utils.ExtractionConfig{},
+		utils.TrashConfig{Retention: 30 * 24 * time.Hour}, nil, new(sync.WaitGroup))
+	audit := NewAuditLogger(ts.repos)
+
+	r := mux.NewRouter()
+	r.NotFoundHandler = NotFound
+	r.MethodNotAllowedHandler = MethodNotAllowed
+	r.Use(audit.Middleware)
+	api := r.PathPrefix("/api").Subrouter()
+	api.Use(testAuth)
+	api.Handle("/documents", audit.Action(AuditDocumentUpload, "document", "",
+		http.HandlerFunc(docs.UploadDocument))).Methods("POST")
+	api.Handle("/documents/{id}", audit.Action(AuditDocumentView, "document", "id",
+		http.HandlerFunc(docs.GetDocument))).Methods("GET")
+	api.Handle("/documents/{id}", audit.Action(AuditDocumentDelete, "document", "id",
+		http.HandlerFunc(docs.DeleteDocument))).Methods("DELETE")
+	api.Handle("/documents/{id}/download", audit.Action(AuditDocumentDownload, "document", "id",
+		http.HandlerFunc(docs.DownloadDocument))).Methods("GET")
+	api.Handle("/documents/{id}/versions", audit.Action(AuditDocumentVersion, "document", "id",
+		http.HandlerFunc(docs.AddVersion))).Methods("POST")
+	api.Handle("/documents/{id}/restore", audit.Action(AuditDocumentRestore, "document", "id",
+		http.HandlerFunc(docs.RestoreDocument))).Methods("POST")
+	api.Handle("/documents/{documentId}/insights", audit.Action(AuditDocumentInsight, "document", "documentId",
+		http.HandlerFunc(llm.GenerateInsight))).Methods("POST")
+	api.Handle("/documents/{documentId}/chat", audit.Action(AuditDocumentChat, "document", "documentId",
+		http.HandlerFunc(llm.ChatWithDocument))).Methods("POST")
+	api.HandleFunc("/documents/{documentId}/chat/history", llm.GetChatHistory).Methods("GET")
+	api.HandleFunc("/trash", docs.ListTrash).Methods("GET")
+	api.HandleFunc("/search", NewSearchService(ts.repos).Search).Methods("GET")
+	api.HandleFunc("/quota", quotas.GetQuota).Methods("GET")
+	api.HandleFunc("/usage", usage.GetUsage).Methods("GET")
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(RequireAdmin)
+	admin.HandleFunc("/audit", audit.ListAuditEvents).Methods("GET")
+	admin.HandleFunc("/audit/export", audit.ExportAuditEvents).Methods("GET")
+	ts.handler = r
+	return ts
+}
+
+// testAuth stands in for AuthMiddleware: the bearer token is the user ID.
+func testAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || userID == "" {
+			auditAuthFailure(r.Context(), "", "missing token")
+			apierror.Write(w, r, errUnauthenticated)
+			return
+		}
+		ctx := context.WithValue(r.Context(), userIDKey, userID)
+		ctx = context.WithValue(ctx, adminKey, userID == testAdmin)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// do sends a request as userID, or unauthenticated if it is empty. A
+// non-nil body that is not an io.Reader is sent as JSON.
+func (ts *testServer) do(t *testing.T, method, path, userID string, body any, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var rd io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		rd = b
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rd, contentType = bytes.NewReader(data), "application/json"
+	}
+	req := httptest.NewRequest(method, path, rd)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if userID != "" {
+		req.Header.Set("Authorization", "Bearer "+userID)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, req)
+	return w
+}
+
+// upload uploads a text document as userID and returns its ID.
+func (ts *testServer) upload(t *testing.T, userID, fileName, content string) string {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("document", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(fw, content)
+	mw.Close()
+
+	w := ts.do(t, "POST", "/api/documents", userID, &body, "Content-Type", mw.FormDataContentType())
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload: status = %d, body %s", w.Code, w.Body)
+	}
+	var doc Document
+	decode(t, w, &doc)
+	return doc.ID
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+}
+
+// errorCode returns the code of an error response.
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var env struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	decode(t, w, &env)
+	return env.Error.Code
+}
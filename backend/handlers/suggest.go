@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// TagSuggester proposes tags for a document from its text.
+type TagSuggester interface {
+	SuggestTags(ctx context.Context, userID, documentID, text string) ([]string, error)
+}
+
+const (
+	// maxSuggestedTags bounds the tags kept from one suggestion.
+	maxSuggestedTags = 5
+	// tagSampleChars is how much of the text the model sees.
+	tagSampleChars = 4000
+	// maxPromptTags bounds the existing tags listed in the prompt.
+	maxPromptTags = 50
+	// tagSuggestionTimeout bounds a background suggestion, including the
+	// model call.
+	tagSuggestionTimeout = 90 * time.Second
+)
+
+// SuggestTags asks the model for short topical tags for a document,
+// preferring tags the user already has. Suggestions are a convenience, so
+// a user without quota left gets none rather than going over it.
+func (ls *LLMService) SuggestTags(ctx context.Context, userID, documentID, text string) ([]string, error) {
+	existing, err := ls.tags.List(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, min(len(existing), maxPromptTags))
+	for _, t := range existing {
+		if len(names) == maxPromptTags {
+			break
+		}
+		names = append(names, t.Name)
+	}
+
+	vocabulary := ""
+	if len(names) > 0 {
+		vocabulary = "\nPrefer these existing tags when they fit: " + strings.Join(names, ", ") + "\n"
+	}
+	prompt := fmt.Sprintf(`Suggest up to %d short tags (one to three words each) describing the topics of the following business document.
+Reply with the tags only, separated by commas.
+%s
+Document:
+%s
+
+Tags:`, maxSuggestedTags, vocabulary, text)
+
+	call := llmCall{UserID: userID, DocumentID: documentID, Feature: featureTagging}
+	response, err := ls.callHuggingFaceAPI(ctx, call, prompt)
+	if err != nil {
+		return nil, err
+	}
+	return parseSuggestedTags(response, names), nil
+}
+
+// parseSuggestedTags extracts tag names from a model response listing them
+// separated by commas or lines, possibly as a bulleted or numbered list.
+// A suggestion matching an existing tag takes that tag's spelling.
+func parseSuggestedTags(response string, existing []string) []string {
+	known := make(map[string]string, len(existing))
+	for _, name := range existing {
+		known[strings.ToLower(name)] = name
+	}
+	seen := make(map[string]bool)
+	var tags []string
+	for _, field := range strings.FieldsFunc(response, func(r rune) bool { return r == ',' || r == '\n' || r == ';' }) {
+		field = strings.TrimLeft(strings.TrimSpace(field), "-*•#0123456789.) ")
+		field = strings.Trim(field, `"'.`+"`")
+		name, ok := normalizeTagName(field)
+		if !ok || len(strings.Fields(name)) > 3 {
+			continue
+		}
+		key := strings.ToLower(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if k, ok := known[key]; ok {
+			name = k
+		} else {
+			name = key
+		}
+		tags = append(tags, name)
+		if len(tags) == maxSuggestedTags {
+			break
+		}
+	}
+	return tags
+}
+
+// suggestTags has tags suggested for a freshly ingested document and
+// stores them on it. It runs in the background, as one of ds.workers, so
+// that uploads do not wait for the model.
+func (ds *DocumentService) suggestTags(ctx context.Context, doc *Document, chunks []string) {
+	if ds.tagger == nil {
+		return
+	}
+	var sample strings.Builder
+	for _, chunk := range chunks {
+		if sample.Len()+len(chunk) > tagSampleChars {
+			sample.WriteString(strings.ToValidUTF8(chunk[:tagSampleChars-sample.Len()], ""))
+			break
+		}
+		sample.WriteString(chunk)
+	}
+	documentID, userID := doc.ID, doc.UserID
+	ctx = context.WithoutCancel(ctx)
+	ds.workers.Add(1)
+	go func() {
+		defer ds.workers.Done()
+		ctx, cancel := context.WithTimeout(ctx, tagSuggestionTimeout)
+		defer cancel()
+		tags, err := ds.tagger.SuggestTags(ctx, userID, documentID, sample.String())
+		if err != nil {
+			slog.WarnContext(ctx, "tag suggestion failed", "document_id", documentID, "error", err)
+			return
+		}
+		if err := ds.documents.SetSuggestedTags(ctx, documentID, tags); err != nil {
+			slog.WarnContext(ctx, "failed to store suggested tags", "document_id", documentID, "error", err)
+		}
+	}()
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/repository"
+
+	"github.com/gorilla/mux"
+)
+
+// maxTagNameLength bounds tag names, in characters.
+const maxTagNameLength = 64
+
+var (
+	errTagNotFound  = apierror.New(apierror.CodeNotFound, "Tag not found")
+	errTagNameTaken = apierror.New(apierror.CodeConflict, "A tag with this name already exists")
+)
+
+type TagService struct {
+	tags repository.TagRepo
+}
+
+func NewTagService(repos repository.Repos) *TagService {
+	return &TagService{tags: repos.Tags}
+}
+
+// normalizeTagName collapses runs of whitespace in name and reports
+// whether the result is a valid tag name. Commas are not allowed since the
+// document listing takes tags as a comma-separated list.
+func normalizeTagName(name string) (string, bool) {
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" || utf8.RuneCountInString(name) > maxTagNameLength {
+		return "", false
+	}
+	if strings.ContainsRune(name, ',') || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "", false
+	}
+	return name, true
+}
+
+var errInvalidTagName = apierror.New(apierror.CodeInvalidRequest,
+	"Tag names must be 1 to "+strconv.Itoa(maxTagNameLength)+" characters without commas")
+
+// tagNames normalizes a list of tag names, dropping repetitions that
+// differ only in case.
+func tagNames(names []string) ([]string, *apierror.Error) {
+	seen := make(map[string]bool, len(names))
+	var out []string
+	for _, raw := range names {
+		name, ok := normalizeTagName(raw)
+		if !ok {
+			return nil, errInvalidTagName.WithDetails(map[string]any{"name": raw})
+		}
+		if key := strings.ToLower(name); !seen[key] {
+			seen[key] = true
+			out = append(out, name)
+		}
+	}
+	return out, nil
+}
+
+// ListTags returns every tag of the user with the number of documents
+// carrying it, sorted by name.
+func (ts *TagService) ListTags(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	tags, err := ts.tags.List(ctx, userID)
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to list tags"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+func (ts *TagService) CreateTag(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInvalidRequest, "Invalid request body"))
+		return
+	}
+	name, ok := normalizeTagName(req.Name)
+	if !ok {
+		apierror.Write(w, r, errInvalidTagName)
+		return
+	}
+
+	tag := &repository.Tag{UserID: userID, Name: name}
+	err = ts.tags.Create(ctx, tag)
+	if errors.Is(err, repository.ErrConflict) {
+		apierror.Write(w, r, errTagNameTaken)
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to create tag"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tag)
+}
+
+// RenameTag changes a tag's name on every document carrying it.
+func (ts *TagService) RenameTag(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInvalidRequest, "Invalid request body"))
+		return
+	}
+	name, ok := normalizeTagName(req.Name)
+	if !ok {
+		apierror.Write(w, r, errInvalidTagName)
+		return
+	}
+
+	tag := &repository.Tag{ID: mux.Vars(r)["id"], UserID: userID, Name: name}
+	err = ts.tags.Rename(ctx, tag)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		apierror.Write(w, r, errTagNotFound)
+		return
+	case errors.Is(err, repository.ErrConflict):
+		apierror.Write(w, r, errTagNameTaken)
+		return
+	case err != nil:
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to rename tag"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tag)
+}
+
+// DeleteTag removes a tag from every document and then deletes it.
+func (ts *TagService) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	err = ts.tags.Delete(ctx, mux.Vars(r)["id"], userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Write(w, r, errTagNotFound)
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to delete tag"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TagDocuments adds and removes tags, by name, on a set of documents.
+// Tags the user does not have yet are created. Either every document is
+// changed or, if one is not found, none is.
+func (ts *TagService) TagDocuments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	var req struct {
+		DocumentIDs []string `json:"documentIds"`
+		Add         []string `json:"add"`
+		Remove      []string `json:"remove"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInvalidRequest, "Invalid request body"))
+		return
+	}
+	if aerr := checkDocumentIDs(req.DocumentIDs); aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+	add, aerr := tagNames(req.Add)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+	remove, aerr := tagNames(req.Remove)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+	if len(add) == 0 && len(remove) == 0 {
+		apierror.Write(w, r, apierror.New(apierror.CodeInvalidRequest, "add or remove is required"))
+		return
+	}
+
+	err = ts.tags.TagDocuments(ctx, userID, req.DocumentIDs, add, remove)
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeNotFound, "Document not found"))
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to tag documents"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"strategic-insight-analyst/utils"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("strategic-insight-analyst/handlers")
+
+// Tracing starts a server span for each routed request, continuing any
+// trace the caller propagated, and puts the trace ID on the request's log
+// lines. Like Metrics it must be installed with Router.Use so the span can
+// be named after the route template.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if cur := mux.CurrentRoute(r); cur != nil {
+			if tpl, err := cur.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+			))
+		defer span.End()
+		if sc := span.SpanContext(); sc.IsValid() {
+			utils.AddLogAttrs(ctx, slog.String("trace_id", sc.TraceID().String()))
+		}
+
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startExtraction starts the span and timer for one extraction attempt.
+// The returned function ends both, reporting whether the attempt produced
+// text.
+func startExtraction(ctx context.Context, extractor string) (context.Context, func(ok bool, err error)) {
+	ctx, span := tracer.Start(ctx, "extract."+extractor,
+		trace.WithAttributes(attribute.String("extractor", extractor)))
+	observe := startExtractionTimer(extractor)
+	return ctx, func(ok bool, err error) {
+		observe(ok)
+		span.SetAttributes(attribute.Bool("extract.ok", ok))
+		endSpan(span, err)
+	}
+}
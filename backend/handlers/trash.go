@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/repository"
+
+	"github.com/gorilla/mux"
+)
+
+var errNotInTrash = apierror.New(apierror.CodeNotFound, "Document not found in the trash")
+
+// trashedDocument is a document in the trash with the time the purger
+// deletes it for good.
+type trashedDocument struct {
+	Document
+	PurgeAt time.Time `json:"purgeAt"`
+}
+
+// ListTrash returns one page of the user's deleted documents, most
+// recently deleted first.
+func (ds *DocumentService) ListTrash(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+	page, aerr := parsePage(r)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+
+	docs, err := ds.documents.ListTrash(ctx, userID, page)
+	if err != nil {
+		apierror.Write(w, r, listError(err, "Failed to list deleted documents"))
+		return
+	}
+	response := repository.Page[trashedDocument]{
+		Items:      make([]trashedDocument, len(docs.Items)),
+		NextCursor: docs.NextCursor,
+		Total:      docs.Total,
+	}
+	for i, doc := range docs.Items {
+		response.Items[i] = trashedDocument{Document: doc, PurgeAt: doc.DeletedAt.Add(ds.retention)}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RestoreDocument takes a document out of the trash.
+func (ds *DocumentService) RestoreDocument(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	doc, err := ds.documents.Restore(ctx, mux.Vars(r)["id"], userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Write(w, r, errNotInTrash)
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to restore document"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(doc)
+}
+
+// PurgeDocument deletes a document in the trash for good, without waiting
+// for the retention period to end.
+func (ds *DocumentService) PurgeDocument(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	orphaned, err := ds.documents.Purge(ctx, mux.Vars(r)["id"], userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Write(w, r, errNotInTrash)
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to delete document"))
+		return
+	}
+	// Duplicates share their original's blobs; each goes with the last
+	// version referencing it. The blobs were queued with the delete, so a
+	// failed removal is retried by the purger.
+	pending := make([]repository.PendingBlob, len(orphaned))
+	for i, p := range orphaned {
+		pending[i] = repository.PendingBlob{Path: p}
+	}
+	if err := removeBlobs(ctx, ds.blobQueue, ds.blobs, pending); err != nil {
+		slog.WarnContext(ctx, "blob delete failed, will retry", "paths", orphaned, "error", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
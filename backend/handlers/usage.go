@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/repository"
+)
+
+// Features that make LLM calls, recorded with every usage row.
+const (
+	featureInsight = "insight"
+	featureChat    = "chat"
+	featureTagging = "tagging"
+)
+
+// LLMPricing is the estimated cost in USD per 1,000 tokens.
+type LLMPricing struct {
+	PromptPer1K     float64
+	CompletionPer1K float64
+}
+
+// UsageService meters every LLM call.
+type UsageService struct {
+	usage   repository.UsageRepo
+	pricing LLMPricing
+}
+
+func NewUsageService(repos repository.Repos, pricing LLMPricing) *UsageService {
+	return &UsageService{usage: repos.Usage, pricing: pricing}
+}
+
+// llmCall identifies who made an LLM request and why.
+type llmCall struct {
+	UserID     string
+	DocumentID string
+	Feature    string
+}
+
+// LLMUsage is a single metered LLM request.
+type LLMUsage struct {
+	llmCall
+	Provider         string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Latency          time.Duration
+	Status           string
+}
+
+func (us *UsageService) cost(u LLMUsage) float64 {
+	return float64(u.PromptTokens)/1000*us.pricing.PromptPer1K +
+		float64(u.CompletionTokens)/1000*us.pricing.CompletionPer1K
+}
+
+func (us *UsageService) Record(ctx context.Context, u LLMUsage) error {
+	return us.usage.Record(ctx, repository.LLMUsage{
+		UserID:           u.UserID,
+		DocumentID:       u.DocumentID,
+		Feature:          u.Feature,
+		Provider:         u.Provider,
+		Model:            u.Model,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		Latency:          u.Latency,
+		Status:           u.Status,
+		EstimatedCost:    us.cost(u),
+	})
+}
+
+type UsageReportRow = repository.UsageReportRow
+
+// GetUsage reports LLM usage grouped by day, user and model. The range
+// defaults to the last 30 days and can be set with from/to (YYYY-MM-DD).
+// Regular users only see their own usage; admins see everyone's and may
+// narrow the report with userId.
+func (us *UsageService) GetUsage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	q := r.URL.Query()
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -30)
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			apierror.Write(w, r, apierror.New(apierror.CodeInvalidRequest, "Invalid from date: expected YYYY-MM-DD"))
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse("2006-01-02", v); err != nil {
+			apierror.Write(w, r, apierror.New(apierror.CodeInvalidRequest, "Invalid to date: expected YYYY-MM-DD"))
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+
+	filterUser := userID
+	if isAdmin(ctx) {
+		filterUser = q.Get("userId")
+	}
+
+	report, err := us.usage.Report(ctx, from, to, filterUser)
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to load usage"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
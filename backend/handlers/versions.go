@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"strategic-insight-analyst/apierror"
+	"strategic-insight-analyst/repository"
+
+	"github.com/gorilla/mux"
+)
+
+// AddVersion uploads a new revision of an existing document. It is
+// extracted and chunked like a first upload and becomes the latest
+// version; earlier versions keep their originals and chunks.
+func (ds *DocumentService) AddVersion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	docID := mux.Vars(r)["id"]
+	doc, err := ds.documents.Get(ctx, docID, userID)
+	if err != nil {
+		apierror.Write(w, r, documentLookupError(err, "Failed to retrieve document"))
+		return
+	}
+
+	up, aerr := readUpload(w, r, ds.storage.MaxUploadBytes)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+
+	next := *doc
+	next.Version = doc.Version + 1
+	up.describe(&next)
+	if next.SHA256 == doc.SHA256 {
+		apierror.Write(w, r, apierror.New(apierror.CodeConflict,
+			"The file is identical to the latest version").
+			WithDetails(map[string]any{"version": doc.Version}))
+		return
+	}
+	setAuditDetail(ctx, "version", strconv.Itoa(next.Version))
+
+	// Unlike a first upload, a revision whose text cannot be extracted is
+	// not kept: the document stays at its last usable version.
+	chunks, err := ds.ingest(ctx, &next, up, ingestOptions{})
+	if err != nil {
+		apierror.Write(w, r, errExtractionFailed(err))
+		return
+	}
+
+	if err := ds.store(ctx, &next, up, func() error { return ds.documents.AddVersion(ctx, &next, chunks) }); err != nil {
+		apierror.Write(w, r, err)
+		return
+	}
+	documentChunks.Observe(float64(len(chunks)))
+	ds.suggestTags(ctx, &next, chunks)
+	ds.writeUpload(w, &next, "")
+}
+
+// ListVersions returns every version of a document, oldest first.
+func (ds *DocumentService) ListVersions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	versions, err := ds.documents.ListVersions(ctx, mux.Vars(r)["id"], userID)
+	if err != nil {
+		apierror.Write(w, r, documentLookupError(err, "Failed to list versions"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+type diffResponse struct {
+	DocumentID string          `json:"documentId"`
+	From       int             `json:"from"`
+	To         int             `json:"to"`
+	Summary    diffSummary     `json:"summary"`
+	Changes    []passageChange `json:"changes"`
+}
+
+// DiffVersions compares the extracted text of two versions of a document
+// passage by passage. from defaults to the version before to, and to to
+// the latest version.
+func (ds *DocumentService) DiffVersions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := getUserID(ctx)
+	if err != nil {
+		apierror.Write(w, r, errUnauthenticated)
+		return
+	}
+
+	docID := mux.Vars(r)["id"]
+	doc, err := ds.documents.Get(ctx, docID, userID)
+	if err != nil {
+		apierror.Write(w, r, documentLookupError(err, "Failed to retrieve document"))
+		return
+	}
+
+	to, aerr := versionParam(r, "to", doc.Version)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+	from, aerr := versionParam(r, "from", to-1)
+	if aerr != nil {
+		apierror.Write(w, r, aerr)
+		return
+	}
+	if from < 1 && r.URL.Query().Get("from") == "" {
+		apierror.Write(w, r, apierror.New(apierror.CodeInvalidRequest, "The document has no earlier version to compare with"))
+		return
+	}
+	if from > doc.Version || to > doc.Version {
+		apierror.Write(w, r, errVersionNotFound.WithDetails(map[string]any{"latest": doc.Version}))
+		return
+	}
+
+	fromText, err := ds.versionText(ctx, docID, from)
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to load document text"))
+		return
+	}
+	toText, err := ds.versionText(ctx, docID, to)
+	if err != nil {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInternal, "Failed to load document text"))
+		return
+	}
+
+	changes, summary, err := diffPassages(splitPassages(fromText), splitPassages(toText))
+	if errors.Is(err, errDiffTooLarge) {
+		apierror.Write(w, r, apierror.Wrap(err, apierror.CodeInvalidRequest, "The versions are too different to compare"))
+		return
+	}
+	if changes == nil {
+		changes = []passageChange{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diffResponse{DocumentID: docID, From: from, To: to, Summary: summary, Changes: changes})
+}
+
+// versionParam reads a version number from the query, or returns def when
+// the parameter is absent.
+func versionParam(r *http.Request, name string, def int) (int, *apierror.Error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 1 {
+		return 0, apierror.New(apierror.CodeInvalidRequest, name+" must be a positive integer")
+	}
+	return version, nil
+}
+
+// requestedVersion finds the version of a document a request asks for:
+// the latest, or the one named by the "version" query parameter.
+func (ds *DocumentService) requestedVersion(ctx context.Context, r *http.Request, userID string) (*repository.DocumentVersion, *apierror.Error) {
+	doc, err := ds.documents.Get(ctx, mux.Vars(r)["id"], userID)
+	if err != nil {
+		return nil, documentLookupError(err, "Failed to retrieve document")
+	}
+	version, aerr := versionParam(r, "version", doc.Version)
+	if aerr != nil {
+		return nil, aerr
+	}
+	if version == doc.Version {
+		current := doc.CurrentVersion()
+		return &current, nil
+	}
+	versions, err := ds.documents.ListVersions(ctx, doc.ID, userID)
+	if err != nil {
+		return nil, documentLookupError(err, "Failed to list versions")
+	}
+	for i := range versions {
+		if versions[i].Version == version {
+			return &versions[i], nil
+		}
+	}
+	return nil, errVersionNotFound.WithDetails(map[string]any{"latest": doc.Version})
+}
+
+// versionText reassembles the extracted text of a document version from
+// its chunks.
+func (ds *DocumentService) versionText(ctx context.Context, documentID string, version int) (string, error) {
+	chunks, err := ds.chunks.List(ctx, documentID, version)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(chunks, ""), nil
+}
@@ -1,70 +1,307 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strategic-insight-analyst/blobstore"
 	"strategic-insight-analyst/handlers"
+	"strategic-insight-analyst/repository"
 	"strategic-insight-analyst/utils"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
 )
 
-func withCORS(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		h.ServeHTTP(w, r)
-	})
-}
-
 func main() {
-	err := godotenv.Load()
+	cfg, args, err := utils.LoadConfig(os.Args[1:])
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatal(err)
 	}
+	// Also routes the standard library logger, used by dependencies,
+	// through the JSON handler.
+	slog.SetDefault(utils.NewLogger(cfg.Logging, os.Stderr))
+	cfg.Log()
 
-	utils.LoadConfig()
+	shutdownTracing, err := utils.InitTracing(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("tracing init failed", err)
+	}
 
-	db, err := utils.InitDB()
+	db, err := utils.InitDB(cfg.Database)
 	if err != nil {
-		log.Fatalf("Failed to connect to Neon: %v", err)
+		fatal("failed to connect to database", err)
 	}
 	defer db.Close()
+	handlers.RegisterDBStats(db, "postgres")
 
-	firebaseApp, err := utils.InitFirebase()
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrateCommand(db, args[1:]); err != nil {
+			fatal("migrate", err)
+		}
+		return
+	}
+	if cfg.Database.MigrateOnStartup {
+		if err := utils.MigrateUp(context.Background(), db); err != nil {
+			fatal("failed to apply migrations", err)
+		}
+	}
+
+	firebaseApp, err := utils.InitFirebase(cfg.Firebase)
 	if err != nil {
-		log.Fatalf("Firebase init failed: %v", err)
+		fatal("firebase init failed", err)
+	}
+	authClient, err := firebaseApp.Auth(context.Background())
+	if err != nil {
+		fatal("firebase auth client init failed", err)
+	}
+
+	repos := repository.NewPostgres(db, cfg.Search.Language)
+	blobs := blobstore.WithTracing(blobstore.NewSupabase(cfg.Storage))
+	if changed, err := blobstore.EnsurePrivate(context.Background(), cfg.Storage); err != nil {
+		slog.Error("storage bucket check failed", "bucket", cfg.Storage.Bucket, "error", err)
+	} else if changed {
+		slog.Warn("storage bucket was public; made it private", "bucket", cfg.Storage.Bucket)
+	}
+	searchService := handlers.NewSearchService(repos)
+	quotaService := handlers.NewQuotaService(repos, handlers.QuotaLimits{
+		DailyCalls:    cfg.Quota.DailyCalls,
+		DailyTokens:   cfg.Quota.DailyTokens,
+		MonthlyCalls:  cfg.Quota.MonthlyCalls,
+		MonthlyTokens: cfg.Quota.MonthlyTokens,
+	})
+	usageService := handlers.NewUsageService(repos, handlers.LLMPricing{
+		PromptPer1K:     cfg.LLM.PromptCostPer1K,
+		CompletionPer1K: cfg.LLM.CompletionCostPer1K,
+	})
+	llmService := handlers.NewLLMService(repos, cfg.LLM, quotaService, usageService)
+	var tagger handlers.TagSuggester
+	if cfg.LLM.SuggestTags {
+		tagger = llmService
+	}
+	// workers tracks background goroutines, both the periodic jobs below
+	// and work started by requests, so that shutdown can wait for them.
+	var workers sync.WaitGroup
+	documentService := handlers.NewDocumentService(repos, blobs, cfg.Storage, cfg.Extraction, cfg.Trash, tagger, &workers)
+	folderService := handlers.NewFolderService(repos)
+	tagService := handlers.NewTagService(repos)
+	limiter := handlers.NewRateLimiter(map[string]handlers.RateLimit{
+		"api": {
+			Rate:  cfg.RateLimit.APIRPS,
+			Burst: cfg.RateLimit.APIBurst,
+		},
+		"llm": {
+			Rate:  cfg.RateLimit.LLMPerMinute / 60,
+			Burst: cfg.RateLimit.LLMBurst,
+		},
+	})
+
+	authOpts := handlers.AuthOptions{
+		JITProvisioning:      cfg.Auth.JITProvisioning,
+		RequireVerifiedEmail: cfg.Auth.RequireVerifiedEmail,
+		AdminUserIDs:         cfg.Auth.AdminUserIDs,
+	}
+
+	audit := handlers.NewAuditLogger(repos)
+
+	// ctx is cancelled on SIGINT/SIGTERM; the periodic workers stop with
+	// it, while work started by requests runs to its own timeout.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.Reconcile.Interval > 0 {
+		reconciler := handlers.NewReconciler(repos, blobs, cfg.Reconcile.GracePeriod)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			reconciler.Run(ctx, cfg.Reconcile.Interval)
+		}()
+	}
+	if cfg.Trash.PurgeInterval > 0 {
+		purger := handlers.NewPurger(repos, blobs, cfg.Trash.Retention)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			purger.Run(ctx, cfg.Trash.PurgeInterval)
+		}()
 	}
 
-	documentService := handlers.NewDocumentService(db)
-	llmService := handlers.NewLLMService(db)
+	health := handlers.NewHealth()
+	health.AddCheck("database", 0, repos.Ping)
+	health.AddCheck("blobstore", 30*time.Second, blobs.Ping)
+	health.AddCheck("llm", 30*time.Second, llmService.Ping)
 
 	r := mux.NewRouter()
-	// Register endpoint (no auth)
-	r.HandleFunc("/api/register", handlers.RegisterHandler(db)).Methods("POST")
+	r.NotFoundHandler = handlers.NotFound
+	r.MethodNotAllowedHandler = handlers.MethodNotAllowed
+	r.Use(handlers.Tracing)
+	r.Use(handlers.Metrics)
+	r.Use(audit.Middleware)
+	// Probes (no auth)
+	r.HandleFunc("/healthz", health.Healthz).Methods("GET")
+	r.HandleFunc("/readyz", health.Readyz).Methods("GET")
+	r.Handle("/metrics", handlers.MetricsHandler()).Methods("GET")
+	// Register endpoint (verifies the ID token itself)
+	r.Handle("/api/register", audit.Action(handlers.AuditUserRegister, "user", "",
+		handlers.RegisterHandler(authClient, repos.Users, authOpts))).Methods("POST")
 	api := r.PathPrefix("/api").Subrouter()
-	api.Use(handlers.AuthMiddleware(firebaseApp, db))
+	api.Use(handlers.AuthMiddleware(authClient, repos.Users, authOpts))
+	api.Use(limiter.Middleware("api"))
 
-	api.HandleFunc("/documents", documentService.UploadDocument).Methods("POST")
+	api.Handle("/documents", audit.Action(handlers.AuditDocumentUpload, "document", "",
+		http.HandlerFunc(documentService.UploadDocument))).Methods("POST")
 	api.HandleFunc("/documents", documentService.ListDocuments).Methods("GET")
-	api.HandleFunc("/documents/{id}", documentService.GetDocument).Methods("GET")
-	api.HandleFunc("/documents/{id}", documentService.DeleteDocument).Methods("DELETE")
-	api.HandleFunc("/documents/{documentId}/insights", llmService.GenerateInsight).Methods("POST")
-	api.HandleFunc("/documents/{documentId}/chat", llmService.ChatWithDocument).Methods("POST")
+	api.HandleFunc("/documents/move", folderService.MoveDocuments).Methods("POST")
+	api.HandleFunc("/documents/tags", tagService.TagDocuments).Methods("POST")
+	api.Handle("/documents/{id}", audit.Action(handlers.AuditDocumentView, "document", "id",
+		http.HandlerFunc(documentService.GetDocument))).Methods("GET")
+	api.Handle("/documents/{id}", audit.Action(handlers.AuditDocumentUpdate, "document", "id",
+		http.HandlerFunc(documentService.UpdateDocument))).Methods("PATCH")
+	api.Handle("/documents/{id}", audit.Action(handlers.AuditDocumentDelete, "document", "id",
+		http.HandlerFunc(documentService.DeleteDocument))).Methods("DELETE")
+	api.Handle("/documents/{id}/download", audit.Action(handlers.AuditDocumentDownload, "document", "id",
+		http.HandlerFunc(documentService.DownloadDocument))).Methods("GET")
+	api.Handle("/documents/{id}/download-url", audit.Action(handlers.AuditDocumentShare, "document", "id",
+		http.HandlerFunc(documentService.CreateDownloadURL))).Methods("POST")
+	api.Handle("/documents/{id}/text", audit.Action(handlers.AuditDocumentInspect, "document", "id",
+		http.HandlerFunc(documentService.DocumentText))).Methods("GET")
+	api.Handle("/documents/{id}/chunks", audit.Action(handlers.AuditDocumentInspect, "document", "id",
+		http.HandlerFunc(documentService.ListChunks))).Methods("GET")
+	api.Handle("/documents/{id}/restore", audit.Action(handlers.AuditDocumentRestore, "document", "id",
+		http.HandlerFunc(documentService.RestoreDocument))).Methods("POST")
+	api.Handle("/documents/{id}/reprocess", audit.Action(handlers.AuditDocumentReprocess, "document", "id",
+		http.HandlerFunc(documentService.ReprocessDocument))).Methods("POST")
+	api.Handle("/documents/{id}/versions", audit.Action(handlers.AuditDocumentVersion, "document", "id",
+		http.HandlerFunc(documentService.AddVersion))).Methods("POST")
+	api.HandleFunc("/documents/{id}/versions", documentService.ListVersions).Methods("GET")
+	api.HandleFunc("/documents/{id}/diff", documentService.DiffVersions).Methods("GET")
+	api.Handle("/documents/{documentId}/insights", audit.Action(handlers.AuditDocumentInsight, "document", "documentId",
+		limiter.Limit("llm", llmService.GenerateInsight))).Methods("POST")
+	api.Handle("/documents/{documentId}/chat", audit.Action(handlers.AuditDocumentChat, "document", "documentId",
+		limiter.Limit("llm", llmService.ChatWithDocument))).Methods("POST")
 	api.HandleFunc("/documents/{documentId}/chat/history", llmService.GetChatHistory).Methods("GET")
+	api.HandleFunc("/search", searchService.Search).Methods("GET")
+	api.HandleFunc("/trash", documentService.ListTrash).Methods("GET")
+	api.Handle("/trash/{id}", audit.Action(handlers.AuditDocumentPurge, "document", "id",
+		http.HandlerFunc(documentService.PurgeDocument))).Methods("DELETE")
+	api.HandleFunc("/folders", folderService.ListFolders).Methods("GET")
+	api.HandleFunc("/folders", folderService.CreateFolder).Methods("POST")
+	api.HandleFunc("/folders/{id}", folderService.UpdateFolder).Methods("PATCH")
+	api.HandleFunc("/folders/{id}", folderService.DeleteFolder).Methods("DELETE")
+	api.HandleFunc("/tags", tagService.ListTags).Methods("GET")
+	api.HandleFunc("/tags", tagService.CreateTag).Methods("POST")
+	api.HandleFunc("/tags/{id}", tagService.RenameTag).Methods("PATCH")
+	api.HandleFunc("/tags/{id}", tagService.DeleteTag).Methods("DELETE")
+	api.HandleFunc("/quota", quotaService.GetQuota).Methods("GET")
+	api.HandleFunc("/usage", usageService.GetUsage).Methods("GET")
+
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(handlers.RequireAdmin)
+	admin.HandleFunc("/audit-events", audit.ListAuditEvents).Methods("GET")
+	admin.HandleFunc("/audit-events/export", audit.ExportAuditEvents).Methods("GET")
+
+	cors := handlers.CORSConfig{
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Request-ID", "Range", "If-Range", "If-None-Match"},
+		ExposedHeaders:   []string{"X-Request-ID", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Content-Disposition", "Content-Range", "Accept-Ranges", "ETag"},
+		AllowCredentials: cfg.CORS.AllowCredentials,
+		MaxAge:           cfg.CORS.MaxAge,
+		HSTSMaxAge:       cfg.CORS.HSTSMaxAge,
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	srv := &http.Server{
+		Addr:              ":" + cfg.Server.Port,
+		Handler:           handlers.RequestID(handlers.AccessLog(handlers.CORS(r, cors))),
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("server running", "port", cfg.Server.Port)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		fatal("server error", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down: draining in-flight requests")
+	health.SetDraining()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("graceful shutdown incomplete", "error", err)
+	}
+
+	workersDone := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-shutdownCtx.Done():
+		slog.Warn("background workers did not stop in time")
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Warn("failed to flush traces", "error", err)
+	}
+	slog.Info("server stopped")
+}
+
+// fatal logs err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// runMigrateCommand implements "migrate up", "migrate down [n]" and
+// "migrate status".
+func runMigrateCommand(db *sql.DB, args []string) error {
+	ctx := context.Background()
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up | down [n] | status")
+	}
+	switch args[0] {
+	case "up":
+		return utils.MigrateUp(ctx, db)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+			steps = n
+		}
+		return utils.MigrateDown(ctx, db, steps)
+	case "status":
+		statuses, err := utils.MigrationStatuses(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
 	}
-	log.Printf("Server running on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, withCORS(r)))
 }
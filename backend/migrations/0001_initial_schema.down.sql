@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS chat_history;
+DROP TABLE IF EXISTS document_chunks;
+DROP TABLE IF EXISTS documents;
+DROP TABLE IF EXISTS users;
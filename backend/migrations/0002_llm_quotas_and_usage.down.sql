@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS llm_usage;
+DROP TABLE IF EXISTS llm_quota_usage;
+DROP TABLE IF EXISTS llm_quotas;
@@ -0,0 +1,39 @@
+-- LLM Quota Overrides (NULL columns fall back to the server defaults)
+CREATE TABLE IF NOT EXISTS llm_quotas (
+    user_id VARCHAR(255) PRIMARY KEY,
+    daily_calls BIGINT,
+    daily_tokens BIGINT,
+    monthly_calls BIGINT,
+    monthly_tokens BIGINT,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- LLM Quota Counters (one row per user per day and per month)
+CREATE TABLE IF NOT EXISTS llm_quota_usage (
+    user_id VARCHAR(255) NOT NULL,
+    period VARCHAR(10) NOT NULL CHECK (period IN ('day', 'month')),
+    period_start TIMESTAMP NOT NULL,
+    calls BIGINT NOT NULL DEFAULT 0,
+    tokens BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, period, period_start),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- LLM Usage Table (one row per LLM request, for metering and cost accounting)
+CREATE TABLE IF NOT EXISTS llm_usage (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    document_id VARCHAR(255), -- NULL for calls not tied to a document, or once it is deleted
+    feature VARCHAR(20) NOT NULL, -- 'insight', 'chat', 'summary'
+    provider VARCHAR(50) NOT NULL,
+    model VARCHAR(255) NOT NULL,
+    prompt_tokens INT NOT NULL DEFAULT 0,
+    completion_tokens INT NOT NULL DEFAULT 0,
+    latency_ms INT NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL, -- 'ok' or 'error'
+    estimated_cost NUMERIC(12, 6) NOT NULL DEFAULT 0, -- USD
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE SET NULL
+);
@@ -0,0 +1,31 @@
+-- Audit Events Table (append-only record of security-relevant and data-access events)
+CREATE TABLE IF NOT EXISTS audit_events (
+    id VARCHAR(255) PRIMARY KEY,
+    action VARCHAR(50) NOT NULL, -- e.g. 'document.delete', 'auth.failure'
+    outcome VARCHAR(10) NOT NULL CHECK (outcome IN ('success', 'failure')),
+    status INT NOT NULL, -- HTTP status of the request
+    actor_id VARCHAR(255), -- No FK: events must outlive the user
+    target_type VARCHAR(50),
+    target_id VARCHAR(255),
+    ip VARCHAR(64) NOT NULL,
+    user_agent TEXT NOT NULL,
+    method VARCHAR(10) NOT NULL,
+    path TEXT NOT NULL,
+    details JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events (target_type, target_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events (actor_id, created_at);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_no_modify ON audit_events;
+CREATE TRIGGER audit_events_no_modify
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_llm_usage_document;
+DROP INDEX IF EXISTS idx_llm_usage_user_created;
+DROP INDEX IF EXISTS idx_llm_usage_created_at;
+DROP INDEX IF EXISTS idx_chat_history_document_user;
+DROP INDEX IF EXISTS idx_documents_user_uploaded;
@@ -0,0 +1,10 @@
+-- Every document listing filters on the owner
+CREATE INDEX IF NOT EXISTS idx_documents_user_uploaded ON documents (user_id, uploaded_at DESC);
+
+-- Chat history is always read per document and user, in time order
+CREATE INDEX IF NOT EXISTS idx_chat_history_document_user ON chat_history (document_id, user_id, timestamp);
+
+-- Usage reports scan a time range, optionally for one user
+CREATE INDEX IF NOT EXISTS idx_llm_usage_created_at ON llm_usage (created_at);
+CREATE INDEX IF NOT EXISTS idx_llm_usage_user_created ON llm_usage (user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_llm_usage_document ON llm_usage (document_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_documents_user_size;
+DROP INDEX IF EXISTS idx_documents_user_name;
+ALTER TABLE documents DROP COLUMN IF EXISTS status;
+ALTER TABLE documents DROP COLUMN IF EXISTS size_bytes;
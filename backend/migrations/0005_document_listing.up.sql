@@ -0,0 +1,8 @@
+-- Columns and indexes backing paginated, sortable document listings.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS size_bytes BIGINT NOT NULL DEFAULT 0;
+-- Ingestion is synchronous, so every existing document is ready.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'ready'
+    CHECK (status IN ('processing', 'ready', 'failed'));
+
+CREATE INDEX IF NOT EXISTS idx_documents_user_name ON documents (user_id, file_name, id);
+CREATE INDEX IF NOT EXISTS idx_documents_user_size ON documents (user_id, size_bytes, id);
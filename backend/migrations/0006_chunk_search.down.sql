@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_document_chunks_search;
+ALTER TABLE document_chunks DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE document_chunks DROP COLUMN IF EXISTS language;
@@ -0,0 +1,7 @@
+-- Full-text search over chunk content. language is the text search
+-- configuration each chunk was indexed with; search_vector follows it.
+ALTER TABLE document_chunks ADD COLUMN IF NOT EXISTS language REGCONFIG NOT NULL DEFAULT 'english';
+ALTER TABLE document_chunks ADD COLUMN IF NOT EXISTS search_vector TSVECTOR
+    GENERATED ALWAYS AS (to_tsvector(language, content)) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_document_chunks_search ON document_chunks USING GIN (search_vector);
@@ -0,0 +1,7 @@
+ALTER TABLE documents DROP COLUMN IF EXISTS processing_error;
+ALTER TABLE documents DROP COLUMN IF EXISTS extractor;
+ALTER TABLE documents DROP COLUMN IF EXISTS word_count;
+ALTER TABLE documents DROP COLUMN IF EXISTS language;
+ALTER TABLE documents DROP COLUMN IF EXISTS page_count;
+ALTER TABLE documents DROP COLUMN IF EXISTS sha256;
+ALTER TABLE documents DROP COLUMN IF EXISTS mime_type;
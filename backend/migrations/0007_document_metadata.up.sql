@@ -0,0 +1,9 @@
+-- Metadata recorded at ingestion. Documents uploaded earlier keep the
+-- zero values, meaning unknown.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS mime_type VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS sha256 VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS page_count INT NOT NULL DEFAULT 0;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS language VARCHAR(10) NOT NULL DEFAULT '';
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS word_count INT NOT NULL DEFAULT 0;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS extractor VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS processing_error TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_documents_storage_path;
+DROP INDEX IF EXISTS idx_documents_user_sha256;
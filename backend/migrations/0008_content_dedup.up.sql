@@ -0,0 +1,6 @@
+-- Uploads look for an earlier copy of the same content by hash.
+CREATE INDEX IF NOT EXISTS idx_documents_user_sha256 ON documents (user_id, sha256);
+
+-- Duplicates share their original's blob; deletes count the documents
+-- still referencing it.
+CREATE INDEX IF NOT EXISTS idx_documents_storage_path ON documents (storage_path);
@@ -0,0 +1,10 @@
+-- Only the latest version of each document survives.
+ALTER TABLE chat_history DROP COLUMN IF EXISTS version;
+
+DELETE FROM document_chunks c USING documents d WHERE c.document_id = d.id AND c.version <> d.version;
+ALTER TABLE document_chunks DROP CONSTRAINT IF EXISTS document_chunks_document_version_chunk_index_key;
+ALTER TABLE document_chunks ADD CONSTRAINT document_chunks_document_id_chunk_index_key UNIQUE (document_id, chunk_index);
+ALTER TABLE document_chunks DROP COLUMN IF EXISTS version;
+
+DROP TABLE IF EXISTS document_versions;
+ALTER TABLE documents DROP COLUMN IF EXISTS version;
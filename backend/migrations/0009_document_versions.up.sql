@@ -0,0 +1,41 @@
+-- Every upload of a document is kept as a version. The documents row
+-- mirrors its latest version; chunks and chat messages belong to one.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS document_versions (
+    document_id VARCHAR(255) NOT NULL,
+    version INT NOT NULL,
+    file_name VARCHAR(255) NOT NULL,
+    storage_path VARCHAR(255) NOT NULL,
+    uploaded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    mime_type VARCHAR(100) NOT NULL DEFAULT '',
+    sha256 VARCHAR(64) NOT NULL DEFAULT '',
+    page_count INT NOT NULL DEFAULT 0,
+    language VARCHAR(10) NOT NULL DEFAULT '',
+    word_count INT NOT NULL DEFAULT 0,
+    extractor VARCHAR(20) NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'ready' CHECK (status IN ('processing', 'ready', 'failed')),
+    processing_error TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (document_id, version),
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE
+);
+
+-- Blobs are shared between versions of duplicates; deletes and the
+-- reconciler look them up by path.
+CREATE INDEX IF NOT EXISTS idx_document_versions_storage_path ON document_versions (storage_path);
+
+INSERT INTO document_versions (document_id, version, file_name, storage_path, uploaded_at, size_bytes,
+    mime_type, sha256, page_count, language, word_count, extractor, status, processing_error)
+SELECT id, version, file_name, storage_path, uploaded_at, size_bytes,
+    mime_type, sha256, page_count, language, word_count, extractor, status, processing_error
+FROM documents
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE document_chunks ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE document_chunks DROP CONSTRAINT IF EXISTS document_chunks_document_id_chunk_index_key;
+ALTER TABLE document_chunks DROP CONSTRAINT IF EXISTS document_chunks_document_version_chunk_index_key;
+ALTER TABLE document_chunks ADD CONSTRAINT document_chunks_document_version_chunk_index_key
+    UNIQUE (document_id, version, chunk_index);
+
+ALTER TABLE chat_history ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
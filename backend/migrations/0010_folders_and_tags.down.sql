@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS document_tags;
+DROP TABLE IF EXISTS tags;
+ALTER TABLE documents DROP COLUMN IF EXISTS suggested_tags;
+ALTER TABLE documents DROP COLUMN IF EXISTS folder_id;
+DROP TABLE IF EXISTS folders;
@@ -0,0 +1,44 @@
+-- Folders nest under an optional parent. Deleting a folder takes its
+-- subfolders with it; the application first moves their documents out.
+CREATE TABLE IF NOT EXISTS folders (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    parent_id VARCHAR(255),
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (parent_id) REFERENCES folders(id) ON DELETE CASCADE
+);
+
+-- Sibling folders have distinct names, ignoring case.
+CREATE UNIQUE INDEX IF NOT EXISTS folders_user_parent_name_key
+    ON folders (user_id, COALESCE(parent_id, ''), lower(name));
+CREATE INDEX IF NOT EXISTS idx_folders_parent ON folders (parent_id);
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS folder_id VARCHAR(255)
+    REFERENCES folders(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_documents_folder ON documents (folder_id);
+
+-- Tags suggested by the language model at ingestion. They are not applied
+-- until the user accepts them.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS suggested_tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS tags (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS tags_user_name_key ON tags (user_id, lower(name));
+
+CREATE TABLE IF NOT EXISTS document_tags (
+    document_id VARCHAR(255) NOT NULL,
+    tag_id VARCHAR(255) NOT NULL,
+    PRIMARY KEY (document_id, tag_id),
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_tags_tag ON document_tags (tag_id);
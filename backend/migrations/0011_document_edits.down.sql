@@ -0,0 +1,6 @@
+ALTER TABLE document_versions DROP COLUMN IF EXISTS chunk_size;
+ALTER TABLE document_versions DROP COLUMN IF EXISTS chunk_strategy;
+ALTER TABLE documents DROP COLUMN IF EXISTS chunk_size;
+ALTER TABLE documents DROP COLUMN IF EXISTS chunk_strategy;
+ALTER TABLE documents DROP COLUMN IF EXISTS metadata;
+ALTER TABLE documents DROP COLUMN IF EXISTS description;
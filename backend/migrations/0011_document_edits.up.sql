@@ -0,0 +1,11 @@
+-- Details the user can edit after upload.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+
+-- How the text of each version was chunked, so that a reprocessed version
+-- can be told from one chunked at upload. Earlier uploads used fixed
+-- chunks of 2000 bytes.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS chunk_strategy VARCHAR(20) NOT NULL DEFAULT 'fixed';
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS chunk_size INT NOT NULL DEFAULT 2000;
+ALTER TABLE document_versions ADD COLUMN IF NOT EXISTS chunk_strategy VARCHAR(20) NOT NULL DEFAULT 'fixed';
+ALTER TABLE document_versions ADD COLUMN IF NOT EXISTS chunk_size INT NOT NULL DEFAULT 2000;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS blob_deletions;
+DROP INDEX IF EXISTS idx_documents_deleted_at;
+ALTER TABLE documents DROP COLUMN IF EXISTS deleted_at;
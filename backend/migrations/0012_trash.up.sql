@@ -0,0 +1,17 @@
+-- Deleted documents go to the trash first: deleted_at is set and they
+-- disappear from every listing until restored or purged.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_documents_deleted_at ON documents (deleted_at)
+    WHERE deleted_at IS NOT NULL;
+
+-- Blobs left without a document by a permanent delete, queued in the same
+-- transaction so that a failed removal is retried instead of forgotten.
+CREATE TABLE IF NOT EXISTS blob_deletions (
+    path VARCHAR(255) PRIMARY KEY,
+    queued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_blob_deletions_next_attempt ON blob_deletions (next_attempt_at);
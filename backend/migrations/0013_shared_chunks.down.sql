@@ -0,0 +1,24 @@
+-- Every version gets its own copy of the chunks it shared. The copies
+-- belong to no set, so the set's uniqueness rules go first.
+ALTER TABLE document_chunks ADD COLUMN IF NOT EXISTS document_id VARCHAR(255);
+ALTER TABLE document_chunks ADD COLUMN IF NOT EXISTS version INT;
+ALTER TABLE document_chunks DROP CONSTRAINT IF EXISTS document_chunks_chunk_set_chunk_index_key;
+ALTER TABLE document_chunks ALTER COLUMN chunk_set_id DROP NOT NULL;
+
+INSERT INTO document_chunks (id, document_id, version, chunk_index, content, embedding, created_at, language)
+SELECT gen_random_uuid()::text, v.document_id, v.version, c.chunk_index, c.content, c.embedding, c.created_at, c.language
+FROM document_versions v JOIN document_chunks c ON c.chunk_set_id = v.chunk_set_id;
+DELETE FROM document_chunks WHERE document_id IS NULL;
+
+ALTER TABLE document_chunks DROP COLUMN IF EXISTS chunk_set_id;
+ALTER TABLE document_chunks ALTER COLUMN document_id SET NOT NULL;
+ALTER TABLE document_chunks ALTER COLUMN version SET NOT NULL;
+ALTER TABLE document_chunks ALTER COLUMN version SET DEFAULT 1;
+ALTER TABLE document_chunks ADD CONSTRAINT document_chunks_document_id_fkey
+    FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE;
+ALTER TABLE document_chunks ADD CONSTRAINT document_chunks_document_version_chunk_index_key
+    UNIQUE (document_id, version, chunk_index);
+
+DROP INDEX IF EXISTS idx_document_versions_chunk_set;
+ALTER TABLE document_versions DROP COLUMN IF EXISTS chunk_set_id;
+DROP TABLE IF EXISTS chunk_sets;
@@ -0,0 +1,36 @@
+-- Chunks belong to a chunk set rather than to one document version, so
+-- that duplicates of a document share its chunks instead of copying them.
+-- ref_count is the number of versions using the set; the set and its
+-- chunks are deleted with the last of them.
+CREATE TABLE IF NOT EXISTS chunk_sets (
+    id VARCHAR(255) PRIMARY KEY,
+    ref_count INT NOT NULL CHECK (ref_count >= 0),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE document_versions ADD COLUMN IF NOT EXISTS chunk_set_id VARCHAR(255);
+ALTER TABLE document_chunks ADD COLUMN IF NOT EXISTS chunk_set_id VARCHAR(255);
+
+-- Every version with chunks gets a set of its own.
+UPDATE document_versions v SET chunk_set_id = gen_random_uuid()::text
+WHERE chunk_set_id IS NULL
+  AND EXISTS (SELECT 1 FROM document_chunks c WHERE c.document_id = v.document_id AND c.version = v.version);
+INSERT INTO chunk_sets (id, ref_count)
+SELECT chunk_set_id, 1 FROM document_versions WHERE chunk_set_id IS NOT NULL;
+UPDATE document_chunks c SET chunk_set_id = v.chunk_set_id
+FROM document_versions v
+WHERE v.document_id = c.document_id AND v.version = c.version;
+DELETE FROM document_chunks WHERE chunk_set_id IS NULL;
+
+ALTER TABLE document_versions ADD CONSTRAINT document_versions_chunk_set_id_fkey
+    FOREIGN KEY (chunk_set_id) REFERENCES chunk_sets(id);
+CREATE INDEX IF NOT EXISTS idx_document_versions_chunk_set ON document_versions (chunk_set_id);
+
+ALTER TABLE document_chunks DROP CONSTRAINT IF EXISTS document_chunks_document_version_chunk_index_key;
+ALTER TABLE document_chunks DROP COLUMN IF EXISTS document_id;
+ALTER TABLE document_chunks DROP COLUMN IF EXISTS version;
+ALTER TABLE document_chunks ALTER COLUMN chunk_set_id SET NOT NULL;
+ALTER TABLE document_chunks ADD CONSTRAINT document_chunks_chunk_set_id_fkey
+    FOREIGN KEY (chunk_set_id) REFERENCES chunk_sets(id) ON DELETE CASCADE;
+ALTER TABLE document_chunks ADD CONSTRAINT document_chunks_chunk_set_chunk_index_key
+    UNIQUE (chunk_set_id, chunk_index);
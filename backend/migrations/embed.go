@@ -0,0 +1,9 @@
+// Package migrations holds the versioned SQL schema migrations, embedded
+// into the binary. Files are named NNNN_description.up.sql and
+// NNNN_description.down.sql.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// seedFolders stores the tree root > child > grandchild for alice, plus a
+// folder of bob's, and puts one document of alice's in each of her
+// folders and one outside any folder.
+func seedFolders(t *testing.T, repos Repos) {
+	t.Helper()
+	ctx := context.Background()
+	for _, f := range []Folder{
+		{ID: "root", UserID: "alice", Name: "Root"},
+		{ID: "child", UserID: "alice", ParentID: "root", Name: "Child"},
+		{ID: "grandchild", UserID: "alice", ParentID: "child", Name: "Grandchild"},
+		{ID: "other", UserID: "alice", Name: "Other"},
+		{ID: "bobs", UserID: "bob", Name: "Bob's"},
+	} {
+		if err := repos.Folders.Create(ctx, &f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, doc := range []Document{
+		{ID: "loose", UserID: "alice"},
+		{ID: "in-root", UserID: "alice", FolderID: "root"},
+		{ID: "in-child", UserID: "alice", FolderID: "child"},
+		{ID: "in-grandchild", UserID: "alice", FolderID: "grandchild"},
+		{ID: "in-other", UserID: "alice", FolderID: "other"},
+		{ID: "bob-doc", UserID: "bob", FolderID: "bobs"},
+	} {
+		if err := repos.Documents.Create(ctx, &doc, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func folderOf(t *testing.T, repos Repos, documentID, userID string) string {
+	t.Helper()
+	doc, err := repos.Documents.Get(context.Background(), documentID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc.FolderID
+}
+
+func TestUpdateFolder(t *testing.T) {
+	tests := []struct {
+		name   string
+		folder Folder
+		want   error
+	}{
+		{"rename", Folder{ID: "child", UserID: "alice", ParentID: "root", Name: "Renamed"}, nil},
+		{"move up", Folder{ID: "grandchild", UserID: "alice", Name: "Grandchild"}, nil},
+		{"move across", Folder{ID: "child", UserID: "alice", ParentID: "other", Name: "Child"}, nil},
+		{"into itself", Folder{ID: "root", UserID: "alice", ParentID: "root", Name: "Root"}, ErrFolderCycle},
+		{"into its child", Folder{ID: "root", UserID: "alice", ParentID: "child", Name: "Root"}, ErrFolderCycle},
+		{"into its grandchild", Folder{ID: "root", UserID: "alice", ParentID: "grandchild", Name: "Root"}, ErrFolderCycle},
+		{"name taken", Folder{ID: "other", UserID: "alice", Name: "root"}, ErrConflict},
+		{"parent of another user", Folder{ID: "child", UserID: "alice", ParentID: "bobs", Name: "Child"}, ErrNotFound},
+		{"folder of another user", Folder{ID: "bobs", UserID: "alice", Name: "Mine"}, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := NewMemory()
+			seedFolders(t, repos)
+			ctx := context.Background()
+			f := tt.folder
+			if err := repos.Folders.Update(ctx, &f); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			got, err := repos.Folders.Get(ctx, tt.folder.ID, tt.folder.UserID)
+			if tt.want == nil && (err != nil || got.Name != f.Name || got.ParentID != f.ParentID) {
+				t.Errorf("stored %+v, %v; want %+v", got, err, f)
+			}
+			if tt.want == ErrFolderCycle && (err != nil || got.ParentID != "") {
+				t.Errorf("stored %+v, %v; want the folder left in place", got, err)
+			}
+		})
+	}
+}
+
+func TestDeleteFolderMovesDocumentsToParent(t *testing.T) {
+	repos := NewMemory()
+	seedFolders(t, repos)
+	ctx := context.Background()
+
+	if err := repos.Folders.Delete(ctx, "child", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"child", "grandchild"} {
+		if _, err := repos.Folders.Get(ctx, id, "alice"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("get %s: err = %v, want ErrNotFound", id, err)
+		}
+	}
+	for doc, want := range map[string]string{
+		"loose": "", "in-root": "root", "in-child": "root", "in-grandchild": "root", "in-other": "other",
+	} {
+		if got := folderOf(t, repos, doc, "alice"); got != want {
+			t.Errorf("%s is in %q, want %q", doc, got, want)
+		}
+	}
+	root, err := repos.Folders.Get(ctx, "root", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.DocumentCount != 3 {
+		t.Errorf("root holds %d documents, want 3", root.DocumentCount)
+	}
+
+	// Documents of a top-level folder end up outside any folder.
+	if err := repos.Folders.Delete(ctx, "root", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if got := folderOf(t, repos, "in-child", "alice"); got != "" {
+		t.Errorf("in-child is in %q, want no folder", got)
+	}
+
+	if err := repos.Folders.Delete(ctx, "bobs", "alice"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("delete another user's folder: err = %v, want ErrNotFound", err)
+	}
+	if got := folderOf(t, repos, "bob-doc", "bob"); got != "bobs" {
+		t.Errorf("bob-doc is in %q, want bobs", got)
+	}
+}
+
+func TestMoveDocuments(t *testing.T) {
+	tests := []struct {
+		name   string
+		folder string
+		docs   []string
+		want   error
+	}{
+		{"into folder", "other", []string{"loose", "in-root"}, nil},
+		{"out of folders", "", []string{"in-root", "in-child"}, nil},
+		{"one unknown", "other", []string{"loose", "missing"}, ErrNotFound},
+		{"one of another user", "other", []string{"loose", "bob-doc"}, ErrNotFound},
+		{"folder of another user", "bobs", []string{"loose"}, ErrNotFound},
+		{"unknown folder", "missing", []string{"loose"}, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := NewMemory()
+			seedFolders(t, repos)
+			err := repos.Folders.MoveDocuments(context.Background(), "alice", tt.folder, tt.docs)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil {
+				for _, id := range tt.docs {
+					if got := folderOf(t, repos, id, "alice"); got != tt.folder {
+						t.Errorf("%s is in %q, want %q", id, got, tt.folder)
+					}
+				}
+				return
+			}
+			// A failed move leaves every document where it was.
+			if got := folderOf(t, repos, "loose", "alice"); got != "" {
+				t.Errorf("loose moved to %q", got)
+			}
+		})
+	}
+}
+
+func TestTagDocuments(t *testing.T) {
+	repos := NewMemory()
+	seedFolders(t, repos)
+	ctx := context.Background()
+
+	if err := repos.Tags.TagDocuments(ctx, "alice", []string{"loose", "in-root"}, []string{"q3", "Risk"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, docs := range [][]string{{"in-root", "missing"}, {"in-root", "bob-doc"}} {
+		err := repos.Tags.TagDocuments(ctx, "alice", docs, []string{"new"}, []string{"q3"})
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("tag %v: err = %v, want ErrNotFound", docs, err)
+		}
+	}
+
+	// The failed calls neither removed q3 nor created the new tag.
+	for _, id := range []string{"loose", "in-root"} {
+		doc, err := repos.Documents.Get(ctx, id, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"q3", "Risk"}; !slices.Equal(doc.Tags, want) {
+			t.Errorf("%s tags = %v, want %v", id, doc.Tags, want)
+		}
+	}
+	tags, err := repos.Tags.List(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 {
+		t.Errorf("alice has %d tags, want 2", len(tags))
+	}
+}
@@ -0,0 +1,1303 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// memoryStore holds every table of the in-memory implementation behind one
+// lock so that cascading deletes stay consistent.
+type memoryStore struct {
+	mu        sync.Mutex
+	users     map[string]User
+	documents map[string]Document
+	versions  map[string][]DocumentVersion
+	// chunks are keyed by document ID, then version.
+	chunks map[string]map[int][]string
+	chats  []ChatMessage
+	// folders and tags are keyed by ID; docTags holds the set of tag IDs
+	// of each document.
+	folders map[string]Folder
+	tags    map[string]Tag
+	docTags map[string]map[string]bool
+	// blobQueue holds the blobs waiting to be removed, keyed by path.
+	blobQueue  map[string]PendingBlob
+	quotaUsage map[quotaKey]QuotaUsage
+	usage      []usageRecord
+	audit      []AuditEvent
+}
+
+// NewMemory returns repositories that keep everything in process memory.
+// It is meant for tests and local development.
+func NewMemory() Repos {
+	s := &memoryStore{
+		users:      make(map[string]User),
+		documents:  make(map[string]Document),
+		versions:   make(map[string][]DocumentVersion),
+		chunks:     make(map[string]map[int][]string),
+		folders:    make(map[string]Folder),
+		tags:       make(map[string]Tag),
+		docTags:    make(map[string]map[string]bool),
+		blobQueue:  make(map[string]PendingBlob),
+		quotaUsage: make(map[quotaKey]QuotaUsage),
+	}
+	return Repos{
+		Users:     (*memUserRepo)(s),
+		Documents: (*memDocumentRepo)(s),
+		Chunks:    (*memChunkRepo)(s),
+		Chats:     (*memChatRepo)(s),
+		Folders:   (*memFolderRepo)(s),
+		Tags:      (*memTagRepo)(s),
+		BlobQueue: (*memBlobQueueRepo)(s),
+		Quotas:    (*memQuotaRepo)(s),
+		Usage:     (*memUsageRepo)(s),
+		Audit:     (*memAuditRepo)(s),
+		Ping:      func(ctx context.Context) error { return nil },
+	}
+}
+
+// withTags returns doc with the names of its tags filled in.
+func (s *memoryStore) withTags(doc Document) Document {
+	doc.Tags = nil
+	for id := range s.docTags[doc.ID] {
+		doc.Tags = append(doc.Tags, s.tags[id].Name)
+	}
+	sort.Slice(doc.Tags, func(i, j int) bool {
+		return strings.ToLower(doc.Tags[i]) < strings.ToLower(doc.Tags[j])
+	})
+	return doc
+}
+
+// live returns the document if it belongs to userID and is not in the
+// trash.
+func (s *memoryStore) live(id, userID string) (Document, bool) {
+	doc, ok := s.documents[id]
+	if !ok || doc.UserID != userID || doc.DeletedAt != nil {
+		return Document{}, false
+	}
+	return doc, true
+}
+
+// hasTag reports whether the document carries a tag of the given name,
+// ignoring case.
+func (s *memoryStore) hasTag(documentID, name string) bool {
+	for id := range s.docTags[documentID] {
+		if strings.EqualFold(s.tags[id].Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// subtree returns the IDs of the folder and every folder below it.
+func (s *memoryStore) subtree(id string) map[string]bool {
+	ids := map[string]bool{id: true}
+	for grew := true; grew; {
+		grew = false
+		for _, f := range s.folders {
+			if !ids[f.ID] && ids[f.ParentID] {
+				ids[f.ID] = true
+				grew = true
+			}
+		}
+	}
+	return ids
+}
+
+type memUserRepo memoryStore
+
+func (r *memUserRepo) Get(ctx context.Context, id string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &u, nil
+}
+
+func (r *memUserRepo) emailTaken(id, email string) bool {
+	for _, u := range r.users {
+		if u.ID != id && strings.EqualFold(u.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *memUserRepo) Upsert(ctx context.Context, id, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.emailTaken(id, email) {
+		return ErrConflict
+	}
+	u, ok := r.users[id]
+	if !ok {
+		u = User{ID: id, CreatedAt: time.Now()}
+	}
+	u.Email = email
+	r.users[id] = u
+	return nil
+}
+
+func (r *memUserRepo) UpdateEmail(ctx context.Context, id, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return nil
+	}
+	if r.emailTaken(id, email) {
+		return ErrConflict
+	}
+	u.Email = email
+	r.users[id] = u
+	return nil
+}
+
+type memDocumentRepo memoryStore
+
+func (r *memDocumentRepo) Create(ctx context.Context, doc *Document, chunks []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.documents[doc.ID]; ok {
+		return ErrConflict
+	}
+	r.insert(doc, chunks)
+	return nil
+}
+
+// insert stores doc as version 1 of a new document.
+func (r *memDocumentRepo) insert(doc *Document, chunks []string) {
+	if doc.Status == "" {
+		doc.Status = StatusReady
+	}
+	doc.Version = 1
+	stored := *doc
+	stored.Tags = nil
+	stored.Metadata = cloneMetadata(doc.Metadata)
+	r.documents[doc.ID] = stored
+	r.versions[doc.ID] = []DocumentVersion{doc.CurrentVersion()}
+	r.chunks[doc.ID] = map[int][]string{1: append([]string(nil), chunks...)}
+}
+
+func (r *memDocumentRepo) Get(ctx context.Context, id, userID string) (*Document, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	doc, ok := (*memoryStore)(r).live(id, userID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	doc = (*memoryStore)(r).withTags(doc)
+	return &doc, nil
+}
+
+func (r *memDocumentRepo) List(ctx context.Context, userID string, q DocumentQuery) (*Page[Document], error) {
+	if _, ok := documentSortColumns[q.Sort]; !ok {
+		q.Sort = SortUploaded
+	}
+	c, err := decodeCursor(q.Page.Cursor, q.Sort, q.Desc)
+	if err != nil {
+		return nil, err
+	}
+	var pivot *Document
+	if c != nil {
+		p, err := c.pivot()
+		if err != nil {
+			return nil, err
+		}
+		pivot = &p
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := (*memoryStore)(r)
+	var folders map[string]bool
+	if q.FolderID != "" {
+		folders = map[string]bool{q.FolderID: true}
+		if q.IncludeSubfolders {
+			folders = s.subtree(q.FolderID)
+		}
+	}
+	var matched []Document
+	for _, doc := range r.documents {
+		if doc.UserID != userID || doc.DeletedAt != nil || !q.matches(doc) {
+			continue
+		}
+		if (folders != nil && !folders[doc.FolderID]) || (q.Unfiled && doc.FolderID != "") {
+			continue
+		}
+		tagged := true
+		for _, name := range q.Tags {
+			tagged = tagged && s.hasTag(doc.ID, name)
+		}
+		if !tagged {
+			continue
+		}
+		matched = append(matched, s.withTags(doc))
+	}
+	less := func(a, b Document) bool {
+		if q.Desc {
+			return compareDocuments(a, b, q.Sort) > 0
+		}
+		return compareDocuments(a, b, q.Sort) < 0
+	}
+	sort.Slice(matched, func(i, j int) bool { return less(matched[i], matched[j]) })
+
+	page := &Page[Document]{Items: []Document{}, Total: len(matched)}
+	limit := q.Page.limit()
+	for _, doc := range matched {
+		if pivot != nil && !less(*pivot, doc) {
+			continue
+		}
+		if len(page.Items) == limit {
+			page.NextCursor = documentCursor(page.Items[limit-1], q.Sort, q.Desc)
+			break
+		}
+		page.Items = append(page.Items, doc)
+	}
+	return page, nil
+}
+
+func (r *memDocumentRepo) CreateCopy(ctx context.Context, doc *Document, sourceID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	source, ok := r.documents[sourceID]
+	if !ok || source.StoragePath != doc.StoragePath {
+		return ErrNotFound
+	}
+	if _, ok := r.documents[doc.ID]; ok {
+		return ErrConflict
+	}
+	r.insert(doc, nil)
+	// Shared rather than copied: stored chunk slices are only ever
+	// replaced, never changed in place.
+	r.chunks[doc.ID][1] = r.chunks[sourceID][source.Version]
+	return nil
+}
+
+func (r *memDocumentRepo) AddVersion(ctx context.Context, doc *Document, chunks []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := (*memoryStore)(r).live(doc.ID, doc.UserID)
+	if !ok || stored.Version != doc.Version-1 {
+		return ErrConflict
+	}
+	if doc.Status == "" {
+		doc.Status = StatusReady
+	}
+	doc.UploadedAt = stored.UploadedAt
+	doc.FolderID = stored.FolderID
+	doc.SuggestedTags = stored.SuggestedTags
+	updated := *doc
+	updated.Tags = nil
+	r.documents[doc.ID] = updated
+	v := doc.CurrentVersion()
+	v.UploadedAt = time.Now()
+	r.versions[doc.ID] = append(r.versions[doc.ID], v)
+	r.chunks[doc.ID][doc.Version] = append([]string(nil), chunks...)
+	return nil
+}
+
+func (r *memDocumentRepo) ListVersions(ctx context.Context, id, userID string) ([]DocumentVersion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := (*memoryStore)(r).live(id, userID); !ok {
+		return nil, ErrNotFound
+	}
+	return append([]DocumentVersion(nil), r.versions[id]...), nil
+}
+
+func (r *memDocumentRepo) FindByHash(ctx context.Context, userID, sha256 string) (*Document, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found *Document
+	for _, doc := range r.documents {
+		if doc.UserID != userID || doc.SHA256 != sha256 || doc.Status != StatusReady || doc.DeletedAt != nil {
+			continue
+		}
+		if found == nil || compareDocuments(doc, *found, SortUploaded) < 0 {
+			found = &doc
+		}
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	doc := (*memoryStore)(r).withTags(*found)
+	return &doc, nil
+}
+
+func (r *memDocumentRepo) Delete(ctx context.Context, id, userID string) ([]string, error) {
+	return r.delete(id, userID, false)
+}
+
+func (r *memDocumentRepo) Purge(ctx context.Context, id, userID string) ([]string, error) {
+	return r.delete(id, userID, true)
+}
+
+// delete removes the document, only if it is in the trash when trashed
+// is set.
+func (r *memDocumentRepo) delete(id, userID string, trashed bool) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	doc, ok := r.documents[id]
+	if !ok || doc.UserID != userID || (trashed && doc.DeletedAt == nil) {
+		return nil, ErrNotFound
+	}
+	versions := r.versions[id]
+	delete(r.documents, id)
+	delete(r.versions, id)
+	delete(r.chunks, id)
+	delete(r.docTags, id)
+	kept := r.chats[:0]
+	for _, m := range r.chats {
+		if m.DocumentID != id {
+			kept = append(kept, m)
+		}
+	}
+	r.chats = kept
+
+	var paths []string
+	for _, v := range versions {
+		paths = append(paths, v.StoragePath)
+	}
+	referenced := r.referenced(paths)
+	var orphaned []string
+	for _, p := range paths {
+		if !referenced[p] {
+			orphaned = append(orphaned, p)
+			referenced[p] = true
+			if _, ok := r.blobQueue[p]; !ok {
+				now := time.Now()
+				r.blobQueue[p] = PendingBlob{Path: p, QueuedAt: now, NextAttemptAt: now}
+			}
+		}
+	}
+	return orphaned, nil
+}
+
+func (r *memDocumentRepo) ReferencedPaths(ctx context.Context, paths []string) (map[string]bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.referenced(paths), nil
+}
+
+// referenced reports which of paths belong to a version of some document.
+func (r *memDocumentRepo) referenced(paths []string) map[string]bool {
+	wanted := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		wanted[p] = true
+	}
+	referenced := make(map[string]bool)
+	for _, versions := range r.versions {
+		for _, v := range versions {
+			if wanted[v.StoragePath] {
+				referenced[v.StoragePath] = true
+			}
+		}
+	}
+	return referenced
+}
+
+func (r *memDocumentRepo) ListChunkless(ctx context.Context, uploadedBefore time.Time) ([]Document, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var documents []Document
+	for id, doc := range r.documents {
+		if doc.UploadedAt.Before(uploadedBefore) && !r.hasChunks(id) && doc.Status != StatusFailed && doc.DeletedAt == nil {
+			documents = append(documents, doc)
+		}
+	}
+	return documents, nil
+}
+
+func (r *memDocumentRepo) hasChunks(id string) bool {
+	for _, chunks := range r.chunks[id] {
+		if len(chunks) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *memDocumentRepo) SetSuggestedTags(ctx context.Context, id string, tags []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	doc, ok := r.documents[id]
+	if !ok {
+		return nil
+	}
+	doc.SuggestedTags = append([]string(nil), tags...)
+	r.documents[id] = doc
+	return nil
+}
+
+func (r *memDocumentRepo) Update(ctx context.Context, id, userID string, change func(*Document) error) (*Document, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := (*memoryStore)(r).live(id, userID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	doc := (*memoryStore)(r).withTags(stored)
+	doc.Metadata = cloneMetadata(stored.Metadata)
+	if err := change(&doc); err != nil {
+		return nil, err
+	}
+	stored.FileName = doc.FileName
+	stored.Description = doc.Description
+	stored.Metadata = cloneMetadata(doc.Metadata)
+	r.documents[id] = stored
+	return &doc, nil
+}
+
+func cloneMetadata(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func (r *memDocumentRepo) Reprocess(ctx context.Context, doc *Document, chunks []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := (*memoryStore)(r).live(doc.ID, doc.UserID)
+	if !ok {
+		return ErrNotFound
+	}
+	if stored.Version != doc.Version {
+		return ErrConflict
+	}
+	stored.Language = doc.Language
+	stored.WordCount = doc.WordCount
+	stored.Extractor = doc.Extractor
+	stored.Status = doc.Status
+	stored.ProcessingError = doc.ProcessingError
+	stored.ChunkStrategy = doc.ChunkStrategy
+	stored.ChunkSize = doc.ChunkSize
+	r.documents[doc.ID] = stored
+	// The version keeps its own file name, which a rename does not change.
+	v := &r.versions[doc.ID][len(r.versions[doc.ID])-1]
+	v.Language = doc.Language
+	v.WordCount = doc.WordCount
+	v.Extractor = doc.Extractor
+	v.Status = doc.Status
+	v.ProcessingError = doc.ProcessingError
+	v.ChunkStrategy = doc.ChunkStrategy
+	v.ChunkSize = doc.ChunkSize
+	r.chunks[doc.ID][doc.Version] = append([]string(nil), chunks...)
+	return nil
+}
+
+func (r *memDocumentRepo) Trash(ctx context.Context, id, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	doc, ok := (*memoryStore)(r).live(id, userID)
+	if !ok {
+		return ErrNotFound
+	}
+	now := time.Now()
+	doc.DeletedAt = &now
+	r.documents[id] = doc
+	return nil
+}
+
+func (r *memDocumentRepo) Restore(ctx context.Context, id, userID string) (*Document, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	doc, ok := r.documents[id]
+	if !ok || doc.UserID != userID || doc.DeletedAt == nil {
+		return nil, ErrNotFound
+	}
+	doc.DeletedAt = nil
+	r.documents[id] = doc
+	doc = (*memoryStore)(r).withTags(doc)
+	return &doc, nil
+}
+
+func (r *memDocumentRepo) ListTrash(ctx context.Context, userID string, p PageRequest) (*Page[Document], error) {
+	pivot, err := trashPivot(p.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	var trashed []Document
+	for _, doc := range r.documents {
+		if doc.UserID == userID && doc.DeletedAt != nil {
+			trashed = append(trashed, (*memoryStore)(r).withTags(doc))
+		}
+	}
+	r.mu.Unlock()
+
+	// Latest deletion first, ties broken by descending ID.
+	before := func(a, b Document) bool {
+		if !a.DeletedAt.Equal(*b.DeletedAt) {
+			return a.DeletedAt.After(*b.DeletedAt)
+		}
+		return a.ID > b.ID
+	}
+	sort.Slice(trashed, func(i, j int) bool { return before(trashed[i], trashed[j]) })
+
+	page := &Page[Document]{Items: []Document{}, Total: len(trashed)}
+	limit := p.limit()
+	for _, doc := range trashed {
+		if pivot != nil && !before(*pivot, doc) {
+			continue
+		}
+		if len(page.Items) == limit {
+			page.NextCursor = trashCursor(page.Items[limit-1])
+			break
+		}
+		page.Items = append(page.Items, doc)
+	}
+	return page, nil
+}
+
+func (r *memDocumentRepo) ListExpired(ctx context.Context, deletedBefore time.Time, limit int) ([]Document, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var expired []Document
+	for _, doc := range r.documents {
+		if doc.DeletedAt != nil && doc.DeletedAt.Before(deletedBefore) {
+			expired = append(expired, doc)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].DeletedAt.Before(*expired[j].DeletedAt) })
+	if len(expired) > limit {
+		expired = expired[:limit]
+	}
+	return expired, nil
+}
+
+type memBlobQueueRepo memoryStore
+
+func (r *memBlobQueueRepo) Due(ctx context.Context, now time.Time, limit int) ([]PendingBlob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var due []PendingBlob
+	for _, b := range r.blobQueue {
+		if !b.NextAttemptAt.After(now) {
+			due = append(due, b)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].Path < due[j].Path
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func (r *memBlobQueueRepo) Done(ctx context.Context, paths ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range paths {
+		delete(r.blobQueue, p)
+	}
+	return nil
+}
+
+func (r *memBlobQueueRepo) Failed(ctx context.Context, path, reason string, retryAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.blobQueue[path]
+	if !ok {
+		return nil
+	}
+	b.Attempts++
+	b.LastError = reason
+	b.NextAttemptAt = retryAt
+	r.blobQueue[path] = b
+	return nil
+}
+
+type memChunkRepo memoryStore
+
+func (r *memChunkRepo) List(ctx context.Context, documentID string, version int) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.chunks[documentID][version]...), nil
+}
+
+// ListDetails reports every chunk as having no embedding: the memory
+// store keeps only the content.
+func (r *memChunkRepo) ListDetails(ctx context.Context, documentID string, version int, p PageRequest) (*Page[Chunk], error) {
+	after, err := chunkPivot(p.Cursor, documentID, version)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	chunks := r.chunks[documentID][version]
+	page := &Page[Chunk]{Items: []Chunk{}, Total: len(chunks)}
+	limit := p.limit()
+	start := 0
+	for i, content := range chunks {
+		c := Chunk{Index: i, Start: start, End: start + len(content), Content: content}
+		start = c.End
+		if i <= after {
+			continue
+		}
+		if len(page.Items) == limit {
+			page.NextCursor = chunkCursor(documentID, version, page.Items[limit-1])
+			break
+		}
+		page.Items = append(page.Items, c)
+	}
+	return page, nil
+}
+
+// Search approximates the Postgres search: a chunk matches when it
+// contains every query word, case-insensitively, and ranks by how often
+// they occur. Phrases, alternatives and exclusions are not supported.
+func (r *memChunkRepo) Search(ctx context.Context, userID string, q SearchQuery) (*Page[SearchResult], error) {
+	pivot, err := searchPivot(q.Page.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	terms := strings.Fields(strings.ToLower(strings.NewReplacer(`"`, " ", "-", " ").Replace(q.Text)))
+
+	r.mu.Lock()
+	var results []SearchResult
+	for id, doc := range r.documents {
+		if doc.UserID != userID || doc.DeletedAt != nil || len(terms) == 0 {
+			continue
+		}
+		chunks := r.chunks[id][doc.Version]
+		res := SearchResult{Document: (*memoryStore)(r).withTags(doc)}
+		hasBreaks := strings.Contains(strings.Join(chunks, ""), pageBreak)
+		start, breaks := 0, 0
+		for i, chunk := range chunks {
+			chunkStart, chunkBreaks := start, breaks
+			start += len(chunk)
+			breaks += strings.Count(chunk, pageBreak)
+			lower := strings.ToLower(chunk)
+			occurrences := 0
+			for _, t := range terms {
+				n := strings.Count(lower, t)
+				if n == 0 {
+					occurrences = 0
+					break
+				}
+				occurrences += n
+			}
+			if occurrences == 0 {
+				continue
+			}
+			rank := float64(occurrences) / float64(occurrences+1)
+			res.Matches++
+			res.Rank = max(res.Rank, rank)
+			res.Hits = append(res.Hits, SearchHit{
+				ChunkIndex: i,
+				Start:      chunkStart,
+				Page:       hitPage(doc.PageCount, chunkBreaks, hasBreaks),
+				Rank:       rank,
+				Snippet:    memorySnippet(chunk, terms),
+			})
+		}
+		if res.Matches > 0 {
+			sort.SliceStable(res.Hits, func(i, j int) bool { return res.Hits[i].Rank > res.Hits[j].Rank })
+			if len(res.Hits) > hitsPerDocument {
+				res.Hits = res.Hits[:hitsPerDocument]
+			}
+			results = append(results, res)
+		}
+	}
+	r.mu.Unlock()
+
+	before := func(a, b SearchResult) bool {
+		if a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+		return a.Document.ID > b.Document.ID
+	}
+	sort.Slice(results, func(i, j int) bool { return before(results[i], results[j]) })
+
+	page := &Page[SearchResult]{Items: []SearchResult{}, Total: len(results)}
+	limit := q.Page.limit()
+	for _, res := range results {
+		if pivot != nil && !before(*pivot, res) {
+			continue
+		}
+		if len(page.Items) == limit {
+			page.NextCursor = searchCursor(page.Items[limit-1])
+			break
+		}
+		page.Items = append(page.Items, res)
+	}
+	return page, nil
+}
+
+// memorySnippet returns up to 200 bytes of chunk around the first
+// occurrence of a term, with every occurrence marked.
+func memorySnippet(chunk string, terms []string) []SnippetPart {
+	lower := strings.ToLower(chunk)
+	if len(lower) != len(chunk) {
+		// Lowercasing changed byte offsets; fall back to exact matching.
+		lower = chunk
+	}
+	first := len(chunk)
+	for _, t := range terms {
+		if i := strings.Index(lower, t); i >= 0 && i < first {
+			first = i
+		}
+	}
+	start := max(0, first-80)
+	end := min(len(chunk), start+200)
+
+	var marked strings.Builder
+	for i := start; i < end; {
+		matched := ""
+		for _, t := range terms {
+			if strings.HasPrefix(lower[i:], t) && len(t) > len(matched) {
+				matched = t
+			}
+		}
+		if matched == "" {
+			marked.WriteByte(chunk[i])
+			i++
+			continue
+		}
+		marked.WriteString(highlightStart + chunk[i:i+len(matched)] + highlightStop)
+		i += len(matched)
+	}
+	return splitSnippet(strings.ToValidUTF8(marked.String(), ""))
+}
+
+type memChatRepo memoryStore
+
+func (r *memChatRepo) Append(ctx context.Context, msgs ...ChatMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range msgs {
+		if m.ID == "" {
+			m.ID = uuid.New().String()
+		}
+		if m.Timestamp.IsZero() {
+			m.Timestamp = time.Now()
+		}
+		if m.Version == 0 {
+			m.Version = 1
+		}
+		r.chats = append(r.chats, m)
+	}
+	return nil
+}
+
+func (r *memChatRepo) Recent(ctx context.Context, documentID, userID string, version, limit int) ([]ChatMessage, error) {
+	msgs := r.conversation(documentID, userID)
+	kept := msgs[:0]
+	for _, m := range msgs {
+		if m.Version == version {
+			kept = append(kept, m)
+		}
+	}
+	msgs = kept
+	if len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+	return msgs, nil
+}
+
+func (r *memChatRepo) List(ctx context.Context, documentID, userID string, q ChatQuery) (*Page[ChatMessage], error) {
+	pivot, err := chatPivot(q.Page.Cursor, q.Desc)
+	if err != nil {
+		return nil, err
+	}
+	msgs := r.conversation(documentID, userID)
+	if q.Version > 0 {
+		kept := msgs[:0]
+		for _, m := range msgs {
+			if m.Version == q.Version {
+				kept = append(kept, m)
+			}
+		}
+		msgs = kept
+	}
+	if q.Desc {
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+	}
+	after := func(m ChatMessage) bool {
+		c := m.Timestamp.Compare(pivot.Timestamp)
+		if c == 0 {
+			c = strings.Compare(m.ID, pivot.ID)
+		}
+		if q.Desc {
+			return c < 0
+		}
+		return c > 0
+	}
+
+	page := &Page[ChatMessage]{Items: []ChatMessage{}, Total: len(msgs)}
+	limit := q.Page.limit()
+	for _, m := range msgs {
+		if pivot != nil && !after(m) {
+			continue
+		}
+		if len(page.Items) == limit {
+			page.NextCursor = chatCursor(page.Items[limit-1], q.Desc)
+			break
+		}
+		page.Items = append(page.Items, m)
+	}
+	return page, nil
+}
+
+// conversation returns the user's messages about the document, oldest
+// first.
+func (r *memChatRepo) conversation(documentID, userID string) []ChatMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var msgs []ChatMessage
+	for _, m := range r.chats {
+		if m.DocumentID == documentID && m.UserID == userID {
+			msgs = append(msgs, m)
+		}
+	}
+	sort.SliceStable(msgs, func(i, j int) bool {
+		if c := msgs[i].Timestamp.Compare(msgs[j].Timestamp); c != 0 {
+			return c < 0
+		}
+		return msgs[i].ID < msgs[j].ID
+	})
+	return msgs
+}
+
+type memFolderRepo memoryStore
+
+// nameTaken reports whether the parent already holds another folder of
+// the given name, ignoring case.
+func (r *memFolderRepo) nameTaken(f *Folder) bool {
+	for _, other := range r.folders {
+		if other.ID != f.ID && other.UserID == f.UserID && other.ParentID == f.ParentID &&
+			strings.EqualFold(other.Name, f.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ownsParent reports whether f's parent, if any, belongs to f's user.
+func (r *memFolderRepo) ownsParent(f *Folder) bool {
+	if f.ParentID == "" {
+		return true
+	}
+	parent, ok := r.folders[f.ParentID]
+	return ok && parent.UserID == f.UserID
+}
+
+func (r *memFolderRepo) count(f Folder) Folder {
+	f.DocumentCount = 0
+	for _, doc := range r.documents {
+		if doc.FolderID == f.ID && doc.DeletedAt == nil {
+			f.DocumentCount++
+		}
+	}
+	return f
+}
+
+func (r *memFolderRepo) Create(ctx context.Context, f *Folder) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f.ID == "" {
+		f.ID = uuid.New().String()
+	}
+	if f.CreatedAt.IsZero() {
+		f.CreatedAt = time.Now()
+	}
+	if !r.ownsParent(f) {
+		return ErrNotFound
+	}
+	if _, ok := r.folders[f.ID]; ok || r.nameTaken(f) {
+		return ErrConflict
+	}
+	f.DocumentCount = 0
+	r.folders[f.ID] = *f
+	return nil
+}
+
+func (r *memFolderRepo) Get(ctx context.Context, id, userID string) (*Folder, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.folders[id]
+	if !ok || f.UserID != userID {
+		return nil, ErrNotFound
+	}
+	f = r.count(f)
+	return &f, nil
+}
+
+func (r *memFolderRepo) List(ctx context.Context, userID string) ([]Folder, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	folders := []Folder{}
+	for _, f := range r.folders {
+		if f.UserID == userID {
+			folders = append(folders, r.count(f))
+		}
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		a, b := strings.ToLower(folders[i].Name), strings.ToLower(folders[j].Name)
+		if a != b {
+			return a < b
+		}
+		return folders[i].ID < folders[j].ID
+	})
+	return folders, nil
+}
+
+func (r *memFolderRepo) Update(ctx context.Context, f *Folder) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.folders[f.ID]
+	if !ok || stored.UserID != f.UserID || !r.ownsParent(f) {
+		return ErrNotFound
+	}
+	if f.ParentID != "" && (*memoryStore)(r).subtree(f.ID)[f.ParentID] {
+		return ErrFolderCycle
+	}
+	if r.nameTaken(f) {
+		return ErrConflict
+	}
+	stored.Name = f.Name
+	stored.ParentID = f.ParentID
+	r.folders[f.ID] = stored
+	return nil
+}
+
+func (r *memFolderRepo) Delete(ctx context.Context, id, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.folders[id]
+	if !ok || f.UserID != userID {
+		return ErrNotFound
+	}
+	removed := (*memoryStore)(r).subtree(id)
+	for docID, doc := range r.documents {
+		if removed[doc.FolderID] {
+			doc.FolderID = f.ParentID
+			r.documents[docID] = doc
+		}
+	}
+	for folderID := range removed {
+		delete(r.folders, folderID)
+	}
+	return nil
+}
+
+func (r *memFolderRepo) MoveDocuments(ctx context.Context, userID, folderID string, documentIDs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if folderID != "" {
+		if f, ok := r.folders[folderID]; !ok || f.UserID != userID {
+			return ErrNotFound
+		}
+	}
+	for _, id := range documentIDs {
+		if _, ok := (*memoryStore)(r).live(id, userID); !ok {
+			return ErrNotFound
+		}
+	}
+	for _, id := range documentIDs {
+		doc := r.documents[id]
+		doc.FolderID = folderID
+		r.documents[id] = doc
+	}
+	return nil
+}
+
+type memTagRepo memoryStore
+
+// byName returns the user's tag of the given name, ignoring case.
+func (r *memTagRepo) byName(userID, name string) (Tag, bool) {
+	for _, t := range r.tags {
+		if t.UserID == userID && strings.EqualFold(t.Name, name) {
+			return t, true
+		}
+	}
+	return Tag{}, false
+}
+
+func (r *memTagRepo) count(t Tag) Tag {
+	t.DocumentCount = 0
+	for id, tags := range r.docTags {
+		if tags[t.ID] && r.documents[id].DeletedAt == nil {
+			t.DocumentCount++
+		}
+	}
+	return t
+}
+
+func (r *memTagRepo) Create(ctx context.Context, t *Tag) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t.ID == "" {
+		t.ID = uuid.New().String()
+	}
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now()
+	}
+	if _, taken := r.byName(t.UserID, t.Name); taken {
+		return ErrConflict
+	}
+	t.DocumentCount = 0
+	r.tags[t.ID] = *t
+	return nil
+}
+
+func (r *memTagRepo) List(ctx context.Context, userID string) ([]Tag, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tags := []Tag{}
+	for _, t := range r.tags {
+		if t.UserID == userID {
+			tags = append(tags, r.count(t))
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		a, b := strings.ToLower(tags[i].Name), strings.ToLower(tags[j].Name)
+		if a != b {
+			return a < b
+		}
+		return tags[i].ID < tags[j].ID
+	})
+	return tags, nil
+}
+
+func (r *memTagRepo) Rename(ctx context.Context, t *Tag) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.tags[t.ID]
+	if !ok || stored.UserID != t.UserID {
+		return ErrNotFound
+	}
+	if other, taken := r.byName(t.UserID, t.Name); taken && other.ID != t.ID {
+		return ErrConflict
+	}
+	stored.Name = t.Name
+	r.tags[t.ID] = stored
+	*t = r.count(stored)
+	return nil
+}
+
+func (r *memTagRepo) Delete(ctx context.Context, id, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tags[id]
+	if !ok || t.UserID != userID {
+		return ErrNotFound
+	}
+	for _, tags := range r.docTags {
+		delete(tags, id)
+	}
+	delete(r.tags, id)
+	return nil
+}
+
+func (r *memTagRepo) TagDocuments(ctx context.Context, userID string, documentIDs, add, remove []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range documentIDs {
+		if _, ok := (*memoryStore)(r).live(id, userID); !ok {
+			return ErrNotFound
+		}
+	}
+	for _, name := range add {
+		t, ok := r.byName(userID, name)
+		if !ok {
+			t = Tag{ID: uuid.New().String(), UserID: userID, Name: name, CreatedAt: time.Now()}
+			r.tags[t.ID] = t
+		}
+		for _, id := range documentIDs {
+			if r.docTags[id] == nil {
+				r.docTags[id] = make(map[string]bool)
+			}
+			r.docTags[id][t.ID] = true
+		}
+	}
+	for _, name := range remove {
+		if t, ok := r.byName(userID, name); ok {
+			for _, id := range documentIDs {
+				delete(r.docTags[id], t.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// quotaKey identifies a user's usage in one period.
+type quotaKey struct {
+	userID string
+	period string
+	start  time.Time
+}
+
+type memQuotaRepo memoryStore
+
+// Limits returns defaults: the memory store keeps no per-user overrides.
+func (r *memQuotaRepo) Limits(ctx context.Context, userID string, defaults QuotaLimits) (QuotaLimits, error) {
+	return defaults, nil
+}
+
+func (r *memQuotaRepo) Usage(ctx context.Context, userID string, day, month time.Time) (daily, monthly QuotaUsage, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.quotaUsage[quotaKey{userID, "day", day}], r.quotaUsage[quotaKey{userID, "month", month}], nil
+}
+
+func (r *memQuotaRepo) Reserve(ctx context.Context, userID string, day, month time.Time, limits QuotaLimits) (daily, monthly QuotaUsage, ok bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dayKey, monthKey := quotaKey{userID, "day", day}, quotaKey{userID, "month", month}
+	daily, monthly = r.quotaUsage[dayKey], r.quotaUsage[monthKey]
+	if !limits.allows(daily, monthly) {
+		return daily, monthly, false, nil
+	}
+	daily.Calls++
+	monthly.Calls++
+	r.quotaUsage[dayKey], r.quotaUsage[monthKey] = daily, monthly
+	return daily, monthly, true, nil
+}
+
+func (r *memQuotaRepo) Settle(ctx context.Context, userID string, day, month time.Time, calls, tokens int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, k := range []quotaKey{{userID, "day", day}, {userID, "month", month}} {
+		u := r.quotaUsage[k]
+		u.Calls = max(u.Calls+int64(calls), 0)
+		u.Tokens += int64(tokens)
+		r.quotaUsage[k] = u
+	}
+	return nil
+}
+
+type memUsageRepo memoryStore
+
+// usageRecord is a metered request with the time it was recorded.
+type usageRecord struct {
+	LLMUsage
+	createdAt time.Time
+}
+
+func (r *memUsageRepo) Record(ctx context.Context, u LLMUsage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.usage = append(r.usage, usageRecord{LLMUsage: u, createdAt: time.Now().UTC()})
+	return nil
+}
+
+func (r *memUsageRepo) Report(ctx context.Context, from, to time.Time, userID string) ([]UsageReportRow, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	type key struct{ day, userID, model string }
+	rows := make(map[key]*UsageReportRow)
+	latency := make(map[key]float64)
+	for _, u := range r.usage {
+		if u.createdAt.Before(from) || !u.createdAt.Before(to) || (userID != "" && u.UserID != userID) {
+			continue
+		}
+		k := key{u.createdAt.Format("2006-01-02"), u.UserID, u.Model}
+		row := rows[k]
+		if row == nil {
+			row = &UsageReportRow{Day: k.day, UserID: k.userID, Model: k.model}
+			rows[k] = row
+		}
+		row.Calls++
+		if u.Status != "ok" {
+			row.Errors++
+		}
+		row.PromptTokens += int64(u.PromptTokens)
+		row.CompletionTokens += int64(u.CompletionTokens)
+		row.EstimatedCost += u.EstimatedCost
+		latency[k] += float64(u.Latency.Milliseconds())
+	}
+	report := []UsageReportRow{}
+	for k, row := range rows {
+		row.TotalTokens = row.PromptTokens + row.CompletionTokens
+		row.AvgLatencyMs = latency[k] / float64(row.Calls)
+		report = append(report, *row)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		if a.Day != b.Day {
+			return a.Day > b.Day
+		}
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		return a.Model < b.Model
+	})
+	return report, nil
+}
+
+type memAuditRepo memoryStore
+
+func (r *memAuditRepo) Append(ctx context.Context, ev AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.audit = append(r.audit, ev)
+	return nil
+}
+
+func (f AuditFilter) matches(ev AuditEvent) bool {
+	return (f.Action == "" || ev.Action == f.Action) &&
+		(f.ActorID == "" || ev.ActorID == f.ActorID) &&
+		(f.TargetType == "" || ev.TargetType == f.TargetType) &&
+		(f.TargetID == "" || ev.TargetID == f.TargetID) &&
+		(f.Outcome == "" || ev.Outcome == f.Outcome) &&
+		(f.IP == "" || ev.IP == f.IP) &&
+		(f.From.IsZero() || !ev.CreatedAt.Before(f.From)) &&
+		(f.To.IsZero() || ev.CreatedAt.Before(f.To))
+}
+
+// matching returns the events matching f, oldest first.
+func (r *memAuditRepo) matching(f AuditFilter) []AuditEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []AuditEvent
+	for _, ev := range r.audit {
+		if f.matches(ev) {
+			events = append(events, ev)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].CreatedAt.Equal(events[j].CreatedAt) {
+			return events[i].CreatedAt.Before(events[j].CreatedAt)
+		}
+		return events[i].ID < events[j].ID
+	})
+	return events
+}
+
+func (r *memAuditRepo) List(ctx context.Context, f AuditFilter, limit, offset int) ([]AuditEvent, int, error) {
+	events := r.matching(f)
+	page := []AuditEvent{}
+	for i := len(events) - 1 - offset; i >= 0 && len(page) < limit; i-- {
+		page = append(page, events[i])
+	}
+	return page, len(events), nil
+}
+
+func (r *memAuditRepo) Export(ctx context.Context, f AuditFilter, fn func(AuditEvent) error) error {
+	for _, ev := range r.matching(f) {
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned for a cursor that is malformed or was
+// issued for a different ordering.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page size bounds shared by every paginated listing.
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// PageRequest asks for up to Limit items following Cursor, the NextCursor
+// of the previous page (empty for the first page).
+type PageRequest struct {
+	Limit  int
+	Cursor string
+}
+
+func (p PageRequest) limit() int {
+	if p.Limit <= 0 {
+		return DefaultPageSize
+	}
+	if p.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return p.Limit
+}
+
+// Page is one page of a listing. NextCursor is empty on the last page;
+// Total counts every item matching the filters, across all pages.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	Total      int    `json:"total"`
+}
+
+// Document sort keys.
+const (
+	SortUploaded = "uploaded"
+	SortName     = "name"
+	SortSize     = "size"
+)
+
+// DocumentFilter narrows a document listing. Zero fields match everything.
+type DocumentFilter struct {
+	// Types are file extensions without the dot, e.g. "pdf".
+	Types        []string
+	Status       string
+	UploadedFrom time.Time
+	// UploadedTo is exclusive.
+	UploadedTo time.Time
+	MimeType   string
+	Language   string
+	Extractor  string
+	// MinSize and MaxSize bound the size in bytes, inclusively; zero
+	// means unbounded.
+	MinSize int64
+	MaxSize int64
+	// FolderID keeps the documents directly in the folder, or anywhere
+	// below it with IncludeSubfolders. Unfiled keeps the documents outside
+	// every folder.
+	FolderID          string
+	IncludeSubfolders bool
+	Unfiled           bool
+	// Tags keeps the documents carrying every one of the named tags,
+	// ignoring case.
+	Tags []string
+}
+
+// matches reports whether doc passes every filter other than folder and
+// tags, which depend on other records.
+func (f DocumentFilter) matches(doc Document) bool {
+	return matchesType(doc.FileName, f.Types) &&
+		(f.Status == "" || doc.Status == f.Status) &&
+		(f.UploadedFrom.IsZero() || !doc.UploadedAt.Before(f.UploadedFrom)) &&
+		(f.UploadedTo.IsZero() || doc.UploadedAt.Before(f.UploadedTo)) &&
+		(f.MimeType == "" || doc.MimeType == f.MimeType) &&
+		(f.Language == "" || doc.Language == f.Language) &&
+		(f.Extractor == "" || doc.Extractor == f.Extractor) &&
+		(f.MinSize == 0 || doc.SizeBytes >= f.MinSize) &&
+		(f.MaxSize == 0 || doc.SizeBytes <= f.MaxSize)
+}
+
+type DocumentQuery struct {
+	DocumentFilter
+	Sort string
+	Desc bool
+	Page PageRequest
+}
+
+// cursor is the decoded form of the opaque page token: the sort it was
+// issued for and the sort key and ID of the last item returned. The ID
+// breaks ties so that ordering, and therefore paging, is total.
+type cursor struct {
+	Sort string `json:"s"`
+	Desc bool   `json:"d,omitempty"`
+	Key  string `json:"k"`
+	ID   string `json:"i"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses s and checks that it belongs to the given ordering.
+func decodeCursor(s, sort string, desc bool) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != sort || c.Desc != desc {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// documentCursor returns the cursor positioned just after doc.
+func documentCursor(doc Document, sort string, desc bool) string {
+	c := cursor{Sort: sort, Desc: desc, ID: doc.ID}
+	switch sort {
+	case SortName:
+		c.Key = doc.FileName
+	case SortSize:
+		c.Key = strconv.FormatInt(doc.SizeBytes, 10)
+	default:
+		c.Key = doc.UploadedAt.Format(time.RFC3339Nano)
+	}
+	return c.encode()
+}
+
+// pivot returns a document carrying the cursor's sort key and ID, for
+// comparisons against stored documents.
+func (c *cursor) pivot() (Document, error) {
+	doc := Document{ID: c.ID}
+	switch c.Sort {
+	case SortName:
+		doc.FileName = c.Key
+	case SortSize:
+		n, err := strconv.ParseInt(c.Key, 10, 64)
+		if err != nil {
+			return doc, ErrInvalidCursor
+		}
+		doc.SizeBytes = n
+	default:
+		t, err := time.Parse(time.RFC3339Nano, c.Key)
+		if err != nil {
+			return doc, ErrInvalidCursor
+		}
+		doc.UploadedAt = t
+	}
+	return doc, nil
+}
+
+// compareDocuments orders a before b (-1), after (1) or equal (0) by the
+// sort key, then by ID.
+func compareDocuments(a, b Document, sort string) int {
+	var c int
+	switch sort {
+	case SortName:
+		c = strings.Compare(a.FileName, b.FileName)
+	case SortSize:
+		c = compareInt64(a.SizeBytes, b.SizeBytes)
+	default:
+		c = a.UploadedAt.Compare(b.UploadedAt)
+	}
+	if c == 0 {
+		c = strings.Compare(a.ID, b.ID)
+	}
+	return c
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// trashCursor returns the cursor positioned just after doc in the trash,
+// which is ordered by deletion time, latest first.
+func trashCursor(doc Document) string {
+	return cursor{Sort: "deleted", Desc: true, Key: doc.DeletedAt.Format(time.RFC3339Nano), ID: doc.ID}.encode()
+}
+
+// trashPivot returns the deletion time and ID of a trash cursor.
+func trashPivot(s string) (*Document, error) {
+	c, err := decodeCursor(s, "deleted", true)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, c.Key)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &Document{ID: c.ID, DeletedAt: &t}, nil
+}
+
+// chunkCursor returns the cursor positioned just after chunk c of the
+// given document version.
+func chunkCursor(documentID string, version int, c Chunk) string {
+	return cursor{Sort: "index", Key: strconv.Itoa(c.Index), ID: documentID + "@" + strconv.Itoa(version)}.encode()
+}
+
+// chunkPivot returns the chunk index of a chunk cursor, or -1 for the
+// first page. The cursor must have been issued for the same version.
+func chunkPivot(s, documentID string, version int) (int, error) {
+	c, err := decodeCursor(s, "index", false)
+	if err != nil || c == nil {
+		return -1, err
+	}
+	index, err := strconv.Atoi(c.Key)
+	if err != nil || c.ID != documentID+"@"+strconv.Itoa(version) {
+		return 0, ErrInvalidCursor
+	}
+	return index, nil
+}
+
+// chatCursor returns the cursor positioned just after m.
+func chatCursor(m ChatMessage, desc bool) string {
+	return cursor{Sort: "timestamp", Desc: desc, Key: m.Timestamp.Format(time.RFC3339Nano), ID: m.ID}.encode()
+}
+
+// chatPivot returns the timestamp and ID of a chat cursor.
+func chatPivot(s string, desc bool) (*ChatMessage, error) {
+	c, err := decodeCursor(s, "timestamp", desc)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, c.Key)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &ChatMessage{ID: c.ID, Timestamp: t}, nil
+}
+
+// matchesType reports whether fileName has one of the extensions in types.
+func matchesType(fileName string, types []string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	name := strings.ToLower(fileName)
+	for _, t := range types {
+		if strings.HasSuffix(name, "."+strings.ToLower(t)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// walk fetches every page of a listing, starting from the first, and
+// returns the items in order. Every page must report total items.
+func walk[T any](t *testing.T, total int, fetch func(cursor string) (*Page[T], error)) []T {
+	t.Helper()
+	var items []T
+	cursor := ""
+	for range total + 2 {
+		page, err := fetch(cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != total {
+			t.Fatalf("page total = %d, want %d", page.Total, total)
+		}
+		items = append(items, page.Items...)
+		if page.NextCursor == "" {
+			return items
+		}
+		cursor = page.NextCursor
+	}
+	t.Fatalf("listing did not end after %d pages", total+2)
+	return nil
+}
+
+// seedDocuments stores n documents of user whose names, sizes and upload
+// times repeat, so that every sort needs the ID to break ties.
+func seedDocuments(t *testing.T, repos Repos, user string, n int) {
+	t.Helper()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range n {
+		doc := &Document{
+			ID:         fmt.Sprintf("%s-%03d", user, (i*37)%n), // stored out of ID order
+			UserID:     user,
+			FileName:   fmt.Sprintf("report-%d.pdf", i%4),
+			SizeBytes:  int64(i % 3 * 1000),
+			UploadedAt: base.Add(time.Duration(i%5) * time.Hour),
+		}
+		if err := repos.Documents.Create(context.Background(), doc, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDocumentPagesCoverEveryDocumentOnce(t *testing.T) {
+	const n = 25
+	repos := NewMemory()
+	seedDocuments(t, repos, "alice", n)
+	seedDocuments(t, repos, "bob", 3)
+
+	for _, sort := range []string{SortUploaded, SortName, SortSize} {
+		for _, desc := range []bool{false, true} {
+			for _, limit := range []int{1, 7, 0} {
+				t.Run(fmt.Sprintf("%s desc=%v limit=%d", sort, desc, limit), func(t *testing.T) {
+					docs := walk(t, n, func(cursor string) (*Page[Document], error) {
+						return repos.Documents.List(context.Background(), "alice", DocumentQuery{
+							Sort: sort, Desc: desc, Page: PageRequest{Limit: limit, Cursor: cursor},
+						})
+					})
+					if len(docs) != n {
+						t.Fatalf("walked %d documents, want %d", len(docs), n)
+					}
+					seen := map[string]bool{}
+					for i, doc := range docs {
+						if seen[doc.ID] {
+							t.Errorf("%s listed twice", doc.ID)
+						}
+						seen[doc.ID] = true
+						if i == 0 {
+							continue
+						}
+						c := compareDocuments(docs[i-1], doc, sort)
+						if desc {
+							c = -c
+						}
+						if c >= 0 {
+							t.Errorf("%s listed before %s", docs[i-1].ID, doc.ID)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestPageLimitIsClamped(t *testing.T) {
+	for _, tt := range []struct{ limit, want int }{
+		{-1, DefaultPageSize}, {0, DefaultPageSize}, {1, 1}, {MaxPageSize, MaxPageSize}, {MaxPageSize + 1, MaxPageSize},
+	} {
+		if got := (PageRequest{Limit: tt.limit}).limit(); got != tt.want {
+			t.Errorf("limit(%d) = %d, want %d", tt.limit, got, tt.want)
+		}
+	}
+
+	repos := NewMemory()
+	seedDocuments(t, repos, "alice", MaxPageSize+10)
+	page, err := repos.Documents.List(context.Background(), "alice", DocumentQuery{Page: PageRequest{Limit: 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != MaxPageSize || page.NextCursor == "" {
+		t.Errorf("page of %d items, next cursor %q, want %d and more to come", len(page.Items), page.NextCursor, MaxPageSize)
+	}
+}
+
+func TestCursorIsBoundToItsOrdering(t *testing.T) {
+	repos := NewMemory()
+	seedDocuments(t, repos, "alice", 5)
+	ctx := context.Background()
+	first, err := repos.Documents.List(ctx, "alice", DocumentQuery{Sort: SortName, Page: PageRequest{Limit: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		sort   string
+		desc   bool
+		cursor string
+	}{
+		{"other direction", SortName, true, first.NextCursor},
+		{"other sort", SortSize, false, first.NextCursor},
+		{"not base64", SortName, false, "not a cursor!"},
+		{"not JSON", SortName, false, base64.RawURLEncoding.EncodeToString([]byte("name"))},
+		{"no ID", SortName, false, cursor{Sort: SortName, Key: "report"}.encode()},
+		{"bad size key", SortSize, false, cursor{Sort: SortSize, Key: "big", ID: "alice-001"}.encode()},
+		{"bad time key", SortUploaded, false, cursor{Sort: SortUploaded, Key: "yesterday", ID: "alice-001"}.encode()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := repos.Documents.List(ctx, "alice", DocumentQuery{
+				Sort: tt.sort, Desc: tt.desc, Page: PageRequest{Limit: 2, Cursor: tt.cursor},
+			})
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("err = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestChunkPages(t *testing.T) {
+	repos := NewMemory()
+	ctx := context.Background()
+	chunks := make([]string, 10)
+	for i := range chunks {
+		chunks[i] = fmt.Sprintf("chunk %d. ", i)
+	}
+	doc := &Document{ID: "doc", UserID: "alice"}
+	if err := repos.Documents.Create(ctx, doc, chunks); err != nil {
+		t.Fatal(err)
+	}
+
+	got := walk(t, len(chunks), func(cursor string) (*Page[Chunk], error) {
+		return repos.Chunks.ListDetails(ctx, "doc", 1, PageRequest{Limit: 3, Cursor: cursor})
+	})
+	start := 0
+	for i, c := range got {
+		if c.Index != i || c.Content != chunks[i] || c.Start != start || c.End != start+len(chunks[i]) {
+			t.Errorf("chunk %d = %+v", i, c)
+		}
+		start = c.End
+	}
+	if len(got) != len(chunks) {
+		t.Errorf("walked %d chunks, want %d", len(got), len(chunks))
+	}
+
+	page, err := repos.Chunks.ListDetails(ctx, "doc", 1, PageRequest{Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repos.Chunks.ListDetails(ctx, "doc", 2, PageRequest{Cursor: page.NextCursor}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("cursor of another version: err = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestChatPages(t *testing.T) {
+	repos := NewMemory()
+	ctx := context.Background()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	const n = 11
+	for i := range n {
+		// Messages saved together share a timestamp.
+		err := repos.Chats.Append(ctx, ChatMessage{
+			ID: fmt.Sprintf("msg-%02d", (i*7)%n), DocumentID: "doc", UserID: "alice",
+			Type: "user", Content: "Why?", Timestamp: base.Add(time.Duration(i/2) * time.Minute),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, desc := range []bool{false, true} {
+		t.Run(fmt.Sprintf("desc=%v", desc), func(t *testing.T) {
+			msgs := walk(t, n, func(cursor string) (*Page[ChatMessage], error) {
+				return repos.Chats.List(ctx, "doc", "alice", ChatQuery{Desc: desc, Page: PageRequest{Limit: 2, Cursor: cursor}})
+			})
+			if len(msgs) != n {
+				t.Fatalf("walked %d messages, want %d", len(msgs), n)
+			}
+			for i := 1; i < len(msgs); i++ {
+				a, b := msgs[i-1], msgs[i]
+				c := a.Timestamp.Compare(b.Timestamp)
+				if c == 0 {
+					c = compareStrings(a.ID, b.ID)
+				}
+				if desc {
+					c = -c
+				}
+				if c >= 0 {
+					t.Errorf("%s listed before %s", a.ID, b.ID)
+				}
+			}
+		})
+	}
+
+	page, err := repos.Chats.List(ctx, "doc", "alice", ChatQuery{Page: PageRequest{Limit: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repos.Chats.List(ctx, "doc", "alice", ChatQuery{Desc: true, Page: PageRequest{Cursor: page.NextCursor}})
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("cursor of the other direction: err = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func compareStrings(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,1846 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// NewPostgres returns repositories backed by db. searchLanguage is the
+// text search configuration, such as "english", that new chunks are
+// indexed with and searches run under.
+func NewPostgres(db *sql.DB, searchLanguage string) Repos {
+	return Repos{
+		Users:     &pgUserRepo{db: db},
+		Documents: &pgDocumentRepo{db: db, language: searchLanguage},
+		Chunks:    &pgChunkRepo{db: db, language: searchLanguage},
+		Chats:     &pgChatRepo{db: db},
+		Folders:   &pgFolderRepo{db: db},
+		Tags:      &pgTagRepo{db: db},
+		BlobQueue: &pgBlobQueueRepo{db: db},
+		Quotas:    &pgQuotaRepo{db: db},
+		Usage:     &pgUsageRepo{db: db},
+		Audit:     &pgAuditRepo{db: db},
+		Ping:      db.PingContext,
+	}
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+// nonNil returns s, or an empty slice if s is nil, for NOT NULL array
+// columns.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+type pgUserRepo struct {
+	db *sql.DB
+}
+
+func (r *pgUserRepo) Get(ctx context.Context, id string) (*User, error) {
+	var u User
+	err := r.db.QueryRowContext(ctx, "SELECT id, email, created_at FROM users WHERE id = $1", id).
+		Scan(&u.ID, &u.Email, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (r *pgUserRepo) Upsert(ctx context.Context, id, email string) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO users (id, email) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET email = EXCLUDED.email`, id, email)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+func (r *pgUserRepo) UpdateEmail(ctx context.Context, id, email string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET email = $1 WHERE id = $2", email, id)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+type pgDocumentRepo struct {
+	db       *sql.DB
+	language string
+}
+
+const documentColumns = "id, user_id, file_name, storage_path, uploaded_at, size_bytes, " +
+	"mime_type, sha256, page_count, language, word_count, extractor, status, processing_error, version, " +
+	"folder_id, suggested_tags, chunk_strategy, chunk_size, description, metadata, deleted_at"
+
+// versionColumns lists the document_versions columns in DocumentVersion
+// field order.
+const versionColumns = "document_id, version, file_name, storage_path, uploaded_at, size_bytes, " +
+	"mime_type, sha256, page_count, language, word_count, extractor, status, processing_error, " +
+	"chunk_strategy, chunk_size"
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanDocument scans documentColumns, after any extra leading columns
+// into extra.
+func scanDocument(s scanner, extra ...interface{}) (Document, error) {
+	var doc Document
+	var folderID sql.NullString
+	var metadata []byte
+	var deletedAt sql.NullTime
+	err := s.Scan(append(extra, &doc.ID, &doc.UserID, &doc.FileName, &doc.StoragePath, &doc.UploadedAt, &doc.SizeBytes,
+		&doc.MimeType, &doc.SHA256, &doc.PageCount, &doc.Language, &doc.WordCount, &doc.Extractor, &doc.Status, &doc.ProcessingError, &doc.Version,
+		&folderID, pq.Array(&doc.SuggestedTags), &doc.ChunkStrategy, &doc.ChunkSize, &doc.Description, &metadata, &deletedAt)...)
+	if err != nil {
+		return doc, err
+	}
+	doc.FolderID = folderID.String
+	if deletedAt.Valid {
+		doc.DeletedAt = &deletedAt.Time
+	}
+	if err := json.Unmarshal(metadata, &doc.Metadata); err != nil {
+		return doc, fmt.Errorf("error decoding metadata of document %s: %v", doc.ID, err)
+	}
+	return doc, nil
+}
+
+// metadataJSON encodes document metadata for the metadata column.
+func metadataJSON(m map[string]string) string {
+	if len(m) == 0 {
+		return "{}"
+	}
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+func scanDocuments(rows *sql.Rows) ([]Document, error) {
+	defer rows.Close()
+	documents := []Document{}
+	for rows.Next() {
+		doc, err := scanDocument(rows)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, doc)
+	}
+	return documents, rows.Err()
+}
+
+func (r *pgDocumentRepo) Create(ctx context.Context, doc *Document, chunks []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	chunkSetID, err := insertChunks(ctx, tx, r.language, chunks)
+	if err != nil {
+		return err
+	}
+	if err := insertDocument(ctx, tx, doc, chunkSetID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertDocument inserts doc as version 1 of a new document, whose chunks
+// are the chunk set chunkSetID ("" for none).
+func insertDocument(ctx context.Context, tx *sql.Tx, doc *Document, chunkSetID string) error {
+	if doc.Status == "" {
+		doc.Status = StatusReady
+	}
+	doc.Version = 1
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO documents (`+documentColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, NULLIF($16, ''), $17,
+			$18, $19, $20, $21, NULL)`,
+		doc.ID, doc.UserID, doc.FileName, doc.StoragePath, doc.UploadedAt, doc.SizeBytes,
+		doc.MimeType, doc.SHA256, doc.PageCount, doc.Language, doc.WordCount, doc.Extractor, doc.Status, doc.ProcessingError,
+		doc.Version, doc.FolderID, pq.Array(nonNil(doc.SuggestedTags)),
+		doc.ChunkStrategy, doc.ChunkSize, doc.Description, metadataJSON(doc.Metadata))
+	if err != nil {
+		return fmt.Errorf("error inserting document: %v", err)
+	}
+	return insertVersion(ctx, tx, doc.CurrentVersion(), chunkSetID)
+}
+
+func insertVersion(ctx context.Context, tx *sql.Tx, v DocumentVersion, chunkSetID string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO document_versions (`+versionColumns+`, chunk_set_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, NULLIF($17, ''))`,
+		v.DocumentID, v.Version, v.FileName, v.StoragePath, v.UploadedAt, v.SizeBytes,
+		v.MimeType, v.SHA256, v.PageCount, v.Language, v.WordCount, v.Extractor, v.Status, v.ProcessingError,
+		v.ChunkStrategy, v.ChunkSize, chunkSetID)
+	if err != nil {
+		return fmt.Errorf("error inserting document version: %v", err)
+	}
+	return nil
+}
+
+// copyThreshold is the chunk count from which insertChunks switches from
+// a multi-row INSERT to COPY. Below it COPY's extra round trips to set up
+// and finish the stream cost more than they save.
+const copyThreshold = 64
+
+// chunkBatchSize is the number of chunk rows sent per INSERT statement.
+// Each row uses 5 parameters, well below Postgres' limit of 65535.
+const chunkBatchSize = 500
+
+// insertChunks writes chunks inside tx as a new chunk set, used by one
+// version, to be indexed for search under language, and returns its ID. No
+// set is created for no chunks; the ID is then "". Large documents are
+// streamed with COPY; small ones use batched multi-row INSERTs.
+func insertChunks(ctx context.Context, tx *sql.Tx, language string, chunks []string) (string, error) {
+	if len(chunks) == 0 {
+		return "", nil
+	}
+	setID := uuid.New().String()
+	if _, err := tx.ExecContext(ctx, "INSERT INTO chunk_sets (id, ref_count) VALUES ($1, 1)", setID); err != nil {
+		return "", fmt.Errorf("error inserting chunk set: %v", err)
+	}
+	insert := batchInsertChunks
+	if len(chunks) >= copyThreshold {
+		insert = copyChunks
+	}
+	if err := insert(ctx, tx, setID, language, chunks); err != nil {
+		return "", err
+	}
+	return setID, nil
+}
+
+func copyChunks(ctx context.Context, tx *sql.Tx, setID, language string, chunks []string) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("document_chunks", "id", "chunk_set_id", "chunk_index", "content", "language"))
+	if err != nil {
+		return fmt.Errorf("error starting chunk copy: %v", err)
+	}
+	defer stmt.Close()
+
+	for i, chunk := range chunks {
+		if _, err := stmt.ExecContext(ctx, uuid.New().String(), setID, i, chunk, language); err != nil {
+			return fmt.Errorf("error copying chunk %d: %v", i, err)
+		}
+	}
+	// An Exec without arguments flushes the buffered rows.
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return fmt.Errorf("error copying chunks: %v", err)
+	}
+	return nil
+}
+
+func batchInsertChunks(ctx context.Context, tx *sql.Tx, setID, language string, chunks []string) error {
+	for start := 0; start < len(chunks); start += chunkBatchSize {
+		end := start + chunkBatchSize
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+
+		var query strings.Builder
+		query.WriteString("INSERT INTO document_chunks (id, chunk_set_id, chunk_index, content, language) VALUES ")
+		args := make([]interface{}, 0, (end-start)*5)
+		for i := start; i < end; i++ {
+			if i > start {
+				query.WriteString(", ")
+			}
+			n := len(args)
+			fmt.Fprintf(&query, "($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5)
+			args = append(args, uuid.New().String(), setID, i, chunks[i], language)
+		}
+		if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+			return fmt.Errorf("error inserting chunks %d-%d: %v", start, end-1, err)
+		}
+	}
+	return nil
+}
+
+func (r *pgDocumentRepo) Get(ctx context.Context, id, userID string) (*Document, error) {
+	doc, err := scanDocument(r.db.QueryRowContext(ctx,
+		"SELECT "+documentColumns+" FROM documents WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL", id, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := loadTags(ctx, r.db, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// loadTags fills in the tag names of docs.
+func loadTags(ctx context.Context, db *sql.DB, docs ...*Document) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	ids := make([]string, len(docs))
+	byID := make(map[string]*Document, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+		byID[doc.ID] = doc
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT dt.document_id, t.name
+		FROM document_tags dt JOIN tags t ON t.id = dt.tag_id
+		WHERE dt.document_id = ANY($1)
+		ORDER BY lower(t.name), t.name`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var documentID, name string
+		if err := rows.Scan(&documentID, &name); err != nil {
+			return err
+		}
+		doc := byID[documentID]
+		doc.Tags = append(doc.Tags, name)
+	}
+	return rows.Err()
+}
+
+// folderSubtree is a subquery selecting the folder whose ID is the given
+// parameter and every folder below it.
+func folderSubtree(param string) string {
+	return `WITH RECURSIVE subtree AS (
+		SELECT id FROM folders WHERE id = ` + param + `
+		UNION
+		SELECT f.id FROM folders f JOIN subtree s ON f.parent_id = s.id
+	) SELECT id FROM subtree`
+}
+
+// documentSortColumns maps sort keys to the column ordered on.
+var documentSortColumns = map[string]string{
+	SortUploaded: "uploaded_at",
+	SortName:     "file_name",
+	SortSize:     "size_bytes",
+}
+
+func (r *pgDocumentRepo) List(ctx context.Context, userID string, q DocumentQuery) (*Page[Document], error) {
+	column, ok := documentSortColumns[q.Sort]
+	if !ok {
+		q.Sort, column = SortUploaded, documentSortColumns[SortUploaded]
+	}
+	c, err := decodeCursor(q.Page.Cursor, q.Sort, q.Desc)
+	if err != nil {
+		return nil, err
+	}
+
+	conds := []string{"user_id = $1", "deleted_at IS NULL"}
+	args := []interface{}{userID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if len(q.Types) > 0 {
+		patterns := make([]string, len(q.Types))
+		for i, t := range q.Types {
+			patterns[i] = "%." + strings.ToLower(t)
+		}
+		conds = append(conds, "lower(file_name) LIKE ANY("+arg(pq.Array(patterns))+")")
+	}
+	if q.Status != "" {
+		conds = append(conds, "status = "+arg(q.Status))
+	}
+	if !q.UploadedFrom.IsZero() {
+		conds = append(conds, "uploaded_at >= "+arg(q.UploadedFrom))
+	}
+	if !q.UploadedTo.IsZero() {
+		conds = append(conds, "uploaded_at < "+arg(q.UploadedTo))
+	}
+	if q.MimeType != "" {
+		conds = append(conds, "mime_type = "+arg(q.MimeType))
+	}
+	if q.Language != "" {
+		conds = append(conds, "language = "+arg(q.Language))
+	}
+	if q.Extractor != "" {
+		conds = append(conds, "extractor = "+arg(q.Extractor))
+	}
+	if q.MinSize > 0 {
+		conds = append(conds, "size_bytes >= "+arg(q.MinSize))
+	}
+	if q.MaxSize > 0 {
+		conds = append(conds, "size_bytes <= "+arg(q.MaxSize))
+	}
+	switch {
+	case q.FolderID != "" && q.IncludeSubfolders:
+		conds = append(conds, "folder_id IN ("+folderSubtree(arg(q.FolderID))+")")
+	case q.FolderID != "":
+		conds = append(conds, "folder_id = "+arg(q.FolderID))
+	case q.Unfiled:
+		conds = append(conds, "folder_id IS NULL")
+	}
+	for _, tag := range q.Tags {
+		conds = append(conds, `EXISTS (
+			SELECT 1 FROM document_tags dt JOIN tags t ON t.id = dt.tag_id
+			WHERE dt.document_id = documents.id AND lower(t.name) = lower(`+arg(tag)+`))`)
+	}
+
+	page := &Page[Document]{}
+	where := strings.Join(conds, " AND ")
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM documents WHERE "+where, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	dir, cmp := "ASC", ">"
+	if q.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	if c != nil {
+		pivot, err := c.pivot()
+		if err != nil {
+			return nil, err
+		}
+		key := map[string]interface{}{
+			SortUploaded: pivot.UploadedAt,
+			SortName:     pivot.FileName,
+			SortSize:     pivot.SizeBytes,
+		}[q.Sort]
+		where += fmt.Sprintf(" AND (%s, id) %s (%s, %s)", column, cmp, arg(key), arg(pivot.ID))
+	}
+
+	// Fetch one extra row to learn whether another page follows.
+	limit := q.Page.limit()
+	rows, err := r.db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT %s FROM documents WHERE %s ORDER BY %s %s, id %s LIMIT %d",
+		documentColumns, where, column, dir, dir, limit+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	page.Items, err = scanDocuments(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = documentCursor(page.Items[limit-1], q.Sort, q.Desc)
+	}
+	docs := make([]*Document, len(page.Items))
+	for i := range page.Items {
+		docs[i] = &page.Items[i]
+	}
+	if err := loadTags(ctx, r.db, docs...); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (r *pgDocumentRepo) CreateCopy(ctx context.Context, doc *Document, sourceID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The share lock keeps a concurrent Delete of the source from deciding
+	// that it held the blob's last reference.
+	var chunkSetID sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT v.chunk_set_id FROM document_versions v
+		JOIN documents d ON d.id = v.document_id AND d.version = v.version
+		WHERE v.document_id = $1 AND v.storage_path = $2
+		FOR SHARE OF v`, sourceID, doc.StoragePath).Scan(&chunkSetID)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	// The copy uses the source's chunks rather than copies of them.
+	if chunkSetID.Valid {
+		res, err := tx.ExecContext(ctx,
+			"UPDATE chunk_sets SET ref_count = ref_count + 1 WHERE id = $1 AND ref_count > 0", chunkSetID.String)
+		if err != nil {
+			return fmt.Errorf("error sharing chunks: %v", err)
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return ErrNotFound
+		}
+	}
+	if err := insertDocument(ctx, tx, doc, chunkSetID.String); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *pgDocumentRepo) FindByHash(ctx context.Context, userID, sha256 string) (*Document, error) {
+	doc, err := scanDocument(r.db.QueryRowContext(ctx, `
+		SELECT `+documentColumns+` FROM documents
+		WHERE user_id = $1 AND sha256 = $2 AND status = 'ready' AND deleted_at IS NULL
+		ORDER BY uploaded_at, id
+		LIMIT 1`, userID, sha256))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := loadTags(ctx, r.db, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (r *pgDocumentRepo) AddVersion(ctx context.Context, doc *Document, chunks []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if doc.Status == "" {
+		doc.Status = StatusReady
+	}
+	// Only the version after the one read by the caller may be added, so
+	// concurrent uploads cannot both claim the same number.
+	res, err := tx.ExecContext(ctx, `
+		UPDATE documents SET version = $3, file_name = $4, storage_path = $5, size_bytes = $6,
+			mime_type = $7, sha256 = $8, page_count = $9, language = $10, word_count = $11,
+			extractor = $12, status = $13, processing_error = $14, chunk_strategy = $15, chunk_size = $16
+		WHERE id = $1 AND user_id = $2 AND version = $3 - 1 AND deleted_at IS NULL`,
+		doc.ID, doc.UserID, doc.Version, doc.FileName, doc.StoragePath, doc.SizeBytes,
+		doc.MimeType, doc.SHA256, doc.PageCount, doc.Language, doc.WordCount,
+		doc.Extractor, doc.Status, doc.ProcessingError, doc.ChunkStrategy, doc.ChunkSize)
+	if err != nil {
+		return fmt.Errorf("error updating document: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrConflict
+	}
+	chunkSetID, err := insertChunks(ctx, tx, r.language, chunks)
+	if err != nil {
+		return err
+	}
+	v := doc.CurrentVersion()
+	v.UploadedAt = time.Now()
+	if err := insertVersion(ctx, tx, v, chunkSetID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *pgDocumentRepo) ListVersions(ctx context.Context, id, userID string) ([]DocumentVersion, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT v.`+strings.ReplaceAll(versionColumns, ", ", ", v.")+`
+		FROM document_versions v
+		JOIN documents d ON d.id = v.document_id
+		WHERE v.document_id = $1 AND d.user_id = $2 AND d.deleted_at IS NULL
+		ORDER BY v.version`, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []DocumentVersion
+	for rows.Next() {
+		var v DocumentVersion
+		err := rows.Scan(&v.DocumentID, &v.Version, &v.FileName, &v.StoragePath, &v.UploadedAt, &v.SizeBytes,
+			&v.MimeType, &v.SHA256, &v.PageCount, &v.Language, &v.WordCount, &v.Extractor, &v.Status, &v.ProcessingError,
+			&v.ChunkStrategy, &v.ChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+	return versions, nil
+}
+
+func (r *pgDocumentRepo) Delete(ctx context.Context, id, userID string) ([]string, error) {
+	return r.delete(ctx, id, userID, false)
+}
+
+func (r *pgDocumentRepo) Purge(ctx context.Context, id, userID string) ([]string, error) {
+	return r.delete(ctx, id, userID, true)
+}
+
+// delete removes the document, only if it is in the trash when trashed
+// is set.
+func (r *pgDocumentRepo) delete(ctx context.Context, id, userID string, trashed bool) ([]string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var paths []string
+	err = tx.QueryRowContext(ctx, `
+		SELECT array_agg(DISTINCT v.storage_path)
+		FROM documents d JOIN document_versions v ON v.document_id = d.id
+		WHERE d.id = $1 AND d.user_id = $2 AND (NOT $3 OR d.deleted_at IS NOT NULL)`,
+		id, userID, trashed).Scan(pq.Array(&paths))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, ErrNotFound
+	}
+	// A blob has no reference count of its own: it is referenced by the
+	// versions whose storage_path names it, which only CreateCopy adds to
+	// (AddVersion always stores a new blob). Counting them is safe because
+	// every change takes row locks on them first. Locking every version
+	// sharing one of the blobs, in a fixed order, makes of two concurrent
+	// deletes of a blob's last references exactly one see itself as last,
+	// and makes CreateCopy, which share-locks its source version, either
+	// finish first or find the source gone. Checking in a separate
+	// statement sees copies committed while we waited for the locks.
+	_, err = tx.ExecContext(ctx, `
+		SELECT 1 FROM document_versions WHERE storage_path = ANY($1)
+		ORDER BY document_id, version
+		FOR UPDATE`, pq.Array(paths))
+	if err != nil {
+		return nil, err
+	}
+	var chunkSetIDs []string
+	err = tx.QueryRowContext(ctx, `
+		SELECT array_agg(chunk_set_id) FROM document_versions
+		WHERE document_id = $1 AND chunk_set_id IS NOT NULL`, id).Scan(pq.Array(&chunkSetIDs))
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.ExecContext(ctx, `
+		DELETE FROM documents WHERE id = $1 AND user_id = $2 AND (NOT $3 OR deleted_at IS NOT NULL)`,
+		id, userID, trashed)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, ErrNotFound
+	}
+	if err := releaseChunkSets(ctx, tx, chunkSetIDs); err != nil {
+		return nil, err
+	}
+	var orphaned []string
+	err = tx.QueryRowContext(ctx, `
+		SELECT array_agg(p) FROM unnest($1::text[]) p
+		WHERE NOT EXISTS (SELECT 1 FROM document_versions v WHERE v.storage_path = p)`,
+		pq.Array(paths)).Scan(pq.Array(&orphaned))
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO blob_deletions (path) SELECT unnest($1::text[])
+		ON CONFLICT (path) DO NOTHING`, pq.Array(orphaned))
+	if err != nil {
+		return nil, fmt.Errorf("error queueing blob deletions: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return orphaned, nil
+}
+
+// releaseChunkSets drops one reference to each chunk set in ids, which
+// may repeat, and deletes the sets, with their chunks, that are no longer
+// used. The sets stay locked until tx ends, so CreateCopy cannot take up
+// a set meanwhile.
+func releaseChunkSets(ctx context.Context, tx *sql.Tx, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+		WITH released AS (SELECT id, COUNT(*) AS n FROM unnest($1::text[]) id GROUP BY id)
+		UPDATE chunk_sets s SET ref_count = s.ref_count - released.n
+		FROM released WHERE s.id = released.id`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("error releasing chunk sets: %v", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM chunk_sets WHERE id = ANY($1) AND ref_count = 0", pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("error deleting chunk sets: %v", err)
+	}
+	return nil
+}
+
+func (r *pgDocumentRepo) ReferencedPaths(ctx context.Context, paths []string) (map[string]bool, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT DISTINCT storage_path FROM document_versions WHERE storage_path = ANY($1)", pq.Array(paths))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referenced := make(map[string]bool)
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		referenced[p] = true
+	}
+	return referenced, rows.Err()
+}
+
+func (r *pgDocumentRepo) ListChunkless(ctx context.Context, uploadedBefore time.Time) ([]Document, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+documentColumns+`
+		FROM documents d
+		WHERE d.uploaded_at < $1
+		  AND d.status <> 'failed'
+		  AND d.deleted_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM document_versions v WHERE v.document_id = d.id AND v.chunk_set_id IS NOT NULL)`, uploadedBefore)
+	if err != nil {
+		return nil, err
+	}
+	return scanDocuments(rows)
+}
+
+func (r *pgDocumentRepo) SetSuggestedTags(ctx context.Context, id string, tags []string) error {
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE documents SET suggested_tags = $2 WHERE id = $1", id, pq.Array(nonNil(tags)))
+	return err
+}
+
+func (r *pgDocumentRepo) Update(ctx context.Context, id, userID string, change func(*Document) error) (*Document, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	doc, err := scanDocument(tx.QueryRowContext(ctx,
+		"SELECT "+documentColumns+" FROM documents WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL FOR UPDATE", id, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := change(&doc); err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE documents SET file_name = $2, description = $3, metadata = $4 WHERE id = $1",
+		id, doc.FileName, doc.Description, metadataJSON(doc.Metadata))
+	if err != nil {
+		return nil, fmt.Errorf("error updating document: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if err := loadTags(ctx, r.db, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (r *pgDocumentRepo) Reprocess(ctx context.Context, doc *Document, chunks []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the document row orders this against AddVersion, which
+	// updates it too.
+	var version int
+	err = tx.QueryRowContext(ctx,
+		"SELECT version FROM documents WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL FOR UPDATE",
+		doc.ID, doc.UserID).Scan(&version)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if version != doc.Version {
+		return ErrConflict
+	}
+	// The version's chunks may be shared with duplicates, so new ones
+	// replace its reference to them rather than the chunks themselves.
+	var oldSetID sql.NullString
+	err = tx.QueryRowContext(ctx,
+		"SELECT chunk_set_id FROM document_versions WHERE document_id = $1 AND version = $2",
+		doc.ID, doc.Version).Scan(&oldSetID)
+	if err != nil {
+		return err
+	}
+	chunkSetID, err := insertChunks(ctx, tx, r.language, chunks)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{doc.ID, doc.Version, doc.Language, doc.WordCount, doc.Extractor, doc.Status,
+		doc.ProcessingError, doc.ChunkStrategy, doc.ChunkSize}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE documents SET language = $3, word_count = $4, extractor = $5, status = $6,
+			processing_error = $7, chunk_strategy = $8, chunk_size = $9
+		WHERE id = $1 AND version = $2`, args...)
+	if err != nil {
+		return fmt.Errorf("error updating document: %v", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE document_versions SET language = $3, word_count = $4, extractor = $5, status = $6,
+			processing_error = $7, chunk_strategy = $8, chunk_size = $9, chunk_set_id = NULLIF($10, '')
+		WHERE document_id = $1 AND version = $2`, append(args, chunkSetID)...)
+	if err != nil {
+		return fmt.Errorf("error updating document version: %v", err)
+	}
+	if oldSetID.Valid {
+		if err := releaseChunkSets(ctx, tx, []string{oldSetID.String}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *pgDocumentRepo) Trash(ctx context.Context, id, userID string) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE documents SET deleted_at = $3
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL`, id, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgDocumentRepo) Restore(ctx context.Context, id, userID string) (*Document, error) {
+	doc, err := scanDocument(r.db.QueryRowContext(ctx, `
+		UPDATE documents SET deleted_at = NULL
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL
+		RETURNING `+documentColumns, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := loadTags(ctx, r.db, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (r *pgDocumentRepo) ListTrash(ctx context.Context, userID string, p PageRequest) (*Page[Document], error) {
+	pivot, err := trashPivot(p.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	page := &Page[Document]{}
+	err = r.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM documents WHERE user_id = $1 AND deleted_at IS NOT NULL", userID).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	where := "user_id = $1 AND deleted_at IS NOT NULL"
+	args := []interface{}{userID}
+	if pivot != nil {
+		where += " AND (deleted_at, id) < ($2, $3)"
+		args = append(args, *pivot.DeletedAt, pivot.ID)
+	}
+	limit := p.limit()
+	rows, err := r.db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT %s FROM documents WHERE %s ORDER BY deleted_at DESC, id DESC LIMIT %d",
+		documentColumns, where, limit+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	page.Items, err = scanDocuments(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = trashCursor(page.Items[limit-1])
+	}
+	docs := make([]*Document, len(page.Items))
+	for i := range page.Items {
+		docs[i] = &page.Items[i]
+	}
+	if err := loadTags(ctx, r.db, docs...); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (r *pgDocumentRepo) ListExpired(ctx context.Context, deletedBefore time.Time, limit int) ([]Document, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+documentColumns+` FROM documents
+		WHERE deleted_at < $1
+		ORDER BY deleted_at
+		LIMIT $2`, deletedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanDocuments(rows)
+}
+
+type pgBlobQueueRepo struct {
+	db *sql.DB
+}
+
+func (r *pgBlobQueueRepo) Due(ctx context.Context, now time.Time, limit int) ([]PendingBlob, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT path, queued_at, attempts, last_error, next_attempt_at FROM blob_deletions
+		WHERE next_attempt_at <= $1
+		ORDER BY next_attempt_at, path
+		LIMIT $2`, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blobs []PendingBlob
+	for rows.Next() {
+		var b PendingBlob
+		if err := rows.Scan(&b.Path, &b.QueuedAt, &b.Attempts, &b.LastError, &b.NextAttemptAt); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, b)
+	}
+	return blobs, rows.Err()
+}
+
+func (r *pgBlobQueueRepo) Done(ctx context.Context, paths ...string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM blob_deletions WHERE path = ANY($1)", pq.Array(paths))
+	return err
+}
+
+func (r *pgBlobQueueRepo) Failed(ctx context.Context, path, reason string, retryAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE blob_deletions SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+		WHERE path = $1`, path, reason, retryAt)
+	return err
+}
+
+type pgChunkRepo struct {
+	db       *sql.DB
+	language string
+}
+
+func (r *pgChunkRepo) List(ctx context.Context, documentID string, version int) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT c.content FROM document_chunks c
+		JOIN document_versions v ON v.chunk_set_id = c.chunk_set_id
+		WHERE v.document_id = $1 AND v.version = $2
+		ORDER BY c.chunk_index`, documentID, version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []string
+	for rows.Next() {
+		var chunk string
+		if err := rows.Scan(&chunk); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+func (r *pgChunkRepo) ListDetails(ctx context.Context, documentID string, version int, p PageRequest) (*Page[Chunk], error) {
+	after, err := chunkPivot(p.Cursor, documentID, version)
+	if err != nil {
+		return nil, err
+	}
+	page := &Page[Chunk]{Items: []Chunk{}}
+	err = r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM document_chunks c
+		JOIN document_versions v ON v.chunk_set_id = c.chunk_set_id
+		WHERE v.document_id = $1 AND v.version = $2`,
+		documentID, version).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	// The offsets are running totals over the whole version, so they are
+	// computed before the page is cut.
+	limit := p.limit()
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT chunk_index, content, has_embedding, start_offset FROM (
+			SELECT c.chunk_index, c.content, c.embedding IS NOT NULL AS has_embedding,
+				COALESCE(SUM(octet_length(c.content)) OVER (
+					ORDER BY c.chunk_index ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING), 0) AS start_offset
+			FROM document_chunks c
+			JOIN document_versions v ON v.chunk_set_id = c.chunk_set_id
+			WHERE v.document_id = $1 AND v.version = $2
+		) c
+		WHERE chunk_index > $3
+		ORDER BY chunk_index
+		LIMIT $4`, documentID, version, after, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c Chunk
+		if err := rows.Scan(&c.Index, &c.Content, &c.HasEmbedding, &c.Start); err != nil {
+			return nil, err
+		}
+		c.End = c.Start + len(c.Content)
+		page.Items = append(page.Items, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = chunkCursor(documentID, version, page.Items[limit-1])
+	}
+	return page, nil
+}
+
+// searchMatches selects every chunk of the latest version of user $3's
+// documents matching the web search query $2 under text search
+// configuration $1, with its rank. Ranks are normalised into [0, 1).
+const searchMatches = `
+	WITH query AS (SELECT websearch_to_tsquery($1::regconfig, $2) AS q),
+	matches AS (
+		SELECT d.id AS document_id, c.chunk_set_id, c.chunk_index, c.content, query.q,
+		       ts_rank_cd(c.search_vector, query.q, 32)::float8 AS rank
+		FROM document_chunks c
+		JOIN document_versions v ON v.chunk_set_id = c.chunk_set_id
+		JOIN documents d ON d.id = v.document_id AND d.version = v.version
+		CROSS JOIN query
+		WHERE d.user_id = $3 AND d.deleted_at IS NULL
+		  AND c.language = $1::regconfig AND c.search_vector @@ query.q
+	)`
+
+// headlineOptions configures ts_headline to mark matches with the
+// highlight markers and to return up to two short fragments.
+var headlineOptions = fmt.Sprintf(`StartSel=%s, StopSel=%s, MinWords=10, MaxWords=30, MaxFragments=2, FragmentDelimiter=" ... "`,
+	highlightStart, highlightStop)
+
+func (r *pgChunkRepo) Search(ctx context.Context, userID string, q SearchQuery) (*Page[SearchResult], error) {
+	pivot, err := searchPivot(q.Page.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page[SearchResult]{Items: []SearchResult{}}
+	err = r.db.QueryRowContext(ctx, searchMatches+`
+		SELECT COUNT(DISTINCT document_id) FROM matches`, r.language, q.Text, userID).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{r.language, q.Text, userID}
+	where := ""
+	if pivot != nil {
+		where = "WHERE (best.rank, best.document_id) < ($4, $5)"
+		args = append(args, pivot.Rank, pivot.Document.ID)
+	}
+	limit := q.Page.limit()
+	rows, err := r.db.QueryContext(ctx, searchMatches+fmt.Sprintf(`,
+	best AS (
+		SELECT document_id, MAX(rank) AS rank, COUNT(*) AS matches
+		FROM matches GROUP BY document_id
+	)
+	SELECT best.rank, best.matches, d.%s
+	FROM best JOIN documents d ON d.id = best.document_id
+	%s
+	ORDER BY best.rank DESC, best.document_id DESC
+	LIMIT %d`, strings.ReplaceAll(documentColumns, ", ", ", d."), where, limit+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var res SearchResult
+		doc, err := scanDocument(rows, &res.Rank, &res.Matches)
+		if err != nil {
+			return nil, err
+		}
+		res.Document = doc
+		page.Items = append(page.Items, res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = searchCursor(page.Items[limit-1])
+	}
+	if len(page.Items) == 0 {
+		return page, nil
+	}
+	docs := make([]*Document, len(page.Items))
+	for i := range page.Items {
+		docs[i] = &page.Items[i].Document
+	}
+	if err := loadTags(ctx, r.db, docs...); err != nil {
+		return nil, err
+	}
+
+	// Snippets are only generated for the documents on this page, since
+	// ts_headline re-parses the chunk text.
+	ids := make([]string, len(page.Items))
+	byID := make(map[string]*SearchResult, len(page.Items))
+	for i := range page.Items {
+		ids[i] = page.Items[i].Document.ID
+		byID[ids[i]] = &page.Items[i]
+	}
+	// A hit's offset and page come from the chunks before it: their
+	// length, and the page breaks (chr(12)) they contain.
+	rows, err = r.db.QueryContext(ctx, searchMatches+fmt.Sprintf(`
+	SELECT ranked.document_id, ranked.chunk_index, pos.start, pos.breaks, pos.has_breaks,
+	       ranked.rank, ts_headline($1::regconfig, ranked.content, ranked.q, $5)
+	FROM (
+		SELECT *, row_number() OVER (PARTITION BY document_id ORDER BY rank DESC, chunk_index) AS n
+		FROM matches WHERE document_id = ANY($4)
+	) ranked
+	CROSS JOIN LATERAL (
+		SELECT COALESCE(SUM(octet_length(p.content)) FILTER (WHERE p.chunk_index < ranked.chunk_index), 0) AS start,
+		       COALESCE(SUM(length(p.content) - length(replace(p.content, chr(12), '')))
+		           FILTER (WHERE p.chunk_index < ranked.chunk_index), 0) AS breaks,
+		       COALESCE(bool_or(strpos(p.content, chr(12)) > 0), false) AS has_breaks
+		FROM document_chunks p WHERE p.chunk_set_id = ranked.chunk_set_id
+	) pos
+	WHERE ranked.n <= %d
+	ORDER BY ranked.document_id, ranked.rank DESC, ranked.chunk_index`, hitsPerDocument),
+		r.language, q.Text, userID, pq.Array(ids), headlineOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var documentID, headline string
+		var hit SearchHit
+		var breaks int
+		var hasBreaks bool
+		if err := rows.Scan(&documentID, &hit.ChunkIndex, &hit.Start, &breaks, &hasBreaks, &hit.Rank, &headline); err != nil {
+			return nil, err
+		}
+		hit.Snippet = splitSnippet(headline)
+		res := byID[documentID]
+		hit.Page = hitPage(res.Document.PageCount, breaks, hasBreaks)
+		res.Hits = append(res.Hits, hit)
+	}
+	return page, rows.Err()
+}
+
+type pgChatRepo struct {
+	db *sql.DB
+}
+
+const chatColumns = "id, document_id, user_id, message_type, message_content, timestamp, version"
+
+func (r *pgChatRepo) Append(ctx context.Context, msgs ...ChatMessage) error {
+	for _, m := range msgs {
+		id := m.ID
+		if id == "" {
+			id = uuid.New().String()
+		}
+		version := m.Version
+		if version == 0 {
+			version = 1
+		}
+		_, err := r.db.ExecContext(ctx, `
+			INSERT INTO chat_history (id, document_id, user_id, message_type, message_content, version)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			id, m.DocumentID, m.UserID, m.Type, m.Content, version)
+		if err != nil {
+			return fmt.Errorf("error saving %s chat message: %v", m.Type, err)
+		}
+	}
+	return nil
+}
+
+func (r *pgChatRepo) Recent(ctx context.Context, documentID, userID string, version, limit int) ([]ChatMessage, error) {
+	return r.query(ctx, `
+		SELECT `+chatColumns+` FROM (
+			SELECT * FROM chat_history
+			WHERE document_id = $1 AND user_id = $2 AND version = $3
+			ORDER BY timestamp DESC
+			LIMIT $4
+		) recent ORDER BY timestamp`, documentID, userID, version, limit)
+}
+
+func (r *pgChatRepo) List(ctx context.Context, documentID, userID string, q ChatQuery) (*Page[ChatMessage], error) {
+	pivot, err := chatPivot(q.Page.Cursor, q.Desc)
+	if err != nil {
+		return nil, err
+	}
+
+	where := "document_id = $1 AND user_id = $2"
+	args := []interface{}{documentID, userID}
+	if q.Version > 0 {
+		where += " AND version = $3"
+		args = append(args, q.Version)
+	}
+	page := &Page[ChatMessage]{}
+	err = r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM chat_history WHERE "+where, args...).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, cmp := "ASC", ">"
+	if q.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	if pivot != nil {
+		where += fmt.Sprintf(" AND (timestamp, id) %s ($%d, $%d)", cmp, len(args)+1, len(args)+2)
+		args = append(args, pivot.Timestamp, pivot.ID)
+	}
+	limit := q.Page.limit()
+	page.Items, err = r.query(ctx, fmt.Sprintf(`
+		SELECT `+chatColumns+`
+		FROM chat_history
+		WHERE %s
+		ORDER BY timestamp %s, id %s
+		LIMIT %d`, where, dir, dir, limit+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = chatCursor(page.Items[limit-1], q.Desc)
+	}
+	return page, nil
+}
+
+func (r *pgChatRepo) query(ctx context.Context, query string, args ...interface{}) ([]ChatMessage, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	msgs := []ChatMessage{}
+	for rows.Next() {
+		var m ChatMessage
+		if err := rows.Scan(&m.ID, &m.DocumentID, &m.UserID, &m.Type, &m.Content, &m.Timestamp, &m.Version); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// distinct returns ids without repetitions, in first-seen order.
+func distinct(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+type pgFolderRepo struct {
+	db *sql.DB
+}
+
+// folderColumns selects a Folder from folders f.
+const folderColumns = `f.id, f.user_id, COALESCE(f.parent_id, ''), f.name, f.created_at,
+	(SELECT COUNT(*) FROM documents d WHERE d.folder_id = f.id AND d.deleted_at IS NULL)`
+
+func scanFolder(s scanner) (Folder, error) {
+	var f Folder
+	err := s.Scan(&f.ID, &f.UserID, &f.ParentID, &f.Name, &f.CreatedAt, &f.DocumentCount)
+	return f, err
+}
+
+func (r *pgFolderRepo) Create(ctx context.Context, f *Folder) error {
+	if f.ID == "" {
+		f.ID = uuid.New().String()
+	}
+	if f.CreatedAt.IsZero() {
+		f.CreatedAt = time.Now()
+	}
+	res, err := r.db.ExecContext(ctx, `
+		INSERT INTO folders (id, user_id, parent_id, name, created_at)
+		SELECT $1, $2, NULLIF($3, ''), $4, $5
+		WHERE $3 = '' OR EXISTS (SELECT 1 FROM folders WHERE id = $3 AND user_id = $2)`,
+		f.ID, f.UserID, f.ParentID, f.Name, f.CreatedAt)
+	switch {
+	case isUniqueViolation(err):
+		return ErrConflict
+	case isForeignKeyViolation(err):
+		// The parent was deleted meanwhile.
+		return ErrNotFound
+	case err != nil:
+		return fmt.Errorf("error inserting folder: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgFolderRepo) Get(ctx context.Context, id, userID string) (*Folder, error) {
+	f, err := scanFolder(r.db.QueryRowContext(ctx,
+		"SELECT "+folderColumns+" FROM folders f WHERE f.id = $1 AND f.user_id = $2", id, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (r *pgFolderRepo) List(ctx context.Context, userID string) ([]Folder, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT "+folderColumns+" FROM folders f WHERE f.user_id = $1 ORDER BY lower(f.name), f.id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	folders := []Folder{}
+	for rows.Next() {
+		f, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+	return folders, rows.Err()
+}
+
+func (r *pgFolderRepo) Update(ctx context.Context, f *Folder) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking all of the user's folders serialises moves, so that two
+	// concurrent ones cannot each pass the cycle check and together form
+	// a loop.
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM folders WHERE user_id = $1 FOR UPDATE", f.UserID); err != nil {
+		return err
+	}
+	if f.ParentID != "" {
+		var owned, cycle bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM folders WHERE id = $2 AND user_id = $3),
+			       $2 IN (`+folderSubtree("$1")+`)`, f.ID, f.ParentID, f.UserID).Scan(&owned, &cycle)
+		if err != nil {
+			return err
+		}
+		if !owned {
+			return ErrNotFound
+		}
+		if cycle {
+			return ErrFolderCycle
+		}
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE folders SET name = $3, parent_id = NULLIF($4, '')
+		WHERE id = $1 AND user_id = $2`, f.ID, f.UserID, f.Name, f.ParentID)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("error updating folder: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+func (r *pgFolderRepo) Delete(ctx context.Context, id, userID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var parentID string
+	err = tx.QueryRowContext(ctx,
+		"SELECT COALESCE(parent_id, '') FROM folders WHERE id = $1 AND user_id = $2 FOR UPDATE", id, userID).Scan(&parentID)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE documents SET folder_id = NULLIF($2, '')
+		WHERE folder_id IN (`+folderSubtree("$1")+`)`, id, parentID)
+	if err != nil {
+		return fmt.Errorf("error moving documents out of folder: %v", err)
+	}
+	// Subfolders go with it through the cascading foreign key.
+	if _, err := tx.ExecContext(ctx, "DELETE FROM folders WHERE id = $1", id); err != nil {
+		return fmt.Errorf("error deleting folder: %v", err)
+	}
+	return tx.Commit()
+}
+
+func (r *pgFolderRepo) MoveDocuments(ctx context.Context, userID, folderID string, documentIDs []string) error {
+	documentIDs = distinct(documentIDs)
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if folderID != "" {
+		var found int
+		err := tx.QueryRowContext(ctx,
+			"SELECT 1 FROM folders WHERE id = $1 AND user_id = $2 FOR SHARE", folderID, userID).Scan(&found)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+	}
+	res, err := tx.ExecContext(ctx,
+		"UPDATE documents SET folder_id = NULLIF($1, '') WHERE user_id = $2 AND id = ANY($3) AND deleted_at IS NULL",
+		folderID, userID, pq.Array(documentIDs))
+	if err != nil {
+		return fmt.Errorf("error moving documents: %v", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n != int64(len(documentIDs)) {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+type pgTagRepo struct {
+	db *sql.DB
+}
+
+func (r *pgTagRepo) Create(ctx context.Context, t *Tag) error {
+	if t.ID == "" {
+		t.ID = uuid.New().String()
+	}
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now()
+	}
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO tags (id, user_id, name, created_at) VALUES ($1, $2, $3, $4)",
+		t.ID, t.UserID, t.Name, t.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("error inserting tag: %v", err)
+	}
+	return nil
+}
+
+func (r *pgTagRepo) List(ctx context.Context, userID string) ([]Tag, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT t.id, t.user_id, t.name, t.created_at,
+		       (SELECT COUNT(*) FROM document_tags dt JOIN documents d ON d.id = dt.document_id
+		        WHERE dt.tag_id = t.id AND d.deleted_at IS NULL)
+		FROM tags t WHERE t.user_id = $1
+		ORDER BY lower(t.name), t.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []Tag{}
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.CreatedAt, &t.DocumentCount); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+func (r *pgTagRepo) Rename(ctx context.Context, t *Tag) error {
+	err := r.db.QueryRowContext(ctx, `
+		UPDATE tags SET name = $3 WHERE id = $1 AND user_id = $2
+		RETURNING created_at, (SELECT COUNT(*) FROM document_tags dt WHERE dt.tag_id = $1)`,
+		t.ID, t.UserID, t.Name).Scan(&t.CreatedAt, &t.DocumentCount)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *pgTagRepo) Delete(ctx context.Context, id, userID string) error {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM tags WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *pgTagRepo) TagDocuments(ctx context.Context, userID string, documentIDs, add, remove []string) error {
+	documentIDs = distinct(documentIDs)
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id FROM documents WHERE user_id = $1 AND id = ANY($2) AND deleted_at IS NULL FOR SHARE",
+		userID, pq.Array(documentIDs))
+	if err != nil {
+		return err
+	}
+	found := 0
+	for rows.Next() {
+		found++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if found != len(documentIDs) {
+		return ErrNotFound
+	}
+
+	if len(add) > 0 {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO tags (id, user_id, name)
+			SELECT gen_random_uuid()::text, $1, name FROM unnest($2::text[]) AS name
+			ON CONFLICT (user_id, lower(name)) DO NOTHING`, userID, pq.Array(add))
+		if err != nil {
+			return fmt.Errorf("error creating tags: %v", err)
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO document_tags (document_id, tag_id)
+			SELECT d, t.id FROM unnest($2::text[]) AS d
+			CROSS JOIN tags t
+			WHERE t.user_id = $1 AND lower(t.name) = ANY($3)
+			ON CONFLICT DO NOTHING`, userID, pq.Array(documentIDs), pq.Array(lowerAll(add)))
+		if err != nil {
+			return fmt.Errorf("error tagging documents: %v", err)
+		}
+	}
+	if len(remove) > 0 {
+		_, err := tx.ExecContext(ctx, `
+			DELETE FROM document_tags dt USING tags t
+			WHERE dt.tag_id = t.id AND t.user_id = $1
+			  AND dt.document_id = ANY($2) AND lower(t.name) = ANY($3)`,
+			userID, pq.Array(documentIDs), pq.Array(lowerAll(remove)))
+		if err != nil {
+			return fmt.Errorf("error untagging documents: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func lowerAll(names []string) []string {
+	lower := make([]string, len(names))
+	for i, n := range names {
+		lower[i] = strings.ToLower(n)
+	}
+	return lower
+}
+
+type pgQuotaRepo struct {
+	db *sql.DB
+}
+
+func (r *pgQuotaRepo) Limits(ctx context.Context, userID string, defaults QuotaLimits) (QuotaLimits, error) {
+	limits := defaults
+	var dailyCalls, dailyTokens, monthlyCalls, monthlyTokens sql.NullInt64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT daily_calls, daily_tokens, monthly_calls, monthly_tokens
+		FROM llm_quotas WHERE user_id = $1`, userID).
+		Scan(&dailyCalls, &dailyTokens, &monthlyCalls, &monthlyTokens)
+	if err == sql.ErrNoRows {
+		return limits, nil
+	}
+	if err != nil {
+		return limits, err
+	}
+	if dailyCalls.Valid {
+		limits.DailyCalls = dailyCalls.Int64
+	}
+	if dailyTokens.Valid {
+		limits.DailyTokens = dailyTokens.Int64
+	}
+	if monthlyCalls.Valid {
+		limits.MonthlyCalls = monthlyCalls.Int64
+	}
+	if monthlyTokens.Valid {
+		limits.MonthlyTokens = monthlyTokens.Int64
+	}
+	return limits, nil
+}
+
+func (r *pgQuotaRepo) Usage(ctx context.Context, userID string, day, month time.Time) (daily, monthly QuotaUsage, err error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT period, calls, tokens FROM llm_quota_usage
+		WHERE user_id = $1 AND ((period = 'day' AND period_start = $2) OR (period = 'month' AND period_start = $3))`,
+		userID, day, month)
+	if err != nil {
+		return daily, monthly, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var period string
+		var u QuotaUsage
+		if err := rows.Scan(&period, &u.Calls, &u.Tokens); err != nil {
+			return daily, monthly, err
+		}
+		if period == "day" {
+			daily = u
+		} else {
+			monthly = u
+		}
+	}
+	return daily, monthly, rows.Err()
+}
+
+func (r *pgQuotaRepo) Reserve(ctx context.Context, userID string, day, month time.Time, limits QuotaLimits) (daily, monthly QuotaUsage, ok bool, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return daily, monthly, false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO llm_quota_usage (user_id, period, period_start)
+		VALUES ($1, 'day', $2), ($1, 'month', $3)
+		ON CONFLICT (user_id, period, period_start) DO NOTHING`, userID, day, month)
+	if err != nil {
+		return daily, monthly, false, err
+	}
+	// Concurrent reservations queue on the row locks, so each sees the
+	// calls reserved before it. Rows are locked day first, as by Settle.
+	rows, err := tx.QueryContext(ctx, `
+		SELECT period, calls, tokens FROM llm_quota_usage
+		WHERE user_id = $1 AND ((period = 'day' AND period_start = $2) OR (period = 'month' AND period_start = $3))
+		ORDER BY period
+		FOR UPDATE`, userID, day, month)
+	if err != nil {
+		return daily, monthly, false, err
+	}
+	for rows.Next() {
+		var period string
+		var u QuotaUsage
+		if err := rows.Scan(&period, &u.Calls, &u.Tokens); err != nil {
+			rows.Close()
+			return daily, monthly, false, err
+		}
+		if period == "day" {
+			daily = u
+		} else {
+			monthly = u
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return daily, monthly, false, err
+	}
+	if !limits.allows(daily, monthly) {
+		return daily, monthly, false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE llm_quota_usage SET calls = calls + 1
+		WHERE user_id = $1 AND ((period = 'day' AND period_start = $2) OR (period = 'month' AND period_start = $3))`,
+		userID, day, month)
+	if err != nil {
+		return daily, monthly, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return daily, monthly, false, err
+	}
+	daily.Calls++
+	monthly.Calls++
+	return daily, monthly, true, nil
+}
+
+func (r *pgQuotaRepo) Settle(ctx context.Context, userID string, day, month time.Time, calls, tokens int) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE llm_quota_usage SET calls = GREATEST(calls + $4, 0), tokens = tokens + $5
+		WHERE user_id = $1 AND ((period = 'day' AND period_start = $2) OR (period = 'month' AND period_start = $3))`,
+		userID, day, month, calls, tokens)
+	return err
+}
+
+type pgUsageRepo struct {
+	db *sql.DB
+}
+
+func (r *pgUsageRepo) Record(ctx context.Context, u LLMUsage) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO llm_usage (id, user_id, document_id, feature, provider, model,
+			prompt_tokens, completion_tokens, latency_ms, status, estimated_cost)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9, $10, $11)`,
+		uuid.New().String(), u.UserID, u.DocumentID, u.Feature, u.Provider, u.Model,
+		u.PromptTokens, u.CompletionTokens, u.Latency.Milliseconds(), u.Status, u.EstimatedCost)
+	return err
+}
+
+func (r *pgUsageRepo) Report(ctx context.Context, from, to time.Time, userID string) ([]UsageReportRow, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT to_char(date_trunc('day', created_at), 'YYYY-MM-DD') AS day, user_id, model,
+			COUNT(*), COUNT(*) FILTER (WHERE status <> 'ok'),
+			COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0),
+			COALESCE(SUM(estimated_cost), 0), COALESCE(AVG(latency_ms), 0)
+		FROM llm_usage
+		WHERE created_at >= $1 AND created_at < $2 AND ($3 = '' OR user_id = $3)
+		GROUP BY day, user_id, model
+		ORDER BY day DESC, user_id, model`, from, to, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := []UsageReportRow{}
+	for rows.Next() {
+		var row UsageReportRow
+		if err := rows.Scan(&row.Day, &row.UserID, &row.Model, &row.Calls, &row.Errors,
+			&row.PromptTokens, &row.CompletionTokens, &row.EstimatedCost, &row.AvgLatencyMs); err != nil {
+			return nil, err
+		}
+		row.TotalTokens = row.PromptTokens + row.CompletionTokens
+		report = append(report, row)
+	}
+	return report, rows.Err()
+}
+
+type pgAuditRepo struct {
+	db *sql.DB
+}
+
+func (r *pgAuditRepo) Append(ctx context.Context, ev AuditEvent) error {
+	details, err := json.Marshal(ev.Details)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO audit_events (id, action, outcome, status, actor_id, target_type, target_id,
+			ip, user_agent, method, path, details, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), $8, $9, $10, $11, $12, $13)`,
+		ev.ID, ev.Action, ev.Outcome, ev.Status, ev.ActorID, ev.TargetType,
+		ev.TargetID, ev.IP, ev.UserAgent, ev.Method, ev.Path, details, ev.CreatedAt)
+	return err
+}
+
+// auditWhere builds the WHERE clause selecting the events matching f.
+func auditWhere(f AuditFilter) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	for _, c := range []struct{ column, value string }{
+		{"action", f.Action},
+		{"actor_id", f.ActorID},
+		{"target_type", f.TargetType},
+		{"target_id", f.TargetID},
+		{"outcome", f.Outcome},
+		{"ip", f.IP},
+	} {
+		if c.value != "" {
+			add(c.column+" = $%d", c.value)
+		}
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+const auditColumns = `id, action, outcome, status, COALESCE(actor_id, ''), COALESCE(target_type, ''),
+	COALESCE(target_id, ''), ip, user_agent, method, path, details, created_at`
+
+func scanAuditEvent(rows *sql.Rows) (AuditEvent, error) {
+	var ev AuditEvent
+	var details []byte
+	err := rows.Scan(&ev.ID, &ev.Action, &ev.Outcome, &ev.Status, &ev.ActorID, &ev.TargetType,
+		&ev.TargetID, &ev.IP, &ev.UserAgent, &ev.Method, &ev.Path, &details, &ev.CreatedAt)
+	if err != nil {
+		return ev, err
+	}
+	if len(details) > 0 {
+		json.Unmarshal(details, &ev.Details)
+	}
+	return ev, nil
+}
+
+func (r *pgAuditRepo) List(ctx context.Context, f AuditFilter, limit, offset int) ([]AuditEvent, int, error) {
+	where, args := auditWhere(f)
+	var total int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_events "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, limit, offset)
+	rows, err := r.db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT %s FROM audit_events %s ORDER BY created_at DESC, id LIMIT $%d OFFSET $%d",
+		auditColumns, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		ev, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, ev)
+	}
+	return events, total, rows.Err()
+}
+
+func (r *pgAuditRepo) Export(ctx context.Context, f AuditFilter, fn func(AuditEvent) error) error {
+	where, args := auditWhere(f)
+	rows, err := r.db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT %s FROM audit_events %s ORDER BY created_at, id", auditColumns, where), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ev, err := scanAuditEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,456 @@
+// Package repository hides persistence behind small interfaces so that
+// handlers can run against Postgres in production and an in-memory store
+// in tests.
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the requested row does not exist or is
+	// not visible to the requesting user.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a write violates a uniqueness rule.
+	ErrConflict = errors.New("conflict")
+	// ErrFolderCycle is returned when a folder would be moved into itself
+	// or one of its subfolders.
+	ErrFolderCycle = errors.New("folder cannot be moved into itself")
+)
+
+type User struct {
+	ID        string
+	Email     string
+	CreatedAt time.Time
+}
+
+// Document processing states.
+const (
+	StatusProcessing = "processing"
+	StatusReady      = "ready"
+	StatusFailed     = "failed"
+)
+
+type Document struct {
+	ID       string `json:"id"`
+	UserID   string `json:"userId"`
+	FileName string `json:"fileName"`
+	// StoragePath locates the original in the private bucket. It is not a
+	// URL: the file is served through the download endpoint.
+	StoragePath string    `json:"storageUrl"`
+	UploadedAt  time.Time `json:"uploadedAt"`
+	SizeBytes   int64     `json:"size"`
+	// MimeType is sniffed from the content, not taken from the name.
+	MimeType string `json:"mimeType"`
+	SHA256   string `json:"sha256"`
+	// PageCount is 0 for formats without pages.
+	PageCount int `json:"pageCount"`
+	// Language is an ISO 639-1 code, empty when it could not be detected.
+	Language  string `json:"language"`
+	WordCount int    `json:"wordCount"`
+	// Extractor names the method that produced the text.
+	Extractor string `json:"extractor"`
+	// ChunkStrategy and ChunkSize say how the text was split into chunks.
+	ChunkStrategy string `json:"chunkStrategy"`
+	ChunkSize     int    `json:"chunkSize"`
+	Status        string `json:"status"`
+	// ProcessingError says why a failed document could not be processed.
+	ProcessingError string `json:"processingError,omitempty"`
+	// Version is the number of the latest version, which the fields
+	// above describe. Versions are numbered from 1 without gaps.
+	Version int `json:"version"`
+	// FolderID is empty for a document outside any folder.
+	FolderID string `json:"folderId,omitempty"`
+	// Tags are the names of the tags applied to the document, sorted.
+	Tags []string `json:"tags,omitempty"`
+	// SuggestedTags were proposed from the content when it was ingested.
+	// They are not applied until the user tags the document with them.
+	SuggestedTags []string `json:"suggestedTags,omitempty"`
+	// Description and Metadata are set by the user and kept across
+	// versions. Metadata holds free-form key-value pairs.
+	Description string            `json:"description,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	// DeletedAt is set while the document is in the trash.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// DocumentVersion is one upload of a document.
+type DocumentVersion struct {
+	DocumentID      string    `json:"documentId"`
+	Version         int       `json:"version"`
+	FileName        string    `json:"fileName"`
+	StoragePath     string    `json:"storageUrl"`
+	UploadedAt      time.Time `json:"uploadedAt"`
+	SizeBytes       int64     `json:"size"`
+	MimeType        string    `json:"mimeType"`
+	SHA256          string    `json:"sha256"`
+	PageCount       int       `json:"pageCount"`
+	Language        string    `json:"language"`
+	WordCount       int       `json:"wordCount"`
+	Extractor       string    `json:"extractor"`
+	ChunkStrategy   string    `json:"chunkStrategy"`
+	ChunkSize       int       `json:"chunkSize"`
+	Status          string    `json:"status"`
+	ProcessingError string    `json:"processingError,omitempty"`
+}
+
+// CurrentVersion returns the document's latest version.
+func (d *Document) CurrentVersion() DocumentVersion {
+	return DocumentVersion{
+		DocumentID:      d.ID,
+		Version:         d.Version,
+		FileName:        d.FileName,
+		StoragePath:     d.StoragePath,
+		UploadedAt:      d.UploadedAt,
+		SizeBytes:       d.SizeBytes,
+		MimeType:        d.MimeType,
+		SHA256:          d.SHA256,
+		PageCount:       d.PageCount,
+		Language:        d.Language,
+		WordCount:       d.WordCount,
+		Extractor:       d.Extractor,
+		ChunkStrategy:   d.ChunkStrategy,
+		ChunkSize:       d.ChunkSize,
+		Status:          d.Status,
+		ProcessingError: d.ProcessingError,
+	}
+}
+
+// Folder groups documents. Folders nest: ParentID is empty for a
+// top-level folder.
+type Folder struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	ParentID  string    `json:"parentId,omitempty"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	// DocumentCount counts the documents directly in the folder.
+	DocumentCount int `json:"documentCount"`
+}
+
+// Tag is a free-form label. Names are unique per user, ignoring case.
+type Tag struct {
+	ID            string    `json:"id"`
+	UserID        string    `json:"userId"`
+	Name          string    `json:"name"`
+	CreatedAt     time.Time `json:"createdAt"`
+	DocumentCount int       `json:"documentCount"`
+}
+
+type ChatMessage struct {
+	ID         string
+	DocumentID string
+	UserID     string
+	Type       string // "user" or "ai"
+	Content    string
+	Timestamp  time.Time
+	// Version is the document version the message was about.
+	Version int
+}
+
+type UserRepo interface {
+	Get(ctx context.Context, id string) (*User, error)
+	// Upsert creates the user or updates their email. It returns
+	// ErrConflict if the email belongs to another user.
+	Upsert(ctx context.Context, id, email string) error
+	UpdateEmail(ctx context.Context, id, email string) error
+}
+
+type DocumentRepo interface {
+	// Create stores the document as version 1 with its content chunks
+	// atomically: either both are saved or neither is.
+	Create(ctx context.Context, doc *Document, chunks []string) error
+	// AddVersion stores doc, whose Version must be one past the stored
+	// latest version, as the document's new latest version with its
+	// chunks. Earlier versions keep theirs. It returns ErrConflict if
+	// another version was added first.
+	AddVersion(ctx context.Context, doc *Document, chunks []string) error
+	// ListVersions returns every version of the document, oldest first.
+	ListVersions(ctx context.Context, id, userID string) ([]DocumentVersion, error)
+	// Get returns the document only if it belongs to userID and is not in
+	// the trash. Other methods ignore documents in the trash too, unless
+	// they say otherwise.
+	Get(ctx context.Context, id, userID string) (*Document, error)
+	// List returns one page of the user's documents matching q.
+	List(ctx context.Context, userID string, q DocumentQuery) (*Page[Document], error)
+	// CreateCopy stores doc as a duplicate of document sourceID: it shares
+	// the source's blob (doc.StoragePath must be the source's) and gets a
+	// copy of its chunks. It returns ErrNotFound if the source no longer
+	// exists.
+	CreateCopy(ctx context.Context, doc *Document, sourceID string) error
+	// FindByHash returns the user's earliest ready document whose content
+	// has the given SHA-256 hash, or ErrNotFound.
+	FindByHash(ctx context.Context, userID, sha256 string) (*Document, error)
+	// Delete permanently removes the document, in the trash or not, with
+	// all its versions, chunks and chat history. The storage paths of its
+	// blobs that no other document references any more are queued for
+	// removal in the BlobQueueRepo and returned.
+	Delete(ctx context.Context, id, userID string) (orphaned []string, err error)
+	// Purge is Delete for a document in the trash; it returns ErrNotFound
+	// for any other.
+	Purge(ctx context.Context, id, userID string) (orphaned []string, err error)
+	// Trash moves the document to the trash.
+	Trash(ctx context.Context, id, userID string) error
+	// Restore takes the document out of the trash and returns it. It
+	// returns ErrNotFound if the document is not in the trash.
+	Restore(ctx context.Context, id, userID string) (*Document, error)
+	// ListTrash returns one page of the user's documents in the trash,
+	// most recently deleted first.
+	ListTrash(ctx context.Context, userID string, p PageRequest) (*Page[Document], error)
+	// ListExpired returns up to limit documents of any user that were put
+	// in the trash before the cutoff, longest there first.
+	ListExpired(ctx context.Context, deletedBefore time.Time, limit int) ([]Document, error)
+	// ReferencedPaths reports which of the given storage paths belong to a
+	// version of some document.
+	ReferencedPaths(ctx context.Context, paths []string) (map[string]bool, error)
+	// ListChunkless returns documents uploaded before the cutoff that have
+	// no chunks, other than those that failed processing.
+	ListChunkless(ctx context.Context, uploadedBefore time.Time) ([]Document, error)
+	// SetSuggestedTags replaces the tags suggested for the document.
+	SetSuggestedTags(ctx context.Context, id string, tags []string) error
+	// Update runs change on the user's document while holding it locked,
+	// so that concurrent updates do not lose each other's changes, and
+	// then saves the file name, description and metadata change left on
+	// it. An error from change aborts the update and is returned as is.
+	Update(ctx context.Context, id, userID string, change func(*Document) error) (*Document, error)
+	// Reprocess replaces the chunks of doc's latest version, doc.Version,
+	// and records on it the extractor, language, word count, chunking and
+	// status from doc, all at once. It returns ErrConflict if another
+	// version was added meanwhile.
+	Reprocess(ctx context.Context, doc *Document, chunks []string) error
+}
+
+type FolderRepo interface {
+	// Create stores a new folder. It returns ErrNotFound if the parent is
+	// not one of the user's folders and ErrConflict if the parent already
+	// holds a folder of that name.
+	Create(ctx context.Context, f *Folder) error
+	// Get returns the folder only if it belongs to userID.
+	Get(ctx context.Context, id, userID string) (*Folder, error)
+	// List returns every folder of the user, sorted by name.
+	List(ctx context.Context, userID string) ([]Folder, error)
+	// Update renames f and moves it under f.ParentID. Besides the errors
+	// of Create it returns ErrFolderCycle if the new parent is f itself or
+	// lies inside it.
+	Update(ctx context.Context, f *Folder) error
+	// Delete removes the folder with its subfolders. Documents in any of
+	// them move to the folder's parent.
+	Delete(ctx context.Context, id, userID string) error
+	// MoveDocuments puts the documents into the folder, or outside any
+	// folder if folderID is empty. It returns ErrNotFound, moving nothing,
+	// if the folder or one of the documents does not belong to the user.
+	MoveDocuments(ctx context.Context, userID, folderID string, documentIDs []string) error
+}
+
+type TagRepo interface {
+	// Create stores a new tag. It returns ErrConflict if the user already
+	// has a tag of that name.
+	Create(ctx context.Context, t *Tag) error
+	// List returns every tag of the user, sorted by name.
+	List(ctx context.Context, userID string) ([]Tag, error)
+	// Rename changes the tag's name, returning ErrConflict if the user
+	// already has a tag of the new name.
+	Rename(ctx context.Context, t *Tag) error
+	// Delete removes the tag from every document and then the tag itself.
+	Delete(ctx context.Context, id, userID string) error
+	// TagDocuments applies the named tags to the documents, creating tags
+	// the user does not have yet, and removes the tags named in remove.
+	// It returns ErrNotFound, changing nothing, if one of the documents
+	// does not belong to the user.
+	TagDocuments(ctx context.Context, userID string, documentIDs, add, remove []string) error
+}
+
+// PendingBlob is a blob queued for removal from storage.
+type PendingBlob struct {
+	Path     string
+	QueuedAt time.Time
+	// Attempts counts the failed removals so far; LastError is the reason
+	// the latest one failed.
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+}
+
+// BlobQueueRepo holds the blobs waiting to be removed from storage.
+type BlobQueueRepo interface {
+	// Due returns up to limit blobs whose next attempt is due at now,
+	// earliest due first.
+	Due(ctx context.Context, now time.Time, limit int) ([]PendingBlob, error)
+	// Done takes the paths off the queue.
+	Done(ctx context.Context, paths ...string) error
+	// Failed records a failed attempt to remove the blob at path and when
+	// to try again.
+	Failed(ctx context.Context, path, reason string, retryAt time.Time) error
+}
+
+// Chunk is a stored chunk of a version's extracted text. The chunks
+// partition the text, so Start and End, its byte offsets in the text,
+// follow from the lengths of the chunks before it.
+type Chunk struct {
+	Index        int    `json:"index"`
+	Start        int    `json:"start"`
+	End          int    `json:"end"`
+	Content      string `json:"content"`
+	HasEmbedding bool   `json:"hasEmbedding"`
+}
+
+type ChunkRepo interface {
+	// List returns the content of every chunk of one version in order.
+	List(ctx context.Context, documentID string, version int) ([]string, error)
+	// ListDetails returns one page of the chunks of one version in order,
+	// with their offsets.
+	ListDetails(ctx context.Context, documentID string, version int, p PageRequest) (*Page[Chunk], error)
+	// Search returns one page of the user's documents whose chunks match
+	// q, best match first.
+	Search(ctx context.Context, userID string, q SearchQuery) (*Page[SearchResult], error)
+}
+
+type ChatRepo interface {
+	Append(ctx context.Context, msgs ...ChatMessage) error
+	// Recent returns up to limit of the latest messages about one version
+	// of the document, oldest first.
+	Recent(ctx context.Context, documentID, userID string, version, limit int) ([]ChatMessage, error)
+	// List returns one page of the conversation, oldest first unless
+	// q.Desc is set.
+	List(ctx context.Context, documentID, userID string, q ChatQuery) (*Page[ChatMessage], error)
+}
+
+type ChatQuery struct {
+	// Version restricts the listing to one document version; zero means
+	// every version.
+	Version int
+	Desc    bool
+	Page    PageRequest
+}
+
+// QuotaLimits caps LLM usage per period. A zero value means unlimited.
+type QuotaLimits struct {
+	DailyCalls    int64 `json:"dailyCalls"`
+	DailyTokens   int64 `json:"dailyTokens"`
+	MonthlyCalls  int64 `json:"monthlyCalls"`
+	MonthlyTokens int64 `json:"monthlyTokens"`
+}
+
+// QuotaUsage is what a user was charged in one period.
+type QuotaUsage struct {
+	Calls  int64
+	Tokens int64
+}
+
+// allows reports whether one more call fits in l after the given usage of
+// the day and month.
+func (l QuotaLimits) allows(daily, monthly QuotaUsage) bool {
+	under := func(limit, used int64) bool { return limit == 0 || used < limit }
+	return under(l.DailyCalls, daily.Calls) && under(l.DailyTokens, daily.Tokens) &&
+		under(l.MonthlyCalls, monthly.Calls) && under(l.MonthlyTokens, monthly.Tokens)
+}
+
+// QuotaRepo keeps per-user quota overrides and the usage charged against
+// quotas in each day and month, identified by their first instant in UTC.
+type QuotaRepo interface {
+	// Limits returns defaults with the user's overrides applied.
+	Limits(ctx context.Context, userID string, defaults QuotaLimits) (QuotaLimits, error)
+	// Usage returns the user's usage in the given day and month.
+	Usage(ctx context.Context, userID string, day, month time.Time) (daily, monthly QuotaUsage, err error)
+	// Reserve charges one call to the user's day and month unless either
+	// has reached limits, atomically with respect to other reservations.
+	// It returns the usage of both, including the call if ok.
+	Reserve(ctx context.Context, userID string, day, month time.Time, limits QuotaLimits) (daily, monthly QuotaUsage, ok bool, err error)
+	// Settle adds calls, which may be negative to give a reserved call
+	// back, and tokens to the user's day and month.
+	Settle(ctx context.Context, userID string, day, month time.Time, calls, tokens int) error
+}
+
+// LLMUsage is a single metered LLM request.
+type LLMUsage struct {
+	UserID string
+	// DocumentID is empty for calls not about one document.
+	DocumentID       string
+	Feature          string
+	Provider         string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Latency          time.Duration
+	Status           string
+	EstimatedCost    float64
+}
+
+// UsageReportRow sums the LLM usage of one user with one model in a day.
+type UsageReportRow struct {
+	Day              string  `json:"day"`
+	UserID           string  `json:"userId"`
+	Model            string  `json:"model"`
+	Calls            int64   `json:"calls"`
+	Errors           int64   `json:"errors"`
+	PromptTokens     int64   `json:"promptTokens"`
+	CompletionTokens int64   `json:"completionTokens"`
+	TotalTokens      int64   `json:"totalTokens"`
+	EstimatedCost    float64 `json:"estimatedCost"`
+	AvgLatencyMs     float64 `json:"avgLatencyMs"`
+}
+
+type UsageRepo interface {
+	Record(ctx context.Context, u LLMUsage) error
+	// Report sums the usage recorded from from until to by day, user and
+	// model, latest day first. An empty userID reports every user.
+	Report(ctx context.Context, from, to time.Time, userID string) ([]UsageReportRow, error)
+}
+
+type AuditEvent struct {
+	ID         string            `json:"id"`
+	Action     string            `json:"action"`
+	Outcome    string            `json:"outcome"`
+	Status     int               `json:"status"`
+	ActorID    string            `json:"actorId,omitempty"`
+	TargetType string            `json:"targetType,omitempty"`
+	TargetID   string            `json:"targetId,omitempty"`
+	IP         string            `json:"ip"`
+	UserAgent  string            `json:"userAgent"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Details    map[string]string `json:"details,omitempty"`
+	CreatedAt  time.Time         `json:"createdAt"`
+}
+
+// AuditFilter narrows an audit event listing. Zero fields match
+// everything; To is exclusive.
+type AuditFilter struct {
+	Action     string
+	ActorID    string
+	TargetType string
+	TargetID   string
+	Outcome    string
+	IP         string
+	From       time.Time
+	To         time.Time
+}
+
+// AuditRepo stores audit events. Events are never changed or removed.
+type AuditRepo interface {
+	Append(ctx context.Context, ev AuditEvent) error
+	// List returns up to limit of the events matching f, newest first,
+	// after skipping offset of them, and how many match in all.
+	List(ctx context.Context, f AuditFilter, limit, offset int) (events []AuditEvent, total int, err error)
+	// Export calls fn with every event matching f, oldest first, until fn
+	// returns an error, which Export then returns.
+	Export(ctx context.Context, f AuditFilter, fn func(AuditEvent) error) error
+}
+
+// Repos bundles one implementation of every repository.
+type Repos struct {
+	Users     UserRepo
+	Documents DocumentRepo
+	Chunks    ChunkRepo
+	Chats     ChatRepo
+	Folders   FolderRepo
+	Tags      TagRepo
+	BlobQueue BlobQueueRepo
+	Quotas    QuotaRepo
+	Usage     UsageRepo
+	Audit     AuditRepo
+	// Ping checks that the underlying store is reachable.
+	Ping func(ctx context.Context) error
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"strconv"
+	"strings"
+)
+
+// DefaultSearchLanguage is the Postgres text search configuration chunks
+// are indexed and searched with unless configured otherwise.
+const DefaultSearchLanguage = "english"
+
+// SearchQuery is a full-text query over every document of a user. Text
+// uses web search syntax: words must all match, "quoted phrases" match in
+// order, "or" separates alternatives and a leading - excludes a word.
+type SearchQuery struct {
+	Text string
+	Page PageRequest
+}
+
+// SnippetPart is a run of snippet text. Match marks the words that
+// matched the query, for highlighting.
+type SnippetPart struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+// SearchHit is one matching chunk. Start is the chunk's byte offset in
+// the version's full text, as for Chunk, and Page the page it starts on,
+// counted from 1; Page is 0 when the pages are not known.
+type SearchHit struct {
+	ChunkIndex int           `json:"chunkIndex"`
+	Start      int           `json:"start"`
+	Page       int           `json:"page,omitempty"`
+	Rank       float64       `json:"rank"`
+	Snippet    []SnippetPart `json:"snippet"`
+}
+
+// SearchResult is one matching document with its best chunks, best first.
+// Rank is that of the best chunk; Matches counts every matching chunk.
+type SearchResult struct {
+	Document Document    `json:"document"`
+	Rank     float64     `json:"rank"`
+	Matches  int         `json:"matches"`
+	Hits     []SearchHit `json:"hits"`
+}
+
+// pageBreak ends each page of text extracted from a paged format.
+const pageBreak = "\f"
+
+// hitPage returns the page of a document with pageCount pages that a chunk
+// preceded by breaks page breaks starts on. hasBreaks tells whether the
+// version's text has any; text extracted before page breaks were recorded
+// has none, and its pages are then unknown, as are those of formats
+// without pages.
+func hitPage(pageCount, breaks int, hasBreaks bool) int {
+	if pageCount == 0 || pageCount > 1 && !hasBreaks {
+		return 0
+	}
+	return breaks + 1
+}
+
+// hitsPerDocument caps the chunks returned for each matching document.
+const hitsPerDocument = 3
+
+// Markers placed around matched words by the snippet generator and split
+// out by splitSnippet. They are control characters that extracted text
+// never contains.
+const (
+	highlightStart = "\x02"
+	highlightStop  = "\x03"
+)
+
+// splitSnippet turns text carrying highlight markers into parts.
+func splitSnippet(s string) []SnippetPart {
+	parts := []SnippetPart{}
+	for s != "" {
+		i := strings.Index(s, highlightStart)
+		if i < 0 {
+			parts = append(parts, SnippetPart{Text: s})
+			break
+		}
+		if i > 0 {
+			parts = append(parts, SnippetPart{Text: s[:i]})
+		}
+		s = s[i+len(highlightStart):]
+		j := strings.Index(s, highlightStop)
+		if j < 0 {
+			j = len(s)
+		}
+		if j > 0 {
+			parts = append(parts, SnippetPart{Text: s[:j], Match: true})
+		}
+		s = strings.TrimPrefix(s[j:], highlightStop)
+	}
+	return parts
+}
+
+// searchCursor returns the cursor positioned just after res. Results are
+// always ordered by descending rank.
+func searchCursor(res SearchResult) string {
+	return cursor{Sort: "rank", Desc: true, Key: strconv.FormatFloat(res.Rank, 'g', -1, 64), ID: res.Document.ID}.encode()
+}
+
+// searchPivot returns the rank and document ID of a search cursor.
+func searchPivot(s string) (*SearchResult, error) {
+	c, err := decodeCursor(s, "rank", true)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	rank, err := strconv.ParseFloat(c.Key, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &SearchResult{Rank: rank, Document: Document{ID: c.ID}}, nil
+}
@@ -1,29 +1,441 @@
 package utils
 
 import (
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
-// Automatically load environment variables when this package is imported
-func init() {
-	err := godotenv.Load(".env")
-	if err != nil {
-		log.Println("⚠️ No .env file found, using system environment variables")
+// Secret is a string that is redacted whenever it is printed.
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
 	}
+	return "[REDACTED]"
+}
+
+func (s Secret) GoString() string { return `"` + s.String() + `"` }
+
+func (s Secret) MarshalJSON() ([]byte, error) { return []byte(`"` + s.String() + `"`), nil }
+
+// Value returns the secret itself.
+func (s Secret) Value() string { return string(s) }
+
+// Config is the complete server configuration. Every leaf field is named
+// by its env tag, which is also its key in the config file and, lowercased
+// with dashes, its command-line flag.
+type Config struct {
+	Server     ServerConfig
+	Database   DatabaseConfig
+	Firebase   FirebaseConfig
+	Storage    StorageConfig
+	LLM        LLMConfig
+	Extraction ExtractionConfig
+	Search     SearchConfig
+	Auth       AuthConfig
+	RateLimit  RateLimitConfig
+	Quota      QuotaConfig
+	CORS       CORSConfig
+	Reconcile  ReconcileConfig
+	Trash      TrashConfig
+	Logging    LogConfig
+	Tracing    TracingConfig
+}
+
+type ServerConfig struct {
+	Port              string        `env:"PORT" default:"8080"`
+	ReadHeaderTimeout time.Duration `env:"SERVER_READ_HEADER_TIMEOUT" default:"10s"`
+	ReadTimeout       time.Duration `env:"SERVER_READ_TIMEOUT" default:"60s"`
+	// WriteTimeout must outlast the slowest LLM call (60s) plus retrieval.
+	WriteTimeout    time.Duration `env:"SERVER_WRITE_TIMEOUT" default:"120s"`
+	IdleTimeout     time.Duration `env:"SERVER_IDLE_TIMEOUT" default:"120s"`
+	ShutdownTimeout time.Duration `env:"SERVER_SHUTDOWN_TIMEOUT" default:"30s"`
+}
+
+type DatabaseConfig struct {
+	URL              Secret `env:"DATABASE_URL" required:"true"`
+	MigrateOnStartup bool   `env:"MIGRATE_ON_STARTUP"`
+}
+
+type FirebaseConfig struct {
+	ServiceAccount Secret `env:"FIREBASE_SERVICE_ACCOUNT" required:"true"`
+}
+
+type StorageConfig struct {
+	SupabaseURL    string `env:"SUPABASE_URL" required:"true"`
+	ServiceRoleKey Secret `env:"SUPABASE_SERVICE_ROLE_KEY" required:"true"`
+	Bucket         string `env:"SUPABASE_BUCKET" required:"true"`
+	// MakeBucketPrivate turns off public access to the bucket at startup
+	// if it is on; originals are only served through the API.
+	MakeBucketPrivate bool `env:"SUPABASE_BUCKET_MAKE_PRIVATE" default:"true"`
+	// SignedURLTTL is how long a signed download URL stays valid unless
+	// the caller asks for less; SignedURLMaxTTL bounds what it may ask for.
+	SignedURLTTL    time.Duration `env:"STORAGE_SIGNED_URL_TTL" default:"5m"`
+	SignedURLMaxTTL time.Duration `env:"STORAGE_SIGNED_URL_MAX_TTL" default:"1h"`
+	// MaxUploadBytes caps the size of an upload request, file included.
+	MaxUploadBytes int64 `env:"STORAGE_MAX_UPLOAD_BYTES" default:"10485760"`
+}
+
+type LLMConfig struct {
+	HFAPIToken          Secret  `env:"HF_API_TOKEN" required:"true"`
+	HFAPIURL            string  `env:"HF_API_URL" default:"https://api-inference.huggingface.co"`
+	PromptCostPer1K     float64 `env:"LLM_PROMPT_COST_PER_1K"`
+	CompletionCostPer1K float64 `env:"LLM_COMPLETION_COST_PER_1K"`
+	// SuggestTags has the model propose tags for every new upload.
+	SuggestTags bool `env:"LLM_SUGGEST_TAGS" default:"true"`
+}
+
+type ExtractionConfig struct {
+	OCRSpaceAPIKey Secret `env:"OCR_SPACE_API_KEY"`
+}
+
+type SearchConfig struct {
+	// Language is the Postgres text search configuration used to index
+	// and query chunk content. Chunks keep the configuration they were
+	// indexed with, so changing it only affects new uploads.
+	Language string `env:"SEARCH_LANGUAGE" default:"english"`
 }
 
-// Safe getter for env variables with fallback default
-func GetEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+type AuthConfig struct {
+	JITProvisioning      bool     `env:"AUTH_JIT_PROVISIONING"`
+	RequireVerifiedEmail bool     `env:"AUTH_REQUIRE_VERIFIED_EMAIL"`
+	AdminUserIDs         []string `env:"ADMIN_USER_IDS"`
+}
+
+type RateLimitConfig struct {
+	APIRPS       float64 `env:"RATE_LIMIT_API_RPS" default:"10"`
+	APIBurst     int     `env:"RATE_LIMIT_API_BURST" default:"20"`
+	LLMPerMinute float64 `env:"RATE_LIMIT_LLM_PER_MINUTE" default:"6"`
+	LLMBurst     int     `env:"RATE_LIMIT_LLM_BURST" default:"3"`
+}
+
+type QuotaConfig struct {
+	DailyCalls    int64 `env:"LLM_DAILY_CALL_LIMIT"`
+	DailyTokens   int64 `env:"LLM_DAILY_TOKEN_LIMIT"`
+	MonthlyCalls  int64 `env:"LLM_MONTHLY_CALL_LIMIT"`
+	MonthlyTokens int64 `env:"LLM_MONTHLY_TOKEN_LIMIT"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string `env:"CORS_ALLOWED_ORIGINS" default:"http://localhost:3000"`
+	// AllowCredentials cannot be combined with the "*" origin.
+	AllowCredentials bool `env:"CORS_ALLOW_CREDENTIALS" default:"true"`
+	MaxAge           int  `env:"CORS_MAX_AGE" default:"600"`
+	HSTSMaxAge       int  `env:"HSTS_MAX_AGE"`
+}
+
+type ReconcileConfig struct {
+	// Interval between reconciliation passes; zero disables the reconciler.
+	Interval    time.Duration `env:"RECONCILE_INTERVAL" default:"1h"`
+	GracePeriod time.Duration `env:"RECONCILE_GRACE_PERIOD" default:"1h"`
+}
+
+type TrashConfig struct {
+	// Retention is how long deleted documents stay in the trash before
+	// they are purged for good.
+	Retention time.Duration `env:"TRASH_RETENTION" default:"720h"`
+	// PurgeInterval between purges of expired documents and retries of
+	// failed blob removals; zero disables the purger.
+	PurgeInterval time.Duration `env:"TRASH_PURGE_INTERVAL" default:"10m"`
+}
+
+type LogConfig struct {
+	// Level is one of debug, info, warn or error.
+	Level string `env:"LOG_LEVEL" default:"info"`
+	// Format is json or text.
+	Format string `env:"LOG_FORMAT" default:"json"`
+}
+
+type TracingConfig struct {
+	// Exporter is none, stdout or otlp (OTLP over HTTP).
+	Exporter     string  `env:"OTEL_TRACES_EXPORTER" default:"none"`
+	OTLPEndpoint string  `env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	ServiceName  string  `env:"OTEL_SERVICE_NAME" default:"strategic-insight-analyst"`
+	SampleRatio  float64 `env:"OTEL_TRACES_SAMPLE_RATIO" default:"1"`
+}
+
+// LoadConfig builds the configuration from, in increasing precedence:
+// defaults, the optional config file (-config or CONFIG_FILE, YAML or
+// TOML), the environment (including .env) and command-line flags. All
+// problems are reported together. The returned args are the positional
+// arguments left after the flags.
+func LoadConfig(args []string) (*Config, []string, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("loading .env: %v", err)
 	}
-	return value
+
+	cfg := &Config{}
+	fields := configFields(reflect.ValueOf(cfg).Elem())
+
+	fs := flag.NewFlagSet("strategic-insight-analyst", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	flagValues := make(map[string]*string, len(fields))
+	for _, f := range fields {
+		flagValues[f.env] = fs.String(f.flagName(), "", "overrides "+f.env)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	setFlags := make(map[string]bool)
+	fs.Visit(func(fl *flag.Flag) { setFlags[fl.Name] = true })
+
+	var fileValues map[string]string
+	if *configFile != "" {
+		var err error
+		if fileValues, err = readConfigFile(*configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var errs []error
+	for _, f := range fields {
+		raw, ok := f.def, f.def != ""
+		if v, found := fileValues[f.env]; found {
+			raw, ok = v, true
+		}
+		if v, found := os.LookupEnv(f.env); found && v != "" {
+			raw, ok = v, true
+		}
+		if setFlags[f.flagName()] {
+			raw, ok = *flagValues[f.env], true
+		}
+		if !ok || raw == "" {
+			if f.required {
+				errs = append(errs, fmt.Errorf("%s is required", f.env))
+			}
+			continue
+		}
+		if err := f.set(raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", f.env, err))
+		}
+	}
+	errs = append(errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return nil, nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return cfg, fs.Args(), nil
 }
 
-func LoadConfig() {
-	// ...
+var searchLanguagePattern = regexp.MustCompile(`^[a-z_]+$`)
+
+func (c *Config) validate() []error {
+	var errs []error
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT: %q is not a valid port", c.Server.Port))
+	}
+	if u, err := url.Parse(c.LLM.HFAPIURL); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("HF_API_URL: %q is not an absolute URL", c.LLM.HFAPIURL))
+	}
+	if c.Storage.SupabaseURL != "" {
+		if u, err := url.Parse(c.Storage.SupabaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("SUPABASE_URL: %q is not an absolute URL", c.Storage.SupabaseURL))
+		}
+	}
+	nonNegative := map[string]float64{
+		"RATE_LIMIT_API_RPS":         c.RateLimit.APIRPS,
+		"RATE_LIMIT_LLM_PER_MINUTE":  c.RateLimit.LLMPerMinute,
+		"LLM_DAILY_CALL_LIMIT":       float64(c.Quota.DailyCalls),
+		"LLM_DAILY_TOKEN_LIMIT":      float64(c.Quota.DailyTokens),
+		"LLM_MONTHLY_CALL_LIMIT":     float64(c.Quota.MonthlyCalls),
+		"LLM_MONTHLY_TOKEN_LIMIT":    float64(c.Quota.MonthlyTokens),
+		"LLM_PROMPT_COST_PER_1K":     c.LLM.PromptCostPer1K,
+		"LLM_COMPLETION_COST_PER_1K": c.LLM.CompletionCostPer1K,
+		"CORS_MAX_AGE":               float64(c.CORS.MaxAge),
+		"HSTS_MAX_AGE":               float64(c.CORS.HSTSMaxAge),
+		"SERVER_READ_HEADER_TIMEOUT": float64(c.Server.ReadHeaderTimeout),
+		"SERVER_READ_TIMEOUT":        float64(c.Server.ReadTimeout),
+		"SERVER_WRITE_TIMEOUT":       float64(c.Server.WriteTimeout),
+		"SERVER_IDLE_TIMEOUT":        float64(c.Server.IdleTimeout),
+		"SERVER_SHUTDOWN_TIMEOUT":    float64(c.Server.ShutdownTimeout),
+		"RECONCILE_INTERVAL":         float64(c.Reconcile.Interval),
+		"RECONCILE_GRACE_PERIOD":     float64(c.Reconcile.GracePeriod),
+		"TRASH_RETENTION":            float64(c.Trash.Retention),
+		"TRASH_PURGE_INTERVAL":       float64(c.Trash.PurgeInterval),
+	}
+	for key, v := range nonNegative {
+		if v < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", key))
+		}
+	}
+	// A bucket holding no tokens rejects every request, as does an
+	// upload limit of nothing.
+	for key, v := range map[string]int64{
+		"RATE_LIMIT_API_BURST":     int64(c.RateLimit.APIBurst),
+		"RATE_LIMIT_LLM_BURST":     int64(c.RateLimit.LLMBurst),
+		"STORAGE_MAX_UPLOAD_BYTES": c.Storage.MaxUploadBytes,
+	} {
+		if v < 1 {
+			errs = append(errs, fmt.Errorf("%s must be at least 1", key))
+		}
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: %q is not a log level", c.Logging.Level))
+	}
+	if c.Logging.Format != "json" && c.Logging.Format != "text" {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT: %q must be json or text", c.Logging.Format))
+	}
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("OTEL_TRACES_EXPORTER: %q must be none, stdout or otlp", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("OTEL_TRACES_SAMPLE_RATIO must be between 0 and 1"))
+	}
+	if !searchLanguagePattern.MatchString(c.Search.Language) {
+		errs = append(errs, fmt.Errorf("SEARCH_LANGUAGE: %q is not a text search configuration name", c.Search.Language))
+	}
+	if c.Storage.SignedURLTTL <= 0 || c.Storage.SignedURLMaxTTL < c.Storage.SignedURLTTL {
+		errs = append(errs, fmt.Errorf("STORAGE_SIGNED_URL_TTL must be positive and at most STORAGE_SIGNED_URL_MAX_TTL"))
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			if c.CORS.AllowCredentials {
+				errs = append(errs, fmt.Errorf("CORS_ALLOWED_ORIGINS: \"*\" cannot be used with CORS_ALLOW_CREDENTIALS"))
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("CORS_ALLOWED_ORIGINS: %q is not an origin", origin))
+		}
+	}
+	return errs
+}
+
+// Log prints the effective configuration with secrets redacted.
+func (c *Config) Log() {
+	for _, f := range configFields(reflect.ValueOf(c).Elem()) {
+		slog.Info("config", "key", f.env, "value", fmt.Sprint(f.value.Interface()))
+	}
+}
+
+type configField struct {
+	env      string
+	def      string
+	required bool
+	value    reflect.Value
+}
+
+func (f configField) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(f.env), "_", "-")
+}
+
+func (f configField) set(raw string) error {
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		f.value.SetBool(b)
+	case reflect.Int64:
+		if f.value.Type() == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(raw)
+			if err != nil {
+				return fmt.Errorf("%q is not a duration", raw)
+			}
+			f.value.SetInt(int64(d))
+			return nil
+		}
+		fallthrough
+	case reflect.Int:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		f.value.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		f.value.SetFloat(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config field kind %s", f.value.Kind())
+	}
+	return nil
+}
+
+// configFields walks v and returns every field carrying an env tag.
+func configFields(v reflect.Value) []configField {
+	var fields []configField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf, fv := t.Field(i), v.Field(i)
+		if env := sf.Tag.Get("env"); env != "" {
+			fields = append(fields, configField{
+				env:      env,
+				def:      sf.Tag.Get("default"),
+				required: sf.Tag.Get("required") == "true",
+				value:    fv,
+			})
+			continue
+		}
+		if fv.Kind() == reflect.Struct {
+			fields = append(fields, configFields(fv)...)
+		}
+	}
+	return fields
+}
+
+// readConfigFile reads a flat file of KEY: value pairs using the same keys
+// as the environment variables.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %v", err)
+	}
+
+	raw := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format (want .yaml, .yml or .toml)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %v", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, v := range raw {
+		if list, ok := v.([]interface{}); ok {
+			parts := make([]string, len(list))
+			for i, item := range list {
+				parts[i] = fmt.Sprint(item)
+			}
+			values[strings.ToUpper(key)] = strings.Join(parts, ",")
+			continue
+		}
+		values[strings.ToUpper(key)] = fmt.Sprint(v)
+	}
+	return values, nil
 }
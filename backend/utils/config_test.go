@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSecret = "s3cr3t-value"
+
+// setRequiredEnv sets every required setting, and clears those the tests
+// expect to come from elsewhere.
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+	for key, value := range map[string]string{
+		"DATABASE_URL":              "postgres://app:" + testSecret + "@db/app",
+		"FIREBASE_SERVICE_ACCOUNT":  "{}",
+		"SUPABASE_URL":              "https://project.supabase.co",
+		"SUPABASE_SERVICE_ROLE_KEY": testSecret,
+		"SUPABASE_BUCKET":           "documents",
+		"HF_API_TOKEN":              testSecret,
+		"CONFIG_FILE":               "",
+		"PORT":                      "",
+		"LOG_LEVEL":                 "",
+		"CORS_ALLOWED_ORIGINS":      "",
+	} {
+		t.Setenv(key, value)
+	}
+}
+
+// writeConfigFile writes content to a config file named name and returns
+// its path.
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	yamlFile := "PORT: 8081\nLOG_LEVEL: warn\n"
+	tests := []struct {
+		name      string
+		file      string // YAML config file content; none if empty
+		env       string // PORT in the environment
+		args      []string
+		wantPort  string
+		wantLevel string
+	}{
+		{name: "defaults", wantPort: "8080", wantLevel: "info"},
+		{name: "file over defaults", file: yamlFile, wantPort: "8081", wantLevel: "warn"},
+		{name: "environment over file", file: yamlFile, env: "8082", wantPort: "8082", wantLevel: "warn"},
+		{name: "flag over environment", file: yamlFile, env: "8082", args: []string{"-port", "8083"},
+			wantPort: "8083", wantLevel: "warn"},
+		{name: "empty environment is unset", file: yamlFile, env: "", wantPort: "8081", wantLevel: "warn"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("PORT", tt.env)
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, "config.yaml", tt.file)}, args...)
+			}
+			cfg, _, err := LoadConfig(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Port != tt.wantPort || cfg.Logging.Level != tt.wantLevel {
+				t.Errorf("port = %q, log level = %q, want %q, %q",
+					cfg.Server.Port, cfg.Logging.Level, tt.wantPort, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileFormats(t *testing.T) {
+	setRequiredEnv(t)
+	path := writeConfigFile(t, "config.toml",
+		"CORS_ALLOWED_ORIGINS = [\"https://a.example.com\", \"https://b.example.com\"]\ntrash_retention = \"24h\"\n")
+	cfg, rest, err := LoadConfig([]string{"-config", path, "migrate", "up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(cfg.CORS.AllowedOrigins, " "); got != "https://a.example.com https://b.example.com" {
+		t.Errorf("allowed origins = %q", got)
+	}
+	if cfg.Trash.Retention.String() != "24h0m0s" {
+		t.Errorf("trash retention = %v, want 24h from a lowercase key", cfg.Trash.Retention)
+	}
+	if strings.Join(rest, " ") != "migrate up" {
+		t.Errorf("positional args = %q", rest)
+	}
+
+	if _, _, err := LoadConfig([]string{"-config", writeConfigFile(t, "config.ini", "PORT=1")}); err == nil {
+		t.Error("unsupported config file format accepted")
+	}
+}
+
+func TestLoadConfigReportsEveryError(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("HF_API_TOKEN", "")
+	t.Setenv("PORT", "http")
+	t.Setenv("RATE_LIMIT_API_BURST", "0")
+	t.Setenv("TRASH_RETENTION", "a month")
+	t.Setenv("LOG_FORMAT", "xml")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "*")
+
+	_, _, err := LoadConfig(nil)
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	for _, want := range []string{
+		"HF_API_TOKEN is required",
+		`PORT: "http" is not a valid port`,
+		"RATE_LIMIT_API_BURST must be at least 1",
+		`TRASH_RETENTION: "a month" is not a duration`,
+		`LOG_FORMAT: "xml" must be json or text`,
+		`"*" cannot be used with CORS_ALLOW_CREDENTIALS`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not report %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestSecretIsNeverPrinted(t *testing.T) {
+	s := Secret(testSecret)
+	if s.Value() != testSecret {
+		t.Errorf("Value() = %q", s.Value())
+	}
+	holder := struct{ Key Secret }{s}
+	data, err := json.Marshal(holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := map[string]string{
+		"%v":         fmt.Sprintf("%v", s),
+		"%s":         fmt.Sprintf("%s", s),
+		"%q":         fmt.Sprintf("%q", s),
+		"%#v":        fmt.Sprintf("%#v", s),
+		"%+v struct": fmt.Sprintf("%+v", holder),
+		"%#v struct": fmt.Sprintf("%#v", holder),
+		"json":       string(data),
+	}
+	for format, out := range outputs {
+		if strings.Contains(out, testSecret) || !strings.Contains(out, "[REDACTED]") {
+			t.Errorf("%s: %s", format, out)
+		}
+	}
+	if got := fmt.Sprint(Secret("")); got != "" {
+		t.Errorf("empty secret prints as %q, want nothing", got)
+	}
+
+	setRequiredEnv(t)
+	cfg, _, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := fmt.Sprintf("%+v", cfg); strings.Contains(out, testSecret) {
+		t.Errorf("config prints a secret: %s", out)
+	}
+}
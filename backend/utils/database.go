@@ -3,21 +3,26 @@ package utils
 import (
 	"database/sql"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 var dbConnection *sql.DB // Changed from sql.DB to *sql.DB
 
-func InitDB() (*sql.DB, error) { // Changed return type to *sql.DB
-	connStr := os.Getenv("DATABASE_URL")
+func InitDB(cfg DatabaseConfig) (*sql.DB, error) { // Changed return type to *sql.DB
+	connStr := cfg.URL.Value()
 	if connStr == "" {
-		return nil, fmt.Errorf("DATABASE_URL not set in .env")
+		return nil, fmt.Errorf("DATABASE_URL not set")
 	}
 
-	db, err := sql.Open("postgres", connStr)
+	// Every query gets a span; rows and session resets would only add
+	// noise.
+	db, err := otelsql.Open("postgres", connStr,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitRows: true, OmitConnResetSession: true}))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %v", err)
 	}
@@ -28,7 +33,7 @@ func InitDB() (*sql.DB, error) { // Changed return type to *sql.DB
 	}
 
 	dbConnection = db
-	log.Println("✅ Connected to Neon PostgreSQL database")
+	slog.Info("connected to PostgreSQL database")
 	return db, nil
 }
 
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// NewLogger builds the process logger: JSON (or text) records that carry
+// the attributes stored in the request context, with sensitive values
+// redacted.
+func NewLogger(cfg LogConfig, w io.Writer) *slog.Logger {
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.Level)) // validated by LoadConfig
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+
+	var h slog.Handler = slog.NewJSONHandler(w, opts)
+	if cfg.Format == "text" {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(contextHandler{h})
+}
+
+type logAttrsKey struct{}
+
+// logAttrs collects attributes that every log line of a request should
+// carry. It is mutable because some (like the user ID) are only known
+// after inner middleware has run.
+type logAttrs struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// WithLogAttrs returns a context whose log lines will include attrs, plus
+// anything later added with AddLogAttrs.
+func WithLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	return context.WithValue(ctx, logAttrsKey{}, &logAttrs{attrs: attrs})
+}
+
+// AddLogAttrs attaches attrs to every later log line of the request that
+// owns ctx.
+func AddLogAttrs(ctx context.Context, attrs ...slog.Attr) {
+	if la, ok := ctx.Value(logAttrsKey{}).(*logAttrs); ok {
+		la.mu.Lock()
+		la.attrs = append(la.attrs, attrs...)
+		la.mu.Unlock()
+	}
+}
+
+// LogAttr returns the value of the named request log attribute.
+func LogAttr(ctx context.Context, key string) string {
+	if la, ok := ctx.Value(logAttrsKey{}).(*logAttrs); ok {
+		la.mu.Lock()
+		defer la.mu.Unlock()
+		for _, a := range la.attrs {
+			if a.Key == key {
+				return a.Value.String()
+			}
+		}
+	}
+	return ""
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if la, ok := ctx.Value(logAttrsKey{}).(*logAttrs); ok {
+		la.mu.Lock()
+		r.AddAttrs(la.attrs...)
+		la.mu.Unlock()
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// contentKeys hold document text or LLM traffic and are never logged.
+var contentKeys = map[string]bool{
+	"content":  true,
+	"text":     true,
+	"chunk":    true,
+	"prompt":   true,
+	"response": true,
+	"question": true,
+	"message":  true,
+}
+
+// secretKeys hold credentials and are never logged.
+var secretKeys = map[string]bool{
+	"token":         true,
+	"id_token":      true,
+	"authorization": true,
+	"password":      true,
+	"api_key":       true,
+	"apikey":        true,
+}
+
+var (
+	emailPattern  = regexp.MustCompile(`([A-Za-z0-9._%+-])[A-Za-z0-9._%+-]*@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+	jwtPattern    = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)
+	bearerPattern = regexp.MustCompile(`(?i)(bearer\s+)[^\s"']+`)
+)
+
+// Redact masks email addresses and bearer/JWT tokens inside s.
+func Redact(s string) string {
+	s = jwtPattern.ReplaceAllString(s, "[REDACTED_TOKEN]")
+	s = bearerPattern.ReplaceAllString(s, "${1}[REDACTED]")
+	return emailPattern.ReplaceAllString(s, "${1}***@${2}")
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	switch {
+	case a.Key == slog.MessageKey:
+		// msg is free text, possibly from log.Printf; scrub patterns.
+	case secretKeys[key]:
+		return slog.String(a.Key, "[REDACTED]")
+	case contentKeys[key]:
+		return slog.String(a.Key, fmt.Sprintf("[REDACTED %d bytes]", len(a.Value.String())))
+	}
+	if a.Value.Kind() == slog.KindString || a.Value.Kind() == slog.KindAny {
+		return slog.String(a.Key, Redact(a.Value.String()))
+	}
+	return a
+}
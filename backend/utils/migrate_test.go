@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"os"
+	"testing"
+)
+
+// TestSharedChunksMigration runs migration 0013 up, down and up again over
+// seeded chunks, one set of them shared by a duplicate. It needs a scratch
+// Postgres database named by TEST_DATABASE_URL and is skipped without one.
+func TestSharedChunksMigration(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	exec := func(query string, args ...any) {
+		t.Helper()
+		if _, err := db.ExecContext(ctx, query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	// Seed under the schema before 0013: chunks belong to a version.
+	if err := MigrateUp(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateDown(ctx, db, 1); err != nil {
+		t.Fatal(err)
+	}
+	exec("INSERT INTO users (id, email) VALUES ('migrate-test', 'migrate-test@example.com')")
+	t.Cleanup(func() {
+		db.ExecContext(ctx, "DELETE FROM users WHERE id = 'migrate-test'")
+		db.ExecContext(ctx, `
+			DELETE FROM chunk_sets s
+			WHERE NOT EXISTS (SELECT 1 FROM document_versions v WHERE v.chunk_set_id = s.id)`)
+	})
+	for _, id := range []string{"migrate-a", "migrate-b"} {
+		exec(`INSERT INTO documents (id, user_id, file_name, storage_path, version)
+			VALUES ($1, 'migrate-test', 'a.txt', 'blob-a', 1)`, id)
+		exec(`INSERT INTO document_versions (document_id, version, file_name, storage_path)
+			VALUES ($1, 1, 'a.txt', 'blob-a')`, id)
+	}
+	exec("UPDATE documents SET version = 2 WHERE id = 'migrate-a'")
+	exec(`INSERT INTO document_versions (document_id, version, file_name, storage_path)
+		VALUES ('migrate-a', 2, 'a.txt', 'blob-a2')`)
+	for version, n := range map[int]int{1: 2, 2: 3} {
+		for i := range n {
+			exec(`INSERT INTO document_chunks (id, document_id, version, chunk_index, content)
+				VALUES ($1, 'migrate-a', $2, $3, 'text')`, fmt.Sprintf("migrate-a-%d-%d", version, i), version, i)
+		}
+	}
+	seed := map[string]int{"migrate-a/1": 2, "migrate-a/2": 3}
+
+	// countsUp and countsDown return the number of chunks of each seeded
+	// version under the schema after and before 0013.
+	countsUp := func() map[string]int {
+		t.Helper()
+		return chunkCounts(t, db, `
+			SELECT v.document_id || '/' || v.version, COUNT(*) FROM document_chunks c
+			JOIN document_versions v ON v.chunk_set_id = c.chunk_set_id
+			WHERE v.document_id LIKE 'migrate-%' GROUP BY 1`)
+	}
+	countsDown := func() map[string]int {
+		t.Helper()
+		return chunkCounts(t, db, `
+			SELECT document_id || '/' || version, COUNT(*) FROM document_chunks
+			WHERE document_id LIKE 'migrate-%' GROUP BY 1`)
+	}
+
+	if err := MigrateUp(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if got := countsUp(); !maps.Equal(got, seed) {
+		t.Fatalf("after up: chunks = %v, want %v", got, seed)
+	}
+	// migrate-b becomes a duplicate sharing migrate-a's latest chunks.
+	exec(`UPDATE document_versions SET chunk_set_id = (
+			SELECT chunk_set_id FROM document_versions WHERE document_id = 'migrate-a' AND version = 2)
+		WHERE document_id = 'migrate-b'`)
+	exec(`UPDATE chunk_sets SET ref_count = 2 WHERE id = (
+			SELECT chunk_set_id FROM document_versions WHERE document_id = 'migrate-b')`)
+	shared := map[string]int{"migrate-a/1": 2, "migrate-a/2": 3, "migrate-b/1": 3}
+
+	if err := MigrateDown(ctx, db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := countsDown(); !maps.Equal(got, shared) {
+		t.Fatalf("after down: chunks = %v, want %v", got, shared)
+	}
+	if err := MigrateUp(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if got := countsUp(); !maps.Equal(got, shared) {
+		t.Fatalf("after second up: chunks = %v, want %v", got, shared)
+	}
+}
+
+func chunkCounts(t *testing.T, db *sql.DB, query string) map[string]int {
+	t.Helper()
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var key string
+		var n int
+		if err := rows.Scan(&key, &n); err != nil {
+			t.Fatal(err)
+		}
+		counts[key] = n
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return counts
+}
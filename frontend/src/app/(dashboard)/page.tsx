@@ -1,6 +1,7 @@
 "use client";
 
-import { useQuery } from "@tanstack/react-query";
+import { useState } from "react";
+import { useInfiniteQuery, useQuery } from "@tanstack/react-query";
 import { Button } from "@/components/ui/button";
 import { Card } from "@/components/ui/card";
 import { Skeleton } from "@/components/ui/skeleton";
@@ -11,14 +12,27 @@ import { useAuthContext } from "@/context/AuthContext";
 import { redirect } from "next/navigation";
 import { format } from "date-fns";
 import { getAuth } from "firebase/auth";
+import type { Folder, Page, Tag } from "@/types";
+import { formatFileSize } from "@/lib/utils";
 
 interface Document {
   id: string;
   fileName: string;
   storageUrl: string;
   uploadedAt: string;
+  size: number;
+  pageCount: number;
+  status: string;
+  tags?: string[];
 }
 
+const sortOptions = {
+  newest: { sort: "uploaded", order: "desc" },
+  oldest: { sort: "uploaded", order: "asc" },
+  name: { sort: "name", order: "asc" },
+  largest: { sort: "size", order: "desc" },
+} as const;
+
 export default function DashboardPage() {
   const { user } = useAuthContext();
 
@@ -27,29 +41,103 @@ export default function DashboardPage() {
   }
 
   const auth = getAuth();
-  const { data: documents, isLoading } = useQuery<Document[]>({
-    queryKey: ["documents"],
-    queryFn: async () => {
-      const user = auth.currentUser;
-      if (!user) throw new Error("Not authenticated");
-      const token = await user.getIdToken();
-      const { data } = await api.get("/api/documents", {
-        headers: { Authorization: `Bearer ${token}` },
-      });
-      return data;
-    },
+  const [sortBy, setSortBy] = useState<keyof typeof sortOptions>("newest");
+  const [folderId, setFolderId] = useState("");
+  const [tag, setTag] = useState("");
+
+  async function authGet<T>(url: string) {
+    const user = auth.currentUser;
+    if (!user) throw new Error("Not authenticated");
+    const token = await user.getIdToken();
+    const { data } = await api.get<T>(url, {
+      headers: { Authorization: `Bearer ${token}` },
+    });
+    return data;
+  }
+  const { data: folders } = useQuery({
+    queryKey: ["folders"],
+    queryFn: () => authGet<Folder[]>("/api/folders"),
+  });
+  const { data: tags } = useQuery({
+    queryKey: ["tags"],
+    queryFn: () => authGet<Tag[]>("/api/tags"),
   });
 
+  const filters = {
+    ...(folderId && { folderId, includeSubfolders: true }),
+    ...(tag && { tag }),
+  };
+  const { data, isLoading, hasNextPage, fetchNextPage, isFetchingNextPage } =
+    useInfiniteQuery({
+      queryKey: ["documents", sortBy, filters],
+      initialPageParam: undefined as string | undefined,
+      queryFn: async ({ pageParam }) => {
+        const user = auth.currentUser;
+        if (!user) throw new Error("Not authenticated");
+        const token = await user.getIdToken();
+        const { data } = await api.get<Page<Document>>("/api/documents", {
+          params: { ...sortOptions[sortBy], ...filters, cursor: pageParam },
+          headers: { Authorization: `Bearer ${token}` },
+        });
+        return data;
+      },
+      getNextPageParam: (lastPage) => lastPage.nextCursor,
+    });
+  const documents = data?.pages.flatMap((page) => page.items);
+
   return (
     <div className="container mx-auto py-8">
       <div className="mb-6 flex items-center justify-between">
         <h1 className="text-2xl font-bold">Your Documents</h1>
-        <Link href="/upload">
-          <Button>
-            <Plus className="mr-2 h-4 w-4" />
-            Upload Document
-          </Button>
-        </Link>
+        <div className="flex items-center gap-3">
+          {folders && folders.length > 0 && (
+            <select
+              value={folderId}
+              onChange={(e) => setFolderId(e.target.value)}
+              className="rounded-md border px-3 py-2 text-sm"
+              aria-label="Filter by folder"
+            >
+              <option value="">All folders</option>
+              {folders.map((folder) => (
+                <option key={folder.id} value={folder.id}>
+                  {folder.name}
+                </option>
+              ))}
+            </select>
+          )}
+          {tags && tags.length > 0 && (
+            <select
+              value={tag}
+              onChange={(e) => setTag(e.target.value)}
+              className="rounded-md border px-3 py-2 text-sm"
+              aria-label="Filter by tag"
+            >
+              <option value="">All tags</option>
+              {tags.map((t) => (
+                <option key={t.id} value={t.name}>
+                  {t.name} ({t.documentCount})
+                </option>
+              ))}
+            </select>
+          )}
+          <select
+            value={sortBy}
+            onChange={(e) => setSortBy(e.target.value as keyof typeof sortOptions)}
+            className="rounded-md border px-3 py-2 text-sm"
+            aria-label="Sort documents"
+          >
+            <option value="newest">Newest first</option>
+            <option value="oldest">Oldest first</option>
+            <option value="name">Name</option>
+            <option value="largest">Largest first</option>
+          </select>
+          <Link href="/upload">
+            <Button>
+              <Plus className="mr-2 h-4 w-4" />
+              Upload Document
+            </Button>
+          </Link>
+        </div>
       </div>
 
       {isLoading ? (
@@ -59,25 +147,53 @@ export default function DashboardPage() {
           ))}
         </div>
       ) : documents && documents.length > 0 ? (
-        <div className="grid gap-4 md:grid-cols-2 lg:grid-cols-3">
-          {documents.map((doc) => (
-            <Link key={doc.id} href={`/documents/${doc.id}`}>
-              <Card className="h-full p-4 transition-colors hover:bg-gray-50">
-                <div className="flex items-center gap-3">
-                  <div className="rounded-lg bg-blue-100 p-3">
-                    <FileUp className="h-5 w-5 text-blue-600" />
+        <>
+          <div className="grid gap-4 md:grid-cols-2 lg:grid-cols-3">
+            {documents.map((doc) => (
+              <Link key={doc.id} href={`/documents/${doc.id}`}>
+                <Card className="h-full p-4 transition-colors hover:bg-gray-50">
+                  <div className="flex items-center gap-3">
+                    <div className="rounded-lg bg-blue-100 p-3">
+                      <FileUp className="h-5 w-5 text-blue-600" />
+                    </div>
+                    <div>
+                      <h3 className="font-medium">{doc.fileName}</h3>
+                      <p className="text-sm text-gray-500">
+                        {format(new Date(doc.uploadedAt), "MMM dd, yyyy")}
+                        {" · "}
+                        {formatFileSize(doc.size)}
+                        {doc.pageCount > 0 && ` · ${doc.pageCount} pages`}
+                      </p>
+                      {doc.status === "failed" && (
+                        <p className="text-sm text-red-600">Processing failed</p>
+                      )}
+                      {doc.tags && doc.tags.length > 0 && (
+                        <div className="mt-1 flex flex-wrap gap-1">
+                          {doc.tags.map((t) => (
+                            <span key={t} className="rounded bg-gray-100 px-2 py-0.5 text-xs text-gray-700">
+                              {t}
+                            </span>
+                          ))}
+                        </div>
+                      )}
+                    </div>
                   </div>
-                  <div>
-                    <h3 className="font-medium">{doc.fileName}</h3>
-                    <p className="text-sm text-gray-500">
-                      {format(new Date(doc.uploadedAt), "MMM dd, yyyy")}
-                    </p>
-                  </div>
-                </div>
-              </Card>
-            </Link>
-          ))}
-        </div>
+                </Card>
+              </Link>
+            ))}
+          </div>
+          {hasNextPage && (
+            <div className="mt-6 text-center">
+              <Button
+                variant="outline"
+                onClick={() => fetchNextPage()}
+                disabled={isFetchingNextPage}
+              >
+                {isFetchingNextPage ? "Loading..." : "Load more"}
+              </Button>
+            </div>
+          )}
+        </>
       ) : (
         <Card className="p-8 text-center">
           <div className="mx-auto max-w-md">
@@ -1,10 +1,10 @@
 "use client";
 
 import { useParams } from "next/navigation";
-import { useQuery } from "@tanstack/react-query";
+import { useQuery, useQueryClient } from "@tanstack/react-query";
 import { Button } from "@/components/ui/button";
 import { Card } from "@/components/ui/card";
-import { FileUp, Trash2 } from "lucide-react";
+import { Download, FileUp, History, Pencil, RefreshCw, Trash2 } from "lucide-react";
 import api from "@/lib/api";
 import { format } from "date-fns";
 import Link from "next/link";
@@ -13,13 +13,26 @@ import { useToast } from "@/components/ui/toaster";
 import DocumentChat from "@/components/DocumentChat";
 import { Skeleton } from "@/components/ui/skeleton";
 import { auth } from "@/lib/firebase";
-import { useEffect, useState } from "react";
+import { useEffect, useRef, useState } from "react";
+import { formatFileSize } from "@/lib/utils";
 
 interface Document {
   id: string;
   fileName: string;
   storageUrl: string;
   uploadedAt: string;
+  size: number;
+  mimeType: string;
+  pageCount: number;
+  language: string;
+  wordCount: number;
+  status: string;
+  processingError?: string;
+  version: number;
+  tags?: string[];
+  suggestedTags?: string[];
+  description?: string;
+  metadata?: Record<string, string>;
 }
 
 export default function DocumentPage() {
@@ -27,7 +40,8 @@ export default function DocumentPage() {
   const router = useRouter();
   const toast = useToast();
   const [previewContent, setPreviewContent] = useState<string | null>(null);
-  const [isPdf, setIsPdf] = useState(false);
+  const queryClient = useQueryClient();
+  const versionInput = useRef<HTMLInputElement>(null);
 
   const { data: document, isLoading } = useQuery<Document>({
     queryKey: ["document", id],
@@ -42,26 +56,57 @@ export default function DocumentPage() {
     },
   });
 
-  const backendBaseUrl = process.env.NEXT_PUBLIC_API_URL?.replace(/\/api$/, "");
-  const fileUrl = document?.storageUrl?.startsWith("http")
-    ? document.storageUrl
-    : `${backendBaseUrl}${document?.storageUrl || ""}`;
+  const isPdf = document?.fileName.toLowerCase().endsWith(".pdf") ?? false;
+  const [fileUrl, setFileUrl] = useState<string | null>(null);
 
+  // The bucket is private: the original is fetched through the API with
+  // the user's token and previewed from an object URL.
   useEffect(() => {
-    if (fileUrl && fileUrl !== backendBaseUrl && !isPdf) {
-      fetch(fileUrl)
-        .then((res) => res.text())
-        .then(setPreviewContent)
-        .catch(() => setPreviewContent("Failed to load preview."));
-    }
-  }, [fileUrl, isPdf]);
+    if (!document) return;
+    let objectUrl: string | null = null;
+    let cancelled = false;
+    (async () => {
+      try {
+        const user = auth.currentUser;
+        if (!user) throw new Error("Not authenticated");
+        const token = await user.getIdToken();
+        const { data } = await api.get<Blob>(`/api/documents/${id}/download`, {
+          params: { disposition: "inline", version: document.version },
+          headers: { Authorization: `Bearer ${token}` },
+          responseType: "blob",
+        });
+        if (cancelled) return;
+        if (isPdf) {
+          objectUrl = URL.createObjectURL(data);
+          setFileUrl(objectUrl);
+        } else {
+          setPreviewContent(await data.text());
+        }
+      } catch {
+        if (!cancelled) setPreviewContent("Failed to load preview.");
+      }
+    })();
+    return () => {
+      cancelled = true;
+      if (objectUrl) URL.revokeObjectURL(objectUrl);
+    };
+  }, [id, document?.version, isPdf]);
 
-  useEffect(() => {
-    if (document?.storageUrl) {
-      const url = document.storageUrl;
-      setIsPdf(url.toLowerCase().endsWith(".pdf"));
+  async function handleDownload() {
+    try {
+      const user = auth.currentUser;
+      if (!user) throw new Error("Not authenticated");
+      const token = await user.getIdToken();
+      const { data } = await api.post<{ url: string }>(
+        `/api/documents/${id}/download-url`,
+        null,
+        { headers: { Authorization: `Bearer ${token}` } },
+      );
+      window.location.assign(data.url);
+    } catch (error) {
+      toast("Download failed: " + (error instanceof Error ? error.message : "Unknown error"));
     }
-  }, [document?.storageUrl]);
+  }
 
   async function handleDelete() {
     try {
@@ -71,13 +116,83 @@ export default function DocumentPage() {
       await api.delete(`/api/documents/${id}`, {
         headers: { Authorization: `Bearer ${token}` },
       });
-      toast("Document deleted: Your document has been deleted successfully");
+      toast("Document moved to trash: It can be restored from the trash");
       router.push("/");
     } catch (error) {
       toast("Delete failed: " + (error instanceof Error ? error.message : "Unknown error"));
     }
   }
 
+  async function handleNewVersion(file: File) {
+    try {
+      const user = auth.currentUser;
+      if (!user) throw new Error("Not authenticated");
+      const token = await user.getIdToken();
+      const formData = new FormData();
+      formData.append("document", file);
+      const { data } = await api.post(`/api/documents/${id}/versions`, formData, {
+        headers: {
+          "Content-Type": "multipart/form-data",
+          Authorization: `Bearer ${token}`,
+        },
+      });
+      toast(`Version ${data.version} uploaded`);
+      queryClient.invalidateQueries({ queryKey: ["document", id] });
+    } catch (error) {
+      toast("Upload failed: " + (error instanceof Error ? error.message : "Unknown error"));
+    }
+  }
+
+  async function handleRename() {
+    const fileName = window.prompt("New file name", document?.fileName);
+    if (!fileName || fileName === document?.fileName) return;
+    try {
+      const user = auth.currentUser;
+      if (!user) throw new Error("Not authenticated");
+      const token = await user.getIdToken();
+      await api.patch(
+        `/api/documents/${id}`,
+        { fileName },
+        { headers: { Authorization: `Bearer ${token}` } },
+      );
+      queryClient.invalidateQueries({ queryKey: ["document", id] });
+    } catch (error) {
+      toast("Rename failed: " + (error instanceof Error ? error.message : "Unknown error"));
+    }
+  }
+
+  async function handleReprocess() {
+    try {
+      const user = auth.currentUser;
+      if (!user) throw new Error("Not authenticated");
+      const token = await user.getIdToken();
+      await api.post(`/api/documents/${id}/reprocess`, {}, {
+        headers: { Authorization: `Bearer ${token}` },
+      });
+      toast("Document reprocessed");
+      queryClient.invalidateQueries({ queryKey: ["document", id] });
+    } catch (error) {
+      toast("Reprocessing failed: " + (error instanceof Error ? error.message : "Unknown error"));
+    }
+  }
+
+  async function handleAddTag(name: string) {
+    try {
+      const user = auth.currentUser;
+      if (!user) throw new Error("Not authenticated");
+      const token = await user.getIdToken();
+      await api.post(
+        "/api/documents/tags",
+        { documentIds: [id], add: [name] },
+        { headers: { Authorization: `Bearer ${token}` } },
+      );
+      queryClient.invalidateQueries({ queryKey: ["document", id] });
+      queryClient.invalidateQueries({ queryKey: ["tags"] });
+    } catch (error) {
+      toast("Tagging failed: " + (error instanceof Error ? error.message : "Unknown error"));
+    }
+  }
+
   if (isLoading) {
     return (
       <div className="container mx-auto py-8">
@@ -109,13 +224,73 @@ export default function DocumentPage() {
         <div>
           <h1 className="text-2xl font-bold">{document.fileName}</h1>
           <p className="text-gray-500">
+            {document.version > 1 && `Version ${document.version} · `}
             Uploaded on {format(new Date(document.uploadedAt), "MMM dd, yyyy")}
+            {" · "}
+            {formatFileSize(document.size)}
+            {document.pageCount > 0 && ` · ${document.pageCount} pages`}
+            {document.wordCount > 0 && ` · ${document.wordCount.toLocaleString()} words`}
+            {document.language && ` · ${document.language.toUpperCase()}`}
           </p>
+          {document.status === "failed" && (
+            <p className="mt-1 text-sm text-red-600">
+              Processing failed{document.processingError ? `: ${document.processingError}` : ""}
+            </p>
+          )}
+          {document.description && <p className="mt-1 text-sm text-gray-700">{document.description}</p>}
+          <div className="mt-2 flex flex-wrap gap-1">
+            {document.tags?.map((t) => (
+              <span key={t} className="rounded bg-gray-100 px-2 py-0.5 text-xs text-gray-700">
+                {t}
+              </span>
+            ))}
+            {document.suggestedTags
+              ?.filter((t) => !document.tags?.some((applied) => applied.toLowerCase() === t.toLowerCase()))
+              .map((t) => (
+                <button
+                  key={t}
+                  onClick={() => handleAddTag(t)}
+                  className="rounded border border-dashed px-2 py-0.5 text-xs text-gray-500 hover:bg-gray-50"
+                  title="Suggested tag: click to apply"
+                >
+                  + {t}
+                </button>
+              ))}
+          </div>
+        </div>
+        <div className="flex gap-2">
+          <input
+            ref={versionInput}
+            type="file"
+            accept=".pdf,.txt"
+            className="hidden"
+            onChange={(e) => {
+              const file = e.target.files?.[0];
+              e.target.value = "";
+              if (file) handleNewVersion(file);
+            }}
+          />
+          <Button variant="outline" onClick={handleDownload}>
+            <Download className="mr-2 h-4 w-4" />
+            Download
+          </Button>
+          <Button variant="outline" onClick={handleRename}>
+            <Pencil className="mr-2 h-4 w-4" />
+            Rename
+          </Button>
+          <Button variant="outline" onClick={handleReprocess}>
+            <RefreshCw className="mr-2 h-4 w-4" />
+            Reprocess
+          </Button>
+          <Button variant="outline" onClick={() => versionInput.current?.click()}>
+            <History className="mr-2 h-4 w-4" />
+            New version
+          </Button>
+          <Button variant="destructive" onClick={handleDelete}>
+            <Trash2 className="mr-2 h-4 w-4" />
+            Delete
+          </Button>
         </div>
-        <Button variant="destructive" onClick={handleDelete}>
-          <Trash2 className="mr-2 h-4 w-4" />
-          Delete
-        </Button>
       </div>
 
       <div className="mt-8 grid gap-8 lg:grid-cols-2">
@@ -125,9 +300,7 @@ export default function DocumentPage() {
             <h2 className="text-xl font-semibold">Document Preview</h2>
           </div>
           <div className="h-96 overflow-auto rounded-lg border p-4">
-            {!fileUrl || fileUrl === backendBaseUrl ? (
-              <p className="text-gray-500">No preview available</p>
-            ) : isPdf ? (
+            {isPdf && fileUrl ? (
               <iframe
                 src={fileUrl}
                 width="100%"
@@ -48,7 +48,7 @@ export default function RegisterPage() {
       const userCredential = await createUserWithEmailAndPassword(auth, values.email, values.password);
       const user = userCredential.user;
       const idToken = await user.getIdToken();
-      await api.post("/register", { uid: user.uid, email: user.email }, {
+      await api.post("/register", null, {
         headers: {
           Authorization: "Bearer " + idToken,
         },
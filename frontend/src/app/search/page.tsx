@@ -0,0 +1,137 @@
+"use client";
+
+import { useState } from "react";
+import { useInfiniteQuery } from "@tanstack/react-query";
+import Link from "next/link";
+import { Search } from "lucide-react";
+import { Button } from "@/components/ui/button";
+import { Card } from "@/components/ui/card";
+import { Input } from "@/components/ui/input";
+import { Skeleton } from "@/components/ui/skeleton";
+import api from "@/lib/api";
+import { auth } from "@/lib/firebase";
+import type { Page } from "@/types";
+
+interface SnippetPart {
+  text: string;
+  match?: boolean;
+}
+
+interface SearchResult {
+  document: { id: string; fileName: string };
+  rank: number;
+  matches: number;
+  hits: {
+    chunkIndex: number;
+    start: number;
+    page?: number;
+    rank: number;
+    snippet: SnippetPart[];
+  }[];
+}
+
+export default function SearchPage() {
+  const [input, setInput] = useState("");
+  const [query, setQuery] = useState("");
+
+  const { data, isLoading, hasNextPage, fetchNextPage, isFetchingNextPage } =
+    useInfiniteQuery({
+      queryKey: ["search", query],
+      enabled: query !== "",
+      initialPageParam: undefined as string | undefined,
+      queryFn: async ({ pageParam }) => {
+        const user = auth.currentUser;
+        if (!user) throw new Error("Not authenticated");
+        const token = await user.getIdToken();
+        const { data } = await api.get<Page<SearchResult>>("/api/search", {
+          params: { q: query, cursor: pageParam },
+          headers: { Authorization: `Bearer ${token}` },
+        });
+        return data;
+      },
+      getNextPageParam: (lastPage) => lastPage.nextCursor,
+    });
+  const results = data?.pages.flatMap((page) => page.items);
+
+  return (
+    <div className="container mx-auto py-8">
+      <h1 className="mb-6 text-2xl font-bold">Search Documents</h1>
+      <form
+        className="mb-6 flex gap-2"
+        onSubmit={(e) => {
+          e.preventDefault();
+          setQuery(input.trim());
+        }}
+      >
+        <Input
+          value={input}
+          onChange={(e) => setInput(e.target.value)}
+          placeholder='e.g. "supply chain" risk'
+          maxLength={256}
+        />
+        <Button type="submit" disabled={!input.trim()}>
+          <Search className="mr-2 h-4 w-4" />
+          Search
+        </Button>
+      </form>
+
+      {query && isLoading ? (
+        <div className="space-y-4">
+          {[...Array(3)].map((_, i) => (
+            <Skeleton key={i} className="h-24 w-full" />
+          ))}
+        </div>
+      ) : results && results.length > 0 ? (
+        <div className="space-y-4">
+          <p className="text-sm text-gray-500">
+            {data?.pages[0].total} matching document
+            {data?.pages[0].total === 1 ? "" : "s"}
+          </p>
+          {results.map((result) => (
+            <Link key={result.document.id} href={`/documents/${result.document.id}`}>
+              <Card className="mb-4 p-4 transition-colors hover:bg-gray-50">
+                <div className="flex items-baseline justify-between">
+                  <h3 className="font-medium">{result.document.fileName}</h3>
+                  <span className="text-sm text-gray-500">
+                    {result.matches} matching section{result.matches === 1 ? "" : "s"}
+                  </span>
+                </div>
+                {result.hits.map((hit) => (
+                  <p key={hit.chunkIndex} className="mt-2 text-sm text-gray-700">
+                    <span className="mr-2 text-xs text-gray-400">
+                      {hit.page ? `Page ${hit.page}` : `Section ${hit.chunkIndex + 1}`}
+                    </span>
+                    {hit.snippet.map((part, i) =>
+                      part.match ? (
+                        <mark key={i} className="bg-yellow-200">
+                          {part.text}
+                        </mark>
+                      ) : (
+                        <span key={i}>{part.text}</span>
+                      )
+                    )}
+                  </p>
+                ))}
+              </Card>
+            </Link>
+          ))}
+          {hasNextPage && (
+            <div className="text-center">
+              <Button
+                variant="outline"
+                onClick={() => fetchNextPage()}
+                disabled={isFetchingNextPage}
+              >
+                {isFetchingNextPage ? "Loading..." : "Load more"}
+              </Button>
+            </div>
+          )}
+        </div>
+      ) : query ? (
+        <Card className="p-8 text-center text-gray-500">
+          No documents match &ldquo;{query}&rdquo;
+        </Card>
+      ) : null}
+    </div>
+  );
+}
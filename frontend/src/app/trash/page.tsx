@@ -0,0 +1,121 @@
+"use client";
+
+import { useInfiniteQuery, useQueryClient } from "@tanstack/react-query";
+import { format } from "date-fns";
+import { RotateCcw, Trash2 } from "lucide-react";
+import { Button } from "@/components/ui/button";
+import { Card } from "@/components/ui/card";
+import { Skeleton } from "@/components/ui/skeleton";
+import { useToast } from "@/components/ui/toaster";
+import api from "@/lib/api";
+import { auth } from "@/lib/firebase";
+import { formatFileSize } from "@/lib/utils";
+import type { Page } from "@/types";
+
+interface TrashedDocument {
+  id: string;
+  fileName: string;
+  size: number;
+  deletedAt: string;
+  purgeAt: string;
+}
+
+export default function TrashPage() {
+  const toast = useToast();
+  const queryClient = useQueryClient();
+
+  async function authHeaders() {
+    const user = auth.currentUser;
+    if (!user) throw new Error("Not authenticated");
+    return { Authorization: `Bearer ${await user.getIdToken()}` };
+  }
+
+  const { data, isLoading, hasNextPage, fetchNextPage, isFetchingNextPage } =
+    useInfiniteQuery({
+      queryKey: ["trash"],
+      initialPageParam: undefined as string | undefined,
+      queryFn: async ({ pageParam }) => {
+        const { data } = await api.get<Page<TrashedDocument>>("/api/trash", {
+          params: { cursor: pageParam },
+          headers: await authHeaders(),
+        });
+        return data;
+      },
+      getNextPageParam: (lastPage) => lastPage.nextCursor,
+    });
+  const documents = data?.pages.flatMap((page) => page.items);
+
+  async function handleRestore(doc: TrashedDocument) {
+    try {
+      await api.post(`/api/documents/${doc.id}/restore`, null, { headers: await authHeaders() });
+      toast(`${doc.fileName} restored`);
+      queryClient.invalidateQueries({ queryKey: ["trash"] });
+      queryClient.invalidateQueries({ queryKey: ["documents"] });
+    } catch (error) {
+      toast("Restore failed: " + (error instanceof Error ? error.message : "Unknown error"));
+    }
+  }
+
+  async function handlePurge(doc: TrashedDocument) {
+    if (!window.confirm(`Delete ${doc.fileName} permanently? This cannot be undone.`)) return;
+    try {
+      await api.delete(`/api/trash/${doc.id}`, { headers: await authHeaders() });
+      queryClient.invalidateQueries({ queryKey: ["trash"] });
+    } catch (error) {
+      toast("Delete failed: " + (error instanceof Error ? error.message : "Unknown error"));
+    }
+  }
+
+  return (
+    <div className="container mx-auto py-8">
+      <h1 className="mb-2 text-2xl font-bold">Trash</h1>
+      <p className="mb-6 text-sm text-gray-500">
+        Deleted documents can be restored until they are removed for good.
+      </p>
+
+      {isLoading ? (
+        <div className="space-y-4">
+          {[...Array(3)].map((_, i) => (
+            <Skeleton key={i} className="h-16 w-full" />
+          ))}
+        </div>
+      ) : documents && documents.length > 0 ? (
+        <div className="space-y-3">
+          {documents.map((doc) => (
+            <Card key={doc.id} className="flex items-center justify-between p-4">
+              <div>
+                <h3 className="font-medium">{doc.fileName}</h3>
+                <p className="text-sm text-gray-500">
+                  Deleted {format(new Date(doc.deletedAt), "MMM dd, yyyy")}
+                  {" · "}
+                  {formatFileSize(doc.size)}
+                  {" · "}
+                  Removed for good on {format(new Date(doc.purgeAt), "MMM dd, yyyy")}
+                </p>
+              </div>
+              <div className="flex gap-2">
+                <Button variant="outline" size="sm" onClick={() => handleRestore(doc)}>
+                  <RotateCcw className="mr-2 h-4 w-4" />
+                  Restore
+                </Button>
+                <Button variant="destructive" size="sm" onClick={() => handlePurge(doc)}>
+                  <Trash2 className="mr-2 h-4 w-4" />
+                  Delete forever
+                </Button>
+              </div>
+            </Card>
+          ))}
+          {hasNextPage && (
+            <div className="mt-6 text-center">
+              <Button variant="outline" onClick={() => fetchNextPage()} disabled={isFetchingNextPage}>
+                {isFetchingNextPage ? "Loading..." : "Load more"}
+              </Button>
+            </div>
+          )}
+        </div>
+      ) : (
+        <Card className="p-8 text-center text-gray-500">The trash is empty</Card>
+      )}
+    </div>
+  );
+}
@@ -54,7 +54,11 @@ export default function UploadPage() {
         },
       });
 
-      toast("Document uploaded: Your document has been uploaded successfully");
+      if (data.duplicateOf === data.id) {
+        toast("Already uploaded: Opening your existing copy of this document");
+      } else {
+        toast("Document uploaded: Your document has been uploaded successfully");
+      }
       router.push(`/documents/${data.id}`);
     } catch (error) {
       toast("Upload failed: " + (error instanceof Error ? error.message : "Unknown error"));
@@ -9,6 +9,7 @@ import { useQuery } from "@tanstack/react-query";
 import api from "@/lib/api";
 import { useToast } from "@/components/ui/toaster";
 import { auth } from "@/lib/firebase";
+import { fetchChatHistory } from "@/hooks/api/useChatHistory";
 
 interface ChatMessage {
   id: string;
@@ -24,15 +25,7 @@ export default function DocumentChat({ documentId }: { documentId: string }) {
 
   const { data: chatHistory, refetch } = useQuery<ChatMessage[]>({
     queryKey: ["chatHistory", documentId],
-    queryFn: async () => {
-      const user = auth.currentUser;
-      if (!user) throw new Error("Not authenticated");
-      const token = await user.getIdToken();
-      const { data } = await api.get(`/api/documents/${documentId}/chat/history`, {
-        headers: { Authorization: `Bearer ${token}` },
-      });
-      return data;
-    },
+    queryFn: () => fetchChatHistory(documentId),
   });
 
   async function handleSendMessage() {
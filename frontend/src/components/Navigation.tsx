@@ -28,6 +28,12 @@ export default function Navigation() {
         </Link>
         {user && (
           <div className="flex items-center gap-4">
+            <Link href="/search" className="text-sm text-gray-600 hover:text-gray-900">
+              Search
+            </Link>
+            <Link href="/trash" className="text-sm text-gray-600 hover:text-gray-900">
+              Trash
+            </Link>
             <span className="text-sm text-gray-600">{user.email}</span>
             <Button variant="outline" size="sm" onClick={handleSignOut}>
               Sign out
@@ -1,12 +1,41 @@
 import { useQuery } from "@tanstack/react-query";
 import api from "@/lib/api";
+import { auth } from "@/lib/firebase";
+import type { Page } from "@/types";
+
+export interface ChatHistoryItem {
+  id: string;
+  type: "user" | "ai";
+  content: string;
+  timestamp: string;
+}
+
+// fetchChatHistory follows the history's cursors and returns the whole
+// conversation, oldest first.
+export async function fetchChatHistory(documentId: string): Promise<ChatHistoryItem[]> {
+  const user = auth.currentUser;
+  if (!user) throw new Error("Not authenticated");
+  const token = await user.getIdToken();
+
+  const items: ChatHistoryItem[] = [];
+  let cursor: string | undefined;
+  do {
+    const { data } = await api.get<Page<ChatHistoryItem>>(
+      `/api/documents/${documentId}/chat/history`,
+      {
+        params: { limit: 100, cursor },
+        headers: { Authorization: `Bearer ${token}` },
+      }
+    );
+    items.push(...data.items);
+    cursor = data.nextCursor;
+  } while (cursor);
+  return items;
+}
 
 export function useChatHistory(documentId: string) {
   return useQuery({
     queryKey: ["chatHistory", documentId],
-    queryFn: async () => {
-      const { data } = await api.get(`/api/documents/${documentId}/chat/history`);
-      return data;
-    },
+    queryFn: () => fetchChatHistory(documentId),
   });
-}
\ No newline at end of file
+}
@@ -1,12 +1,31 @@
-import { useQuery } from "@tanstack/react-query";
+import { useInfiniteQuery } from "@tanstack/react-query";
 import api from "@/lib/api";
+import { auth } from "@/lib/firebase";
+import type { DocumentListParams, Page } from "@/types";
 
-export function useDocuments() {
-  return useQuery({
-    queryKey: ["documents"],
-    queryFn: async () => {
-      const { data } = await api.get("/api/documents");
+export interface DocumentSummary {
+  id: string;
+  fileName: string;
+  storageUrl: string;
+  uploadedAt: string;
+  size: number;
+  status: string;
+}
+
+export function useDocuments(params: DocumentListParams = {}) {
+  return useInfiniteQuery({
+    queryKey: ["documents", params],
+    initialPageParam: undefined as string | undefined,
+    queryFn: async ({ pageParam }) => {
+      const user = auth.currentUser;
+      if (!user) throw new Error("Not authenticated");
+      const token = await user.getIdToken();
+      const { data } = await api.get<Page<DocumentSummary>>("/api/documents", {
+        params: { ...params, cursor: pageParam },
+        headers: { Authorization: `Bearer ${token}` },
+      });
       return data;
     },
+    getNextPageParam: (lastPage) => lastPage.nextCursor,
   });
-}
\ No newline at end of file
+}
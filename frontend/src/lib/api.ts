@@ -1,7 +1,32 @@
 import axios from "axios";
 
+// ApiError carries the server's error envelope:
+// {"error": {"code", "message", "requestId", "details"}}.
+export class ApiError extends Error {
+  constructor(
+    message: string,
+    public status: number,
+    public code: string,
+    public requestId?: string,
+    public details?: Record<string, unknown>
+  ) {
+    super(message);
+    this.name = "ApiError";
+  }
+}
+
 const api = axios.create({
   baseURL: process.env.NEXT_PUBLIC_API_URL,
 });
 
-export default api;
\ No newline at end of file
+api.interceptors.response.use(undefined, (error) => {
+  const body = error?.response?.data?.error;
+  if (body && typeof body.code === "string") {
+    return Promise.reject(
+      new ApiError(body.message, error.response.status, body.code, body.requestId, body.details)
+    );
+  }
+  return Promise.reject(error);
+});
+
+export default api;
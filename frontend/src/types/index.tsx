@@ -27,4 +27,41 @@ export interface User {
     message_type: "user" | "ai";
     message_content: string;
     timestamp: string;
-  }
\ No newline at end of file
+  }
+
+  // One page of a paginated listing. Pass nextCursor back as the cursor
+  // parameter to fetch the following page; it is absent on the last page.
+  export interface Page<T> {
+    items: T[];
+    nextCursor?: string;
+    total: number;
+  }
+
+  export interface Folder {
+    id: string;
+    parentId?: string;
+    name: string;
+    createdAt: string;
+    documentCount: number;
+  }
+
+  export interface Tag {
+    id: string;
+    name: string;
+    createdAt: string;
+    documentCount: number;
+  }
+
+  export interface DocumentListParams {
+    sort?: "name" | "uploaded" | "size";
+    order?: "asc" | "desc";
+    type?: string;
+    status?: "processing" | "ready" | "failed";
+    from?: string;
+    to?: string;
+    folderId?: string;
+    includeSubfolders?: boolean;
+    unfiled?: boolean;
+    tag?: string;
+    limit?: number;
+  }